@@ -0,0 +1,30 @@
+// Command bookkeeper extracts metadata and pages from books in various formats
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+	"github.com/biblioteca/bookkeeper/src/commands"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+func main() {
+	// Interrupt the command on Ctrl+C, a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+
+	code := commands.RunContext(ctx, os.Args[1:], commands.Env{
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		Version: version,
+	})
+	stop()
+	archives.Close()
+	os.Exit(code)
+}
@@ -2,11 +2,13 @@
 package archives
 
 import (
+	"context"
 	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,8 +21,38 @@ type Page struct {
 	Path   string `json:"path"`
 	Width  int    `json:"width"`
 	Height int    `json:"height"`
+
+	// ID of the chapter in the EPUB manifest
+	ID string `json:"id,omitempty"`
+
+	// Title of the chapter, from the table of contents
+	Title string `json:"title,omitempty"`
+
+	// MediaType of the chapter, e.g. "application/xhtml+xml"
+	MediaType string `json:"media_type,omitempty"`
+
+	// Method used to extract a page of a PDF file, PageRendered or PageEmbedded
+	Method string `json:"method,omitempty"`
+
+	// Type of the page of a comic book archive in the Pages of ComicInfo.xml,
+	// e.g. "FrontCover", "Story" or "Advertisement"
+	Type string `json:"type,omitempty"`
+
+	// DoublePage is set for the pages of ComicInfo.xml holding a spread
+	DoublePage bool `json:"double_page,omitempty"`
+
+	// Bookmark of the page in ComicInfo.xml, e.g. the title of a chapter
+	Bookmark string `json:"bookmark,omitempty"`
 }
 
+// Methods used to extract the pages of PDF files
+const (
+	// PageRendered pages are rendered by PDFium with ExtractOptions.Render
+	PageRendered = "rendered"
+	// PageEmbedded pages are the JPEG image of the page, copied unchanged
+	PageEmbedded = "embedded"
+)
+
 // BookInfo holds metadata about a book
 type BookInfo struct {
 	// Book title
@@ -48,53 +80,143 @@ type BookInfo struct {
 	// Authors of the book
 	Authors []string `json:"authors,omitempty"`
 
+	// Contributors are the people credited for the book with their role,
+	// when the metadata tells them apart
+	Contributors []Contributor `json:"contributors,omitempty"`
+
 	// Publisher of the book
 	Publisher string `json:"publisher,omitempty"`
 
 	// PublishedDate is the publication date as a string
 	PublishedDate string `json:"published_date,omitempty"`
 
+	// ISBN of the book, as found in the metadata
+	ISBN string `json:"isbn,omitempty"`
+
 	// Keywords or subjects associated with the book
 	Keywords []string `json:"keywords,omitempty"`
+
+	// Comic holds the other fields of ComicInfo.xml, for the books that have one
+	Comic *Comic `json:"comic,omitempty"`
 }
 
 // GetBookInfo retrieves metadata from a book archive or PDF file
 func GetBookInfo(path string) (BookInfo, error) {
-	ext := filepath.Ext(path)
-	switch strings.ToLower(ext) {
-	case ".cbz", ".cbr", ".cb7", ".cbt":
-		return getBookInfoCB(path)
-	case ".pdf":
-		return getBookInfoPDF(path)
-	case ".epub":
-		return getBookInfoEPUB(path)
-	default:
+	return GetBookInfoContext(context.Background(), path)
+}
+
+// GetBookInfoContext retrieves metadata from a book archive or PDF file, it
+// stops reading the book and returns the context error when ctx is done
+func GetBookInfoContext(ctx context.Context, path string) (BookInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return BookInfo{}, err
+	}
+
+	info, ok := detect(path).(InfoHandler)
+	if !ok {
 		return BookInfo{}, fmt.Errorf("we don't know how to open this archive '%s'", path)
 	}
+	return info.GetBookInfo(ctx, path)
+}
+
+// ExtractOptions control the extraction of the pages of a book, the zero
+// value extracts every page
+type ExtractOptions struct {
+	// Render is used for the pages of PDF files
+	Render RenderOptions
+
+	// FirstPage and LastPage select the pages to extract, numbered from 1 and
+	// included: the images of comic book archives in natural order, the pages
+	// of PDF files, the pages or chapters of EPUB books. Zero means the first,
+	// respectively the last, page of the book.
+	FirstPage int
+	LastPage  int
+
+	// EmbeddedImages copies the JPEG image of the PDF pages made of a single
+	// image, such as scanned comics, instead of rendering them: the image keeps
+	// its quality and size, Render is not applied. The other pages are rendered.
+	EmbeddedImages bool
+
+	// SkipAdvertisements leaves out the images of comic book archives marked
+	// as Advertisement in ComicInfo.xml. The ones marked as Deleted are always
+	// left out. The page range still counts them.
+	SkipAdvertisements bool
+
+	// Limits protect the extraction of comic book archives and EPUB books
+	// against archive bombs, the default limits apply to the zero value
+	Limits ExtractLimits
+}
+
+// Validate checks the values of the options
+func (o ExtractOptions) Validate() error {
+	if err := o.Render.Validate(); err != nil {
+		return err
+	}
+	if o.FirstPage < 0 || o.LastPage < 0 || (o.LastPage > 0 && o.FirstPage > o.LastPage) {
+		return fmt.Errorf("invalid page range %d-%d", o.FirstPage, o.LastPage)
+	}
+	return nil
+}
+
+// pageRange returns the indexes of the selected pages of a book with count
+// pages, from first included to last excluded
+func (o ExtractOptions) pageRange(count int) (first, last int, err error) {
+	if o.FirstPage > count {
+		return 0, 0, pageOutOfRange(o.FirstPage-1, count)
+	}
+	first, last = max(o.FirstPage-1, 0), count
+	if o.LastPage > 0 {
+		last = min(o.LastPage, count)
+	}
+	return first, last, nil
+}
+
+// pageOutOfRange is returned for the page at index of a book with count pages
+func pageOutOfRange(index, count int) error {
+	return fmt.Errorf("page %d is out of range, the book has %d pages", index+1, count)
 }
 
 // Extract extracts files from an archive or PDF into the output folder
 // Returns a list of extracted pages with file paths and dimensions
 func Extract(inputFile, outputFolder string) ([]Page, error) {
-	// Create output folder if it doesn't exist
-	if err := os.MkdirAll(outputFolder, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create output folder: %w", err)
+	return ExtractContext(context.Background(), inputFile, outputFolder)
+}
+
+// ExtractContext extracts files from an archive or PDF into the output folder,
+// it stops between two pages and returns the context error when ctx is done.
+// The pages already written are left in the output folder.
+func ExtractContext(ctx context.Context, inputFile, outputFolder string) ([]Page, error) {
+	return ExtractWithOptionsContext(ctx, inputFile, outputFolder, ExtractOptions{})
+}
+
+// ExtractWithOptions is like Extract, with the rendering of PDF pages and
+// the pages to extract set by opts
+func ExtractWithOptions(inputFile, outputFolder string, opts ExtractOptions) ([]Page, error) {
+	return ExtractWithOptionsContext(context.Background(), inputFile, outputFolder, opts)
+}
+
+// ExtractWithOptionsContext is like ExtractContext, with the rendering of PDF
+// pages and the pages to extract set by opts
+func ExtractWithOptionsContext(ctx context.Context, inputFile, outputFolder string, opts ExtractOptions) ([]Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
 
 	// Determine file type and extract accordingly
-	ext := strings.ToLower(filepath.Ext(inputFile))
-	var extractedPages []Page
-	var err error
+	extractor, ok := detect(inputFile).(ExtractHandler)
+	if !ok {
+		return nil, fmt.Errorf("unsupported file format: %s", filepath.Ext(inputFile))
+	}
 
-	switch ext {
-	case ".cbz", ".cbr", ".cb7", ".cbt":
-		extractedPages, err = extractArchive(inputFile, outputFolder)
-	case ".pdf":
-		extractedPages, err = extractPDF(inputFile, outputFolder)
-	default:
-		return nil, fmt.Errorf("unsupported file format: %s", ext)
+	// Create output folder if it doesn't exist
+	if err := os.MkdirAll(outputFolder, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output folder: %w", err)
 	}
 
+	extractedPages, err := extractor.Extract(ctx, inputFile, outputFolder, opts)
 	if err != nil {
 		return nil, fmt.Errorf("extraction failed: %w", err)
 	}
@@ -102,6 +224,64 @@ func Extract(inputFile, outputFolder string) ([]Page, error) {
 	return extractedPages, nil
 }
 
+// ExtractPage opens a single page of a book, index starting at 0: the image
+// of comic book archives in natural order, or the page of a PDF file rendered
+// like Extract does. The page is read from the archive, or rendered, without
+// extracting the others. The reader must be closed.
+func ExtractPage(path string, index int) (io.ReadCloser, Page, error) {
+	return ExtractPageContext(context.Background(), path, index)
+}
+
+// ExtractPageContext is like ExtractPage, it stops reading the book and
+// returns the context error when ctx is done
+func ExtractPageContext(ctx context.Context, path string, index int) (io.ReadCloser, Page, error) {
+	return ExtractPageWithOptionsContext(ctx, path, index, ExtractOptions{})
+}
+
+// ExtractPageWithOptions is like ExtractPage, with the rendering of PDF pages
+// set by opts. The page range of opts is ignored.
+func ExtractPageWithOptions(path string, index int, opts ExtractOptions) (io.ReadCloser, Page, error) {
+	return ExtractPageWithOptionsContext(context.Background(), path, index, opts)
+}
+
+// ExtractPageWithOptionsContext is like ExtractPageContext, with the rendering
+// of PDF pages set by opts. The page range of opts is ignored.
+func ExtractPageWithOptionsContext(ctx context.Context, path string, index int, opts ExtractOptions) (io.ReadCloser, Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Page{}, err
+	}
+	opts.FirstPage, opts.LastPage = 0, 0
+	if err := opts.Validate(); err != nil {
+		return nil, Page{}, err
+	}
+
+	extractor, ok := detect(path).(PageHandler)
+	if !ok {
+		return nil, Page{}, fmt.Errorf("extracting a single page is not supported for this format: %s", filepath.Ext(path))
+	}
+	return extractor.ExtractPage(ctx, path, index, opts)
+}
+
+// WriteBookInfo stores the metadata of a book in the book itself, for the
+// formats that support it (see CapabilityWrite)
+func WriteBookInfo(path string, info BookInfo) error {
+	return WriteBookInfoContext(context.Background(), path, info)
+}
+
+// WriteBookInfoContext is like WriteBookInfo, it returns the context error
+// when ctx is done before the book is written
+func WriteBookInfoContext(ctx context.Context, path string, info BookInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	writer, ok := detect(path).(WriteHandler)
+	if !ok {
+		return fmt.Errorf("writing metadata is not supported for this format: %s", filepath.Ext(path))
+	}
+	return writer.WriteBookInfo(ctx, path, info)
+}
+
 // getImageDimensions returns the width and height of an image file
 func getImageDimensions(imagePath string) (int, int, error) {
 	file, err := os.Open(imagePath)
@@ -118,13 +298,19 @@ func getImageDimensions(imagePath string) (int, int, error) {
 	return config.Width, config.Height, nil
 }
 
-// getFileExtension extracts and normalizes the file extension from a path
+// getFileExtension extracts and normalizes the file extension from a path.
+// Zipped FictionBooks keep both extensions, i.e. "fb2.zip".
 func getFileExtension(path string) string {
-	return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	lower := strings.ToLower(path)
+	if strings.HasSuffix(lower, ".fb2.zip") {
+		return "fb2.zip"
+	}
+	return strings.TrimPrefix(filepath.Ext(lower), ".")
 }
 
-// IsValidBookFile checks if the file has a valid book file extension
+// IsValidBookFile checks if the file has the extension of a registered format,
+// use Detect to recognize files from their content
 func IsValidBookFile(path string) bool {
-	ext := getFileExtension(path)
-	return ext == "cbz" || ext == "cbr" || ext == "cb7" || ext == "cbt" || ext == "pdf" || ext == "epub"
+	_, ok := HandlerFor(path)
+	return ok
 }
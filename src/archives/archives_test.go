@@ -1,10 +1,14 @@
 package archives
 
 import (
+	"context"
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/gen2brain/go-unarr"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetBookInfoIntegration(t *testing.T) {
@@ -52,6 +56,10 @@ func TestIsValidBookFileExtended(t *testing.T) {
 		{"PDF", "book.pdf", true},
 		{"EPUB", "book.epub", true},
 		{"EPUB uppercase", "BOOK.EPUB", true},
+		{"MOBI", "book.mobi", true},
+		{"AZW", "book.azw", true},
+		{"AZW3", "book.AZW3", true},
+		{"PRC", "book.prc", true},
 		{"TXT", "readme.txt", false},
 		{"DOCX", "document.docx", false},
 		{"Empty", "", false},
@@ -64,3 +72,42 @@ func TestIsValidBookFileExtended(t *testing.T) {
 		})
 	}
 }
+
+func TestContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	fixtures := []string{"dummy_book.cbz", "testfile.pdf", "pg11-images-3.epub"}
+	for _, fixture := range fixtures {
+		t.Run(fixture, func(t *testing.T) {
+			path := filepath.Join("..", "..", "fixtures", fixture)
+
+			_, err := GetBookInfoContext(ctx, path)
+			assert.ErrorIs(t, err, context.Canceled)
+
+			_, err = ExtractContext(ctx, path, t.TempDir())
+			assert.ErrorIs(t, err, context.Canceled)
+
+			_, err = ExtractCoverContext(ctx, path, filepath.Join(t.TempDir(), "cover.jpg"))
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+	}
+}
+
+func TestListCBCancelled(t *testing.T) {
+	a, err := unarr.NewArchive(filepath.Join("..", "..", "fixtures", "dummy_book.cbz"))
+	require.NoError(t, err)
+	defer a.Close()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err = listCB(ctx, a)
+	assert.ErrorIs(t, err, context.Canceled, "should stop listing the entries")
+
+	root, err := os.OpenRoot(t.TempDir())
+	require.NoError(t, err)
+	defer root.Close()
+	guard := &extractGuard{limits: ExtractLimits{}.withDefaults()}
+	_, err = extractEntriesCB(ctx, a, root, guard, func(string) bool { return true })
+	assert.ErrorIs(t, err, context.Canceled, "should stop extracting the entries")
+}
@@ -1,12 +1,19 @@
 package archives
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"image"
+	"io"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/gen2brain/go-unarr"
+	"github.com/hekmon/go-comicinfo"
 	"github.com/maruel/natural"
 )
 
@@ -28,64 +35,212 @@ func getPagesCountCB(names []string) int {
 	return pages
 }
 
-func getBookInfoCB(path string) (BookInfo, error) {
+// listCB lists the entries of an archive, stopping when ctx is done
+func listCB(ctx context.Context, a *unarr.Archive) ([]string, error) {
+	var names []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := a.Entry()
+		if errors.Is(err, io.EOF) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, a.Name())
+	}
+}
+
+func getBookInfoCB(ctx context.Context, path string) (BookInfo, error) {
 	a, err := unarr.NewArchive(path)
 	if err != nil {
 		return BookInfo{}, err
 	}
 	defer a.Close()
 
-	names, err := a.List()
+	names, err := listCB(ctx, a)
 	if err != nil {
 		return BookInfo{}, err
 	}
 
-	// Check for ComicInfo.xml anywhere in the archive and parse it if found
+	// The first metadata found and parsed wins: ComicInfo.xml anywhere in the
+	// archive, then CoMet.xml, then the ComicBookInfo of the ZIP comment
+	sources := []struct {
+		read  func() ([]byte, bool)
+		parse func([]byte) (BookInfo, error)
+	}{
+		{func() ([]byte, bool) { return readComicInfoCB(a, names) }, parseComicInfo},
+		{func() ([]byte, bool) { return readEntryCB(a, names, coMetName) }, parseCoMet},
+		{func() ([]byte, bool) { return readComicBookInfoZIP(path) }, parseComicBookInfo},
+	}
+	for _, source := range sources {
+		data, ok := source.read()
+		if !ok {
+			continue
+		}
+		bookInfo, err := source.parse(data)
+		if err != nil {
+			// If we can't parse the metadata, continue with the next source
+			continue
+		}
+		// Count pages if not already set in the metadata
+		if bookInfo.Pages == 0 {
+			bookInfo.Pages = getPagesCountCB(names)
+		}
+		return bookInfo, nil
+	}
+
+	// Fallback: count pages and use filename as title
+	pages := getPagesCountCB(names)
+	title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return BookInfo{Title: title, Pages: pages}, nil
+}
+
+// readComicInfoCB reads the first ComicInfo.xml found anywhere in the archive
+func readComicInfoCB(a *unarr.Archive, names []string) ([]byte, bool) {
+	return readEntryCB(a, names, comicInfoName)
+}
+
+// readEntryCB reads the first entry named base, whatever its case, found
+// anywhere in the archive
+func readEntryCB(a *unarr.Archive, names []string, base string) ([]byte, bool) {
 	for _, name := range names {
-		if strings.EqualFold(filepath.Base(name), "ComicInfo.xml") {
-			err := a.EntryFor(name)
-			if err != nil {
-				// If we can't find ComicInfo.xml, continue with fallback
-				break
-			}
+		if !strings.EqualFold(filepath.Base(name), base) {
+			continue
+		}
+		if err := a.EntryFor(name); err != nil {
+			return nil, false
+		}
+		data, err := a.ReadAll()
+		if err != nil {
+			return nil, false
+		}
+		return data, true
+	}
+	return nil, false
+}
+
+// comicPagesCB matches the Pages of ComicInfo.xml to the images of the
+// archive in natural order, their Image being the index of the image
+func comicPagesCB(data []byte, images []string) map[string]ComicPage {
+	doc, err := decodeComicInfo(data)
+	if err != nil {
+		return nil
+	}
+	pages := map[string]ComicPage{}
+	for _, page := range doc.pages {
+		if page.Image < 0 || page.Image >= len(images) {
+			continue
+		}
+		// The first page of an image wins
+		if _, ok := pages[images[page.Image]]; !ok {
+			pages[images[page.Image]] = page
+		}
+	}
+	return pages
+}
 
-			data, err := a.ReadAll()
-			if err != nil {
-				// If we can't read ComicInfo.xml, continue with fallback
-				break
-			}
+// setComicPage copies the attributes of the page in ComicInfo.xml, its
+// dimensions are used when the ones of the image are unknown
+func (p *Page) setComicPage(c ComicPage) {
+	p.Type, p.DoublePage, p.Bookmark = c.Type, c.DoublePage, c.Bookmark
+	if p.Width == 0 && p.Height == 0 {
+		p.Width, p.Height = c.ImageWidth, c.ImageHeight
+	}
+}
 
-			bookInfo, err := parseComicInfo(data)
-			if err != nil {
-				// If we can't parse ComicInfo.xml, continue with fallback
-				break
-			}
+// skipComicPage tells whether a page of ComicInfo.xml is left out of the
+// extraction: the deleted pages, and the advertisements when asked to
+func skipComicPage(c ComicPage, opts ExtractOptions) bool {
+	return strings.EqualFold(c.Type, string(comicinfo.PageTypeDeleted)) ||
+		(opts.SkipAdvertisements && strings.EqualFold(c.Type, string(comicinfo.PageTypeAdvertisement)))
+}
 
-			// Count pages if not already set in ComicInfo
-			if bookInfo.Pages == 0 {
-				bookInfo.Pages = getPagesCountCB(names)
-			}
+// getImageNamesCB returns the image entries of an archive in natural order
+func getImageNamesCB(names []string) []string {
+	var images []string
+	for _, n := range names {
+		if strings.HasSuffix(n, "/") || !validImage(n) {
+			continue
+		}
+		images = append(images, n)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return natural.Less(images[i], images[j])
+	})
+	return images
+}
 
-			return bookInfo, nil
+// getCoverCB returns the cover image of a comic book archive: the page marked
+// as FrontCover in ComicInfo.xml or the first image in natural order
+func getCoverCB(ctx context.Context, path string) ([]byte, error) {
+	a, err := unarr.NewArchive(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer a.Close()
+
+	names, err := listCB(ctx, a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive: %w", err)
+	}
+
+	images := getImageNamesCB(names)
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no image found in archive '%s'", path)
+	}
+
+	cover := images[0]
+	if data, ok := readComicInfoCB(a, names); ok {
+		if index, ok := comicInfoFrontCover(data); ok && index < len(images) {
+			cover = images[index]
 		}
 	}
 
-	// Fallback: count pages and use filename as title
-	pages := getPagesCountCB(names)
-	title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-	return BookInfo{Title: title, Pages: pages}, nil
+	if err := a.EntryFor(cover); err != nil {
+		return nil, fmt.Errorf("failed to find cover '%s': %w", cover, err)
+	}
+	data, err := a.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cover '%s': %w", cover, err)
+	}
+	return data, nil
 }
 
-// extractArchive extracts files from archive formats (CBZ, CBR, etc.)
-func extractArchive(inputFile, outputFolder string) ([]Page, error) {
+// extractArchive extracts files from archive formats (CBZ, CBR, etc.). The
+// other files are extracted along with the images selected by the page range
+// and the Pages of ComicInfo.xml.
+func extractArchive(ctx context.Context, inputFile, outputFolder string, opts ExtractOptions) ([]Page, error) {
+	selected, comicPages, err := selectPagesCB(ctx, inputFile, opts)
+	if err != nil {
+		return nil, err
+	}
+	keep := func(name string) bool { return !validImage(name) || selected[name] }
+
+	guard, err := newExtractGuard(inputFile, opts.Limits)
+	if err != nil {
+		return nil, err
+	}
+
 	archive, err := unarr.NewArchive(inputFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open archive: %w", err)
 	}
 	defer archive.Close()
 
+	if err := os.MkdirAll(outputFolder, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output folder: %w", err)
+	}
+	root, err := os.OpenRoot(outputFolder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output folder: %w", err)
+	}
+	defer root.Close()
+
 	// Extract all files to the output folder
-	extractedFiles, err := archive.Extract(outputFolder)
+	extractedFiles, err := extractEntriesCB(ctx, archive, root, guard, keep)
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract archive: %w", err)
 	}
@@ -116,11 +271,13 @@ func extractArchive(inputFile, outputFolder string) ([]Page, error) {
 			continue
 		}
 
-		pages = append(pages, Page{
+		page := Page{
 			Path:   cleanPath,
 			Width:  width,
 			Height: height,
-		})
+		}
+		page.setComicPage(comicPages[filePath])
+		pages = append(pages, page)
 	}
 
 	// Apply natural sorting for archive files
@@ -130,3 +287,195 @@ func extractArchive(inputFile, outputFolder string) ([]Page, error) {
 
 	return pages, nil
 }
+
+// selectPagesCB returns the images of an archive in the page range of opts
+// that are not left out by the Pages of ComicInfo.xml, and these pages by
+// image name
+func selectPagesCB(ctx context.Context, inputFile string, opts ExtractOptions) (map[string]bool, map[string]ComicPage, error) {
+	a, err := unarr.NewArchive(inputFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer a.Close()
+
+	names, err := listCB(ctx, a)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list archive: %w", err)
+	}
+	images := getImageNamesCB(names)
+	first, last, err := opts.pageRange(len(images))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var comicPages map[string]ComicPage
+	if data, ok := readComicInfoCB(a, names); ok {
+		comicPages = comicPagesCB(data, images)
+	}
+
+	selected := map[string]bool{}
+	for _, name := range images[first:last] {
+		if page, ok := comicPages[name]; !ok || !skipComicPage(page, opts) {
+			selected[name] = true
+		}
+	}
+	return selected, comicPages, nil
+}
+
+// extractPageCB opens the image of a page of a comic book archive, which is
+// read from the archive as the page is read
+func extractPageCB(ctx context.Context, path string, index int) (io.ReadCloser, Page, error) {
+	a, err := unarr.NewArchive(path)
+	if err != nil {
+		return nil, Page{}, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	names, err := listCB(ctx, a)
+	if err != nil {
+		a.Close()
+		return nil, Page{}, fmt.Errorf("failed to list archive: %w", err)
+	}
+	images := getImageNamesCB(names)
+	if index < 0 || index >= len(images) {
+		a.Close()
+		return nil, Page{}, pageOutOfRange(index, len(images))
+	}
+	var comicPages map[string]ComicPage
+	if data, ok := readComicInfoCB(a, names); ok {
+		comicPages = comicPagesCB(data, images)
+	}
+	if err := a.EntryFor(images[index]); err != nil {
+		a.Close()
+		return nil, Page{}, fmt.Errorf("failed to find page '%s': %w", images[index], err)
+	}
+
+	// The header read to find the dimensions is read again by the caller
+	r := &entryReaderCB{a: a, remaining: a.Size()}
+	var head bytes.Buffer
+	page := Page{Path: images[index]}
+	if config, _, err := image.DecodeConfig(io.TeeReader(r, &head)); err == nil {
+		page.Width, page.Height = config.Width, config.Height
+	}
+	page.setComicPage(comicPages[images[index]])
+	return readCloser{Reader: io.MultiReader(&head, r), Closer: a}, page, nil
+}
+
+// entryReaderCB reads the current entry of an archive, unarr fails to read
+// past the end of an entry
+type entryReaderCB struct {
+	a         *unarr.Archive
+	remaining int
+}
+
+func (r *entryReaderCB) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n := min(len(p), r.remaining)
+	if _, err := r.a.Read(p[:n]); err != nil {
+		return 0, err
+	}
+	r.remaining -= n
+	return n, nil
+}
+
+// readCloser closes Closer once Reader is read
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// extractEntriesCB writes the entries of the archive accepted by keep in the
+// output folder and returns their names. Each entry is checked by guard before
+// being read, the first one rejected stops the extraction. It stops between
+// two entries when ctx is done.
+func extractEntriesCB(ctx context.Context, a *unarr.Archive, root *os.Root, guard *extractGuard, keep func(name string) bool) ([]string, error) {
+	var names []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := a.Entry()
+		if errors.Is(err, io.EOF) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Name strips the leading "/" and "../" of the entry name, which the
+		// guard rejects from the raw name
+		rawName, name := a.RawName(), a.Name()
+		if strings.HasSuffix(rawName, "/") || !keep(name) {
+			continue
+		}
+		size := a.Size()
+		if err := guard.checkEntry(rawName, name, int64(size)); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if err := guard.writeEntry(root, filepath.ToSlash(name), int64(size), &entryReaderCB{a: a, remaining: size}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// comicHandler reads comic book archives: CBZ (ZIP), CBR (RAR), CB7 (7z) and
+// CBT (tar). The archive is opened by unarr whatever its extension, there is
+// a handler per extension to report the ones that do not match the content.
+type comicHandler struct {
+	ext string
+}
+
+func (h comicHandler) Name() string { return h.ext }
+
+func (h comicHandler) Extensions() []string { return []string{h.ext} }
+
+func (h comicHandler) Sniff(header []byte) bool {
+	switch h.ext {
+	case "cbz":
+		return isZIP(header)
+	case "cbr":
+		return isRAR(header)
+	case "cb7":
+		return is7z(header)
+	case "cbt":
+		return isTar(header)
+	default:
+		return false
+	}
+}
+
+func (comicHandler) GetBookInfo(ctx context.Context, path string) (BookInfo, error) {
+	return getBookInfoCB(ctx, path)
+}
+
+func (comicHandler) Extract(ctx context.Context, path, outputFolder string, opts ExtractOptions) ([]Page, error) {
+	return extractArchive(ctx, path, outputFolder, opts)
+}
+
+func (comicHandler) ExtractPage(ctx context.Context, path string, index int, _ ExtractOptions) (io.ReadCloser, Page, error) {
+	return extractPageCB(ctx, path, index)
+}
+
+func (comicHandler) ConvertToCBZ(ctx context.Context, path, outputFile string, _ ConvertOptions) (int, error) {
+	return convertArchiveToCBZ(ctx, path, outputFile)
+}
+
+func (comicHandler) GetCover(ctx context.Context, path string) (CoverImage, error) {
+	data, err := getCoverCB(ctx, path)
+	return CoverImage{Data: data}, err
+}
+
+// cbzHandler is the comic handler of ZIP archives, which can store metadata
+// in their ComicInfo.xml
+type cbzHandler struct {
+	comicHandler
+}
+
+func (cbzHandler) WriteBookInfo(ctx context.Context, path string, info BookInfo) error {
+	return WriteComicInfoContext(ctx, path, info)
+}
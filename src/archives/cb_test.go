@@ -1,6 +1,12 @@
 package archives
 
 import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -12,7 +18,7 @@ import (
 func TestGetBookInfoCBZ(t *testing.T) {
 	path := filepath.Join("..", "..", "fixtures", "Full of Fun", "Full_of_Fun_001__Decker_Pub._1957.08__c2c___soothsayr_Yoc.cbz")
 
-	book, err := getBookInfoCB(path)
+	book, err := getBookInfoCB(t.Context(), path)
 	require.NoError(t, err, "should successfully read CBZ file")
 
 	assert.Greater(t, book.Pages, 0, "should have more than 0 pages")
@@ -24,7 +30,7 @@ func TestGetBookInfoCBZ(t *testing.T) {
 func TestGetBookInfoCBR(t *testing.T) {
 	path := filepath.Join("..", "..", "fixtures", "Full of Fun", "Full_Of_Fun_001__c2c___1957___ABPC_.cbr")
 
-	book, err := getBookInfoCB(path)
+	book, err := getBookInfoCB(t.Context(), path)
 	require.NoError(t, err, "should successfully read CBR file")
 
 	assert.Greater(t, book.Pages, 0, "should have more than 0 pages")
@@ -36,7 +42,7 @@ func TestGetBookInfoCBR(t *testing.T) {
 func TestGetBookInfoCBZWithComicInfoInSubdirectory(t *testing.T) {
 	path := filepath.Join("..", "..", "fixtures", "dummy_book.cbz")
 
-	book, err := getBookInfoCB(path)
+	book, err := getBookInfoCB(t.Context(), path)
 	require.NoError(t, err, "should successfully read CBZ file")
 
 	// This test specifically verifies that ComicInfo.xml can be found
@@ -52,7 +58,7 @@ func TestGetBookInfoCBZFallbackBehavior(t *testing.T) {
 	// Test with a CBZ that doesn't have ComicInfo.xml to verify fallback behavior
 	path := filepath.Join("..", "..", "fixtures", "Full of Fun", "Full_of_Fun_001__Decker_Pub._1957.08__c2c___soothsayr_Yoc.cbz")
 
-	book, err := getBookInfoCB(path)
+	book, err := getBookInfoCB(t.Context(), path)
 	require.NoError(t, err, "should successfully read CBZ file")
 
 	assert.Greater(t, book.Pages, 0, "should have more than 0 pages")
@@ -65,6 +71,38 @@ func TestGetBookInfoCBZFallbackBehavior(t *testing.T) {
 	t.Logf("CBZ fallback behavior: Title=%s, Pages=%d", book.Title, book.Pages)
 }
 
+func TestGetBookInfoCBPrecedence(t *testing.T) {
+	page := testPNG(t, 10, 20)
+	comicInfo := zipEntry{Name: "ComicInfo.xml", Data: []byte(`<ComicInfo><Title>From ComicInfo</Title></ComicInfo>`)}
+	coMet := zipEntry{Name: "meta/CoMet.xml", Data: []byte(`<comet><title>From CoMet</title></comet>`)}
+	cbi := `{"ComicBookInfo/1.0": {"title": "From ComicBookInfo"}}`
+
+	tests := []struct {
+		name     string
+		entries  []zipEntry
+		comment  string
+		expected string
+	}{
+		{"all", []zipEntry{comicInfo, coMet}, cbi, "From ComicInfo"},
+		{"CoMet and ComicBookInfo", []zipEntry{coMet}, cbi, "From CoMet"},
+		{"ComicBookInfo", nil, cbi, "From ComicBookInfo"},
+		{"invalid ComicInfo.xml", []zipEntry{{Name: "ComicInfo.xml", Data: []byte("not xml")}, coMet}, cbi, "From CoMet"},
+		{"none", nil, "scanned by someone", "book"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "book.cbz")
+			createTestCBZComment(t, path, tt.comment, append(tt.entries, zipEntry{Name: "1.png", Data: page}))
+
+			info, err := getBookInfoCB(t.Context(), path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, info.Title)
+			assert.Equal(t, 1, info.Pages)
+		})
+	}
+}
+
 func TestValidImage(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -98,7 +136,7 @@ func TestExtractCBZ(t *testing.T) {
 	outputDir := t.TempDir()
 
 	// Extract files
-	extractedFiles, err := extractArchive(inputPath, outputDir)
+	extractedFiles, err := extractArchive(t.Context(), inputPath, outputDir, ExtractOptions{})
 	require.NoError(t, err, "should successfully extract CBZ archive")
 
 	// Verify extraction results
@@ -140,7 +178,7 @@ func TestExtractCBR(t *testing.T) {
 	outputDir := t.TempDir()
 
 	// Extract files
-	extractedFiles, err := extractArchive(inputPath, outputDir)
+	extractedFiles, err := extractArchive(t.Context(), inputPath, outputDir, ExtractOptions{})
 	require.NoError(t, err, "should successfully extract CBR archive")
 
 	// Verify extraction results
@@ -193,7 +231,7 @@ func TestExtractArchiveErrorCases(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := extractArchive(tt.inputPath, tt.outputDir)
+			_, err := extractArchive(t.Context(), tt.inputPath, tt.outputDir, ExtractOptions{})
 			if tt.expectError {
 				assert.Error(t, err, "should return error for %s", tt.name)
 			} else {
@@ -202,3 +240,145 @@ func TestExtractArchiveErrorCases(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractArchivePageRange(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "book.cbz")
+	createTestCBZ(t, input, []zipEntry{
+		{Name: "10.png", Data: testPNG(t, 30, 40)},
+		{Name: "ComicInfo.xml", Data: []byte(`<ComicInfo><Title>Range</Title></ComicInfo>`)},
+		{Name: "2.png", Data: testPNG(t, 20, 30)},
+		{Name: "1.png", Data: testPNG(t, 10, 20)},
+	})
+
+	output := filepath.Join(dir, "out")
+	pages, err := extractArchive(t.Context(), input, output, ExtractOptions{FirstPage: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []Page{{Path: "2.png", Width: 20, Height: 30}, {Path: "10.png", Width: 30, Height: 40}}, pages,
+		"should select the pages in natural order")
+	assert.NoFileExists(t, filepath.Join(output, "1.png"))
+	assert.FileExists(t, filepath.Join(output, "ComicInfo.xml"), "other files should be extracted")
+
+	pages, err = extractArchive(t.Context(), input, filepath.Join(dir, "first"), ExtractOptions{LastPage: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []Page{{Path: "1.png", Width: 10, Height: 20}}, pages)
+
+	_, err = extractArchive(t.Context(), input, filepath.Join(dir, "none"), ExtractOptions{FirstPage: 4})
+	assert.ErrorContains(t, err, "out of range")
+}
+
+func TestExtractArchiveComicInfoPages(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "book.cbz")
+	createTestCBZ(t, input, []zipEntry{
+		{Name: "ComicInfo.xml", Data: []byte(`<ComicInfo><Pages>
+  <Page Image="0" Type="FrontCover"/>
+  <Page Image="1" Type="Deleted"/>
+  <Page Image="2" Type="Story" DoublePage="true" Bookmark="Chapter 1"/>
+  <Page Image="3" Type="Advertisement"/>
+  <Page Image="9" Type="Story"/>
+</Pages></ComicInfo>`)},
+		{Name: "4.png", Data: testPNG(t, 10, 20)},
+		{Name: "1.png", Data: testPNG(t, 10, 20)},
+		{Name: "3.png", Data: testPNG(t, 40, 20)},
+		{Name: "2.png", Data: testPNG(t, 10, 20)},
+	})
+
+	output := filepath.Join(dir, "out")
+	pages, err := extractArchive(t.Context(), input, output, ExtractOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []Page{
+		{Path: "1.png", Width: 10, Height: 20, Type: "FrontCover"},
+		{Path: "3.png", Width: 40, Height: 20, Type: "Story", DoublePage: true, Bookmark: "Chapter 1"},
+		{Path: "4.png", Width: 10, Height: 20, Type: "Advertisement"},
+	}, pages)
+	assert.NoFileExists(t, filepath.Join(output, "2.png"), "deleted pages should not be extracted")
+
+	pages, err = extractArchive(t.Context(), input, filepath.Join(dir, "no-ads"), ExtractOptions{SkipAdvertisements: true, FirstPage: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []Page{{Path: "3.png", Width: 40, Height: 20, Type: "Story", DoublePage: true, Bookmark: "Chapter 1"}}, pages,
+		"the page range should count the deleted pages")
+
+	rc, page, err := ExtractPage(input, 2)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, Page{Path: "3.png", Width: 40, Height: 20, Type: "Story", DoublePage: true, Bookmark: "Chapter 1"}, page)
+}
+
+func TestExtractPage(t *testing.T) {
+	page1, page2 := testPNG(t, 10, 20), testPNG(t, 400, 300)
+	// Larger than the chunks read by io.ReadAll
+	require.Greater(t, len(page2), 512)
+
+	dir := t.TempDir()
+	for _, input := range []string{filepath.Join(dir, "book.cbz"), filepath.Join(dir, "book.cbt")} {
+		entries := []zipEntry{
+			{Name: "notes.txt", Data: []byte("notes")},
+			{Name: "pages/10.png", Data: page2},
+			{Name: "pages/2.png", Data: page1},
+		}
+		if filepath.Ext(input) == ".cbz" {
+			createTestCBZ(t, input, entries)
+		} else {
+			createTestCBT(t, input, entries)
+		}
+
+		for index, want := range [][]byte{page1, page2} {
+			rc, page, err := ExtractPage(input, index)
+			require.NoError(t, err)
+			data, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+
+			assert.Equal(t, want, data, "page %d of %s", index, input)
+			config, _, err := image.DecodeConfig(bytes.NewReader(want))
+			require.NoError(t, err)
+			assert.Equal(t, [2]int{config.Width, config.Height}, [2]int{page.Width, page.Height})
+		}
+
+		_, page, err := ExtractPage(input, 1)
+		require.NoError(t, err)
+		assert.Equal(t, "pages/10.png", page.Path, "pages should be in natural order")
+
+		_, _, err = ExtractPage(input, 2)
+		assert.ErrorContains(t, err, "out of range")
+		_, _, err = ExtractPage(input, -1)
+		assert.ErrorContains(t, err, "out of range")
+	}
+
+	_, _, err := ExtractPage(filepath.Join("..", "..", "fixtures", "pg11-images-3.epub"), 0)
+	assert.ErrorContains(t, err, "not supported")
+}
+
+// zipEntry is a file stored in a test archive
+type zipEntry struct {
+	Name string
+	Data []byte
+}
+
+// createTestCBZ writes a CBZ archive containing the given entries, in order
+func createTestCBZ(t *testing.T, path string, entries []zipEntry) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	require.NoError(t, err, "should create test archive")
+	defer file.Close()
+
+	w := zip.NewWriter(file)
+	for _, entry := range entries {
+		f, err := w.Create(entry.Name)
+		require.NoError(t, err, "should add %s to test archive", entry.Name)
+		_, err = f.Write(entry.Data)
+		require.NoError(t, err, "should write %s to test archive", entry.Name)
+	}
+	require.NoError(t, w.Close(), "should finish test archive")
+}
+
+// testPNG returns an encoded PNG image of the given size
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
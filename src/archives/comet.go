@@ -0,0 +1,103 @@
+package archives
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// CoMet is an XML file stored in comic book archives, usually named
+// CoMet.xml, see https://www.denvog.com/comet/comet-specification/
+
+// coMetName is the name of the CoMet file
+const coMetName = "CoMet.xml"
+
+type coMet struct {
+	XMLName        xml.Name `xml:"comet"`
+	Title          string   `xml:"title"`
+	Description    string   `xml:"description"`
+	Series         string   `xml:"series"`
+	Issue          string   `xml:"issue"`
+	Publisher      string   `xml:"publisher"`
+	Date           string   `xml:"date"`
+	Genres         []string `xml:"genre"`
+	Characters     []string `xml:"character"`
+	Language       string   `xml:"language"`
+	Identifier     string   `xml:"identifier"`
+	Pages          string   `xml:"pages"`
+	Creators       []string `xml:"creator"`
+	Writers        []string `xml:"writer"`
+	Pencillers     []string `xml:"penciller"`
+	Inkers         []string `xml:"inker"`
+	Colorists      []string `xml:"colorist"`
+	Letterers      []string `xml:"letterer"`
+	CoverDesigners []string `xml:"coverDesigner"`
+	Editors        []string `xml:"editor"`
+}
+
+// parseCoMet reads the metadata of a CoMet file
+func parseCoMet(data []byte) (BookInfo, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = charset.NewReaderLabel
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+
+	var c coMet
+	if err := d.Decode(&c); err != nil {
+		return BookInfo{}, fmt.Errorf("failed to parse CoMet: %w", err)
+	}
+
+	info := BookInfo{
+		Title:       strings.TrimSpace(c.Title),
+		Series:      strings.TrimSpace(c.Series),
+		SeriesIndex: strings.TrimSpace(c.Issue),
+		Description: strings.TrimSpace(c.Description),
+		Publisher:   strings.TrimSpace(c.Publisher),
+		Pages:       max(parseLenientInt(c.Pages), 0),
+	}
+	if info.Title == "" {
+		info.Title = comicInfoSeriesTitle(info.Series, info.SeriesIndex)
+	}
+	if language := strings.TrimSpace(c.Language); language != "" {
+		info.Language = []string{language}
+	}
+
+	// Dates are YYYY-MM-DD, possibly shortened
+	info.PublishedDate = strings.TrimSpace(c.Date)
+	if year, month, day, err := parseComicInfoDate(info.PublishedDate); err == nil && year > 0 {
+		info.PublishedDate = formatComicDate(year, month, day)
+	}
+
+	// The identifier is often prefixed, e.g. "isbn:" or "urn:isbn:"
+	identifier := strings.TrimSpace(c.Identifier)
+	if i := strings.LastIndex(strings.ToLower(identifier), "isbn:"); i >= 0 {
+		identifier = strings.TrimSpace(identifier[i+len("isbn:"):])
+	}
+	info.ISBN = identifier
+
+	// Each creator is an element, the generic creators are the authors
+	creators := []comicInfoCreator{
+		{RoleWriter, strings.Join(append(c.Creators, c.Writers...), ",")},
+		{RolePenciller, strings.Join(c.Pencillers, ",")},
+		{RoleInker, strings.Join(c.Inkers, ",")},
+		{RoleColorist, strings.Join(c.Colorists, ",")},
+		{RoleLetterer, strings.Join(c.Letterers, ",")},
+		{RoleCoverArtist, strings.Join(c.CoverDesigners, ",")},
+		{RoleEditor, strings.Join(c.Editors, ",")},
+	}
+	info.Authors = comicInfoAuthors(creators...)
+	info.Contributors = comicInfoContributors(creators...)
+
+	var keywords []string
+	for _, keyword := range append(c.Genres, c.Characters...) {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	info.Keywords = removeDuplicates(keywords)
+
+	return info, nil
+}
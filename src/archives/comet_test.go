@@ -0,0 +1,62 @@
+package archives
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCoMet = `<?xml version="1.0" encoding="UTF-8"?>
+<comet xmlns="http://www.denvog.com/comet/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+  xsi:schemaLocation="http://www.denvog.com http://www.denvog.com/comet/comet.xsd">
+  <title>The Long Road</title>
+  <description>Part one of the journey.</description>
+  <series>Journey</series>
+  <issue>3</issue>
+  <publisher>Indie Press</publisher>
+  <date>2010-04</date>
+  <genre>Adventure</genre>
+  <genre>Fantasy</genre>
+  <character>Ana</character>
+  <language>en</language>
+  <identifier>urn:isbn:9781234567897</identifier>
+  <pages>24</pages>
+  <writer>Jane Doe</writer>
+  <penciller>John Doe</penciller>
+  <penciller>Ann Smith</penciller>
+  <coverDesigner>Ann Smith</coverDesigner>
+  <readingDirection>ltr</readingDirection>
+</comet>`
+
+func TestParseCoMet(t *testing.T) {
+	info, err := parseCoMet([]byte(testCoMet))
+	require.NoError(t, err)
+	assert.Equal(t, BookInfo{
+		Title:         "The Long Road",
+		Series:        "Journey",
+		SeriesIndex:   "3",
+		Description:   "Part one of the journey.",
+		Publisher:     "Indie Press",
+		PublishedDate: "2010-04",
+		Language:      []string{"en"},
+		ISBN:          "9781234567897",
+		Pages:         24,
+		Authors:       []string{"Jane Doe", "John Doe", "Ann Smith"},
+		Contributors: []Contributor{
+			{Name: "Jane Doe", Role: RoleWriter, MARCRelator: "aut"},
+			{Name: "John Doe", Role: RolePenciller, MARCRelator: "pnc"},
+			{Name: "Ann Smith", Role: RolePenciller, MARCRelator: "pnc"},
+			{Name: "Ann Smith", Role: RoleCoverArtist, MARCRelator: "cov"},
+		},
+		Keywords: []string{"Adventure", "Fantasy", "Ana"},
+	}, info)
+
+	info, err = parseCoMet([]byte(`<comet><series>Journey</series><issue>4</issue><date>Spring 2011</date></comet>`))
+	require.NoError(t, err)
+	assert.Equal(t, "Journey #4", info.Title)
+	assert.Equal(t, "Spring 2011", info.PublishedDate, "other dates should be kept as-is")
+
+	_, err = parseCoMet([]byte(`<ComicInfo><Title>Not CoMet</Title></ComicInfo>`))
+	assert.Error(t, err)
+}
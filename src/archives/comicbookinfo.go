@@ -0,0 +1,138 @@
+package archives
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ComicBookInfo is a JSON document stored in the comment of ZIP archives by
+// ComicBookLover and ComicTagger, see
+// https://code.google.com/archive/p/comicbookinfo/wikis/Example.wiki
+
+// comicBookInfoKey is the key of the metadata in the comment
+const comicBookInfoKey = "ComicBookInfo/1.0"
+
+type comicBookInfo struct {
+	Series           string              `json:"series"`
+	Title            string              `json:"title"`
+	Publisher        string              `json:"publisher"`
+	PublicationMonth comicBookInfoNumber `json:"publicationMonth"`
+	PublicationYear  comicBookInfoNumber `json:"publicationYear"`
+	Issue            comicBookInfoNumber `json:"issue"`
+	Genre            string              `json:"genre"`
+	Language         string              `json:"language"`
+	Credits          []comicBookCredit   `json:"credits"`
+	Tags             []string            `json:"tags"`
+	Comments         string              `json:"comments"`
+}
+
+type comicBookCredit struct {
+	Person string `json:"person"`
+	Role   string `json:"role"`
+}
+
+// comicBookInfoNumber is a number written either as a JSON number or as a
+// string, depending on the tool
+type comicBookInfoNumber string
+
+func (n *comicBookInfoNumber) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*n = comicBookInfoNumber(strings.TrimSpace(s))
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		// null and other values are ignored
+		return nil
+	}
+	*n = comicBookInfoNumber(number.String())
+	return nil
+}
+
+// readComicBookInfoZIP reads the ComicBookInfo in the comment of a ZIP
+// archive, it returns false when the comment does not hold one
+func readComicBookInfoZIP(path string) ([]byte, bool) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+
+	comment := []byte(strings.TrimSpace(r.Comment))
+	if !bytes.HasPrefix(comment, []byte("{")) || !bytes.Contains(comment, []byte(comicBookInfoKey)) {
+		return nil, false
+	}
+	return comment, true
+}
+
+// parseComicBookInfo reads the metadata of a ComicBookInfo comment
+func parseComicBookInfo(data []byte) (BookInfo, error) {
+	var comment map[string]json.RawMessage
+	if err := json.Unmarshal(data, &comment); err != nil {
+		return BookInfo{}, fmt.Errorf("failed to parse ComicBookInfo: %w", err)
+	}
+	raw, ok := comment[comicBookInfoKey]
+	if !ok {
+		return BookInfo{}, fmt.Errorf("failed to parse ComicBookInfo: no %s key", comicBookInfoKey)
+	}
+	var cbi comicBookInfo
+	if err := json.Unmarshal(raw, &cbi); err != nil {
+		return BookInfo{}, fmt.Errorf("failed to parse ComicBookInfo: %w", err)
+	}
+
+	info := BookInfo{
+		Title:       strings.TrimSpace(cbi.Title),
+		Series:      strings.TrimSpace(cbi.Series),
+		SeriesIndex: string(cbi.Issue),
+		Description: strings.TrimSpace(cbi.Comments),
+		Publisher:   strings.TrimSpace(cbi.Publisher),
+	}
+	if info.Title == "" {
+		info.Title = comicInfoSeriesTitle(info.Series, info.SeriesIndex)
+	}
+
+	// The language is usually a name, e.g. "English"
+	if language := strings.TrimSpace(cbi.Language); language != "" {
+		info.Language = []string{language}
+	}
+
+	info.PublishedDate = formatComicDate(parseLenientInt(string(cbi.PublicationYear)), parseLenientInt(string(cbi.PublicationMonth)), 0)
+
+	// Each credit is a person, read as a creator of ComicInfo.xml
+	creators := make([]comicInfoCreator, len(cbi.Credits))
+	for i, credit := range cbi.Credits {
+		creators[i] = comicInfoCreator{role: parseComicBookRole(credit.Role), names: credit.Person}
+	}
+	info.Authors = comicInfoAuthors(creators...)
+	info.Contributors = comicInfoContributors(creators...)
+
+	keywords := append(splitCommaDelimited(cbi.Genre), cbi.Tags...)
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			info.Keywords = append(info.Keywords, keyword)
+		}
+	}
+	info.Keywords = removeDuplicates(info.Keywords)
+
+	return info, nil
+}
+
+// parseComicBookRole reads the role of a credit, e.g. "Writer" or "Cover".
+// The roles without a Role constant are kept as-is.
+func parseComicBookRole(role string) string {
+	role = strings.ToLower(strings.TrimSpace(role))
+	switch role {
+	case "cover", "cover artist", "covers":
+		return RoleCoverArtist
+	case "":
+		return ""
+	}
+	if c := parseContributorRole("", role); c.Role != "" {
+		return c.Role
+	}
+	return role
+}
@@ -0,0 +1,103 @@
+package archives
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testComicBookInfo = `{
+  "appID": "ComicTagger/1.0.0",
+  "lastModified": "2014-01-12 20:52:17",
+  "ComicBookInfo/1.0": {
+    "series": "Watchmen",
+    "title": "",
+    "publisher": "DC Comics",
+    "publicationMonth": 9,
+    "publicationYear": 1986,
+    "issue": "1",
+    "numberOfIssues": 12,
+    "volume": 1986,
+    "genre": "Superhero, Mystery",
+    "language": "English",
+    "country": "United States",
+    "credits": [
+      {"person": "Alan Moore", "role": "Writer", "primary": true},
+      {"person": "Dave Gibbons", "role": "Artist"},
+      {"person": "Dave Gibbons", "role": "Cover"},
+      {"person": "John Higgins", "role": "Colorist"}
+    ],
+    "tags": ["classic", "Mystery"],
+    "rating": 5,
+    "comments": "At midnight, all the agents..."
+  }
+}`
+
+func TestParseComicBookInfo(t *testing.T) {
+	info, err := parseComicBookInfo([]byte(testComicBookInfo))
+	require.NoError(t, err)
+	assert.Equal(t, BookInfo{
+		Title:         "Watchmen #1",
+		Series:        "Watchmen",
+		SeriesIndex:   "1",
+		Description:   "At midnight, all the agents...",
+		Publisher:     "DC Comics",
+		PublishedDate: "1986-09",
+		Language:      []string{"English"},
+		Authors:       []string{"Alan Moore", "Dave Gibbons", "John Higgins"},
+		Contributors: []Contributor{
+			{Name: "Alan Moore", Role: RoleWriter, MARCRelator: "aut"},
+			{Name: "Dave Gibbons", Role: RoleArtist, MARCRelator: "art"},
+			{Name: "Dave Gibbons", Role: RoleCoverArtist, MARCRelator: "cov"},
+			{Name: "John Higgins", Role: RoleColorist, MARCRelator: "clr"},
+		},
+		Keywords: []string{"Superhero", "Mystery", "classic"},
+	}, info)
+
+	info, err = parseComicBookInfo([]byte(`{"ComicBookInfo/1.0": {"title": "Issue", "issue": 2.5, "publicationYear": "2001"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, BookInfo{Title: "Issue", SeriesIndex: "2.5", PublishedDate: "2001"}, info,
+		"numbers should be read as strings or numbers")
+
+	_, err = parseComicBookInfo([]byte(`{"appID": "ComicTagger"}`))
+	assert.Error(t, err)
+	_, err = parseComicBookInfo([]byte(`scanned by someone`))
+	assert.Error(t, err)
+}
+
+func TestReadComicBookInfoZIP(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.cbz")
+	createTestCBZComment(t, path, testComicBookInfo, []zipEntry{{Name: "1.png", Data: testPNG(t, 10, 20)}})
+	data, ok := readComicBookInfoZIP(path)
+	require.True(t, ok)
+	assert.JSONEq(t, testComicBookInfo, string(data))
+
+	other := filepath.Join(dir, "other.cbz")
+	createTestCBZWithComment(t, other, []zipEntry{{Name: "1.png", Data: testPNG(t, 10, 20)}})
+	_, ok = readComicBookInfoZIP(other)
+	assert.False(t, ok, "other comments should be ignored")
+}
+
+// createTestCBZComment writes a CBZ archive with the given comment
+func createTestCBZComment(t *testing.T, path, comment string, entries []zipEntry) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	w := zip.NewWriter(file)
+	require.NoError(t, w.SetComment(comment))
+	for _, entry := range entries {
+		f, err := w.Create(entry.Name)
+		require.NoError(t, err)
+		_, err = f.Write(entry.Data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+}
@@ -1,340 +1,542 @@
 package archives
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/hekmon/go-comicinfo"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
 )
 
-// parseComicInfo parses ComicInfo.xml content using the go-comicinfo library types
-func parseComicInfo(xmlData []byte) (BookInfo, error) {
-	// Try parsing as v2.1 first (most recent)
-	var comicInfo comicinfo.ComicInfov21
-	if err := xml.Unmarshal(xmlData, &comicInfo); err == nil {
-		return convertComicInfoV21ToBookInfo(comicInfo), nil
-	}
-
-	// Try v2.0 format
-	var comicInfoV2 comicinfo.ComicInfov2
-	if err := xml.Unmarshal(xmlData, &comicInfoV2); err == nil {
-		return convertComicInfoV2ToBookInfo(comicInfoV2), nil
-	}
+// Versions of the ComicInfo.xml schema
+const (
+	ComicInfoV1  = "1.0"
+	ComicInfoV2  = "2.0"
+	ComicInfoV21 = "2.1"
+)
 
-	// Try v1.0 format
-	var comicInfoV1 comicinfo.ComicInfov1
-	if err := xml.Unmarshal(xmlData, &comicInfoV1); err == nil {
-		return convertComicInfoV1ToBookInfo(comicInfoV1), nil
-	}
+// comicInfoSchemaVersion finds the version in the schema location of a
+// ComicInfo.xml, e.g. ".../schema/v2.0/ComicInfo.xsd"
+var comicInfoSchemaVersion = regexp.MustCompile(`v(1\.0|2\.0|2\.1)/`)
+
+// comicInfoDeclaredEncoding finds the encoding of the XML declaration
+var comicInfoDeclaredEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*encoding\s*=\s*["']([^"']+)["']`)
+
+// comicInfoElements are the elements introduced by each version, used to
+// detect the version of the documents without a schema location
+var comicInfoElements = []struct {
+	version  string
+	elements []string
+}{
+	{ComicInfoV21, []string{"translator", "tags", "storyarcnumber", "gtin"}},
+	{ComicInfoV2, []string{"day", "storyarc", "seriesgroup", "agerating", "communityrating", "characters", "teams",
+		"locations", "scaninformation", "maincharacterorteam", "review"}},
+}
 
-	return BookInfo{}, fmt.Errorf("failed to parse ComicInfo.xml")
+// Comic holds the fields of ComicInfo.xml that BookInfo does not, or only
+// combined with others, using the v2.1 names. The GTIN is the ISBN of BookInfo.
+type Comic struct {
+	// Version is the schema version of the ComicInfo.xml: the one of its
+	// schema location, otherwise the oldest one defining all its elements
+	Version string `json:"version"`
+
+	Count               int      `json:"count,omitempty"`
+	Volume              int      `json:"volume,omitempty"`
+	AlternateSeries     string   `json:"alternate_series,omitempty"`
+	AlternateNumber     string   `json:"alternate_number,omitempty"`
+	AlternateCount      int      `json:"alternate_count,omitempty"`
+	StoryArc            string   `json:"story_arc,omitempty"`
+	StoryArcNumber      string   `json:"story_arc_number,omitempty"`
+	SeriesGroup         string   `json:"series_group,omitempty"`
+	Imprint             string   `json:"imprint,omitempty"`
+	Format              string   `json:"format,omitempty"`
+	AgeRating           string   `json:"age_rating,omitempty"`
+	Manga               string   `json:"manga,omitempty"`
+	BlackAndWhite       string   `json:"black_and_white,omitempty"`
+	CommunityRating     float64  `json:"community_rating,omitempty"`
+	Web                 []string `json:"web,omitempty"`
+	Genre               []string `json:"genre,omitempty"`
+	Tags                []string `json:"tags,omitempty"`
+	Characters          []string `json:"characters,omitempty"`
+	Teams               []string `json:"teams,omitempty"`
+	Locations           []string `json:"locations,omitempty"`
+	MainCharacterOrTeam string   `json:"main_character_or_team,omitempty"`
+	Notes               string   `json:"notes,omitempty"`
+	Review              string   `json:"review,omitempty"`
+	ScanInformation     string   `json:"scan_information,omitempty"`
+
+	// Pages describe the images of the archive, Image being their index
+	Pages []ComicPage `json:"pages,omitempty"`
 }
 
-// convertComicInfoV21ToBookInfo converts ComicInfov21 to BookInfo
-func convertComicInfoV21ToBookInfo(ci comicinfo.ComicInfov21) BookInfo {
-	bookInfo := BookInfo{}
+// ComicPage is a page of the Pages of ComicInfo.xml
+type ComicPage struct {
+	Image       int    `json:"image"`
+	Type        string `json:"type,omitempty"`
+	DoublePage  bool   `json:"double_page,omitempty"`
+	ImageSize   int    `json:"image_size,omitempty"`
+	Key         string `json:"key,omitempty"`
+	Bookmark    string `json:"bookmark,omitempty"`
+	ImageWidth  int    `json:"image_width,omitempty"`
+	ImageHeight int    `json:"image_height,omitempty"`
+}
 
-	// Title - use Series and Number if Title is empty, or just Title
-	if ci.Title != "" {
-		bookInfo.Title = ci.Title
-	} else if ci.Series != "" {
-		if ci.Number > 0 {
-			bookInfo.Title = fmt.Sprintf("%s #%d", ci.Series, ci.Number)
-		} else {
-			bookInfo.Title = ci.Series
-		}
-	}
+// comicInfoDocument is a ComicInfo.xml of any version. Its elements are keyed
+// by their lowercase name, so that the documents of every version and their
+// non-standard variants are read alike.
+type comicInfoDocument struct {
+	version string
+	fields  map[string]string
+	pages   []ComicPage
+}
 
-	// Series information
-	if ci.Series != "" {
-		bookInfo.Series = ci.Series
-	}
+// text returns the trimmed content of an element
+func (d comicInfoDocument) text(name string) string {
+	return d.fields[name]
+}
 
-	if ci.Number > 0 {
-		bookInfo.SeriesIndex = strconv.Itoa(ci.Number)
-	}
+// number returns the content of an element as a whole number, decimals are
+// dropped and invalid numbers read as 0
+func (d comicInfoDocument) number(name string) int {
+	return parseLenientInt(d.fields[name])
+}
 
-	// Description
-	if ci.Summary != "" {
-		bookInfo.Description = ci.Summary
-	}
+// list returns the comma separated values of an element
+func (d comicInfoDocument) list(name string) []string {
+	return splitCommaDelimited(d.fields[name])
+}
 
-	// Authors - combine creators
-	var authors []string
-	if ci.Writer != "" {
-		authors = append(authors, splitCommaDelimited(ci.Writer)...)
-	}
-	if ci.Penciller != "" {
-		authors = append(authors, splitCommaDelimited(ci.Penciller)...)
-	}
-	if ci.Inker != "" {
-		authors = append(authors, splitCommaDelimited(ci.Inker)...)
-	}
-	if ci.Colorist != "" {
-		authors = append(authors, splitCommaDelimited(ci.Colorist)...)
-	}
-	if ci.Letterer != "" {
-		authors = append(authors, splitCommaDelimited(ci.Letterer)...)
+// parseLenientInt parses "12", "12.0" or " 12 ", anything else is 0
+func parseLenientInt(s string) int {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
 	}
-	if ci.Editor != "" {
-		authors = append(authors, splitCommaDelimited(ci.Editor)...)
-	}
-	if ci.Translator != "" {
-		authors = append(authors, splitCommaDelimited(ci.Translator)...)
-	}
-	// Remove duplicates
-	authors = removeDuplicates(authors)
-	if len(authors) > 0 {
-		bookInfo.Authors = authors
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return int(f)
 	}
+	return 0
+}
 
-	// Publisher
-	if ci.Publisher != "" {
-		bookInfo.Publisher = ci.Publisher
+// parseLenientBool parses the booleans of attributes, e.g. "true" or "True"
+func parseLenientBool(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "1", "yes":
+		return true
 	}
+	return false
+}
 
-	// Published date - construct from Year, Month, Day
-	if ci.Year > 0 {
-		date := strconv.Itoa(ci.Year)
-		if ci.Month > 0 {
-			date += "-" + fmt.Sprintf("%02d", ci.Month)
-			if ci.Day > 0 {
-				date += "-" + fmt.Sprintf("%02d", ci.Day)
-			}
+// decodeComicInfo reads a ComicInfo.xml of any version. It accepts a byte
+// order mark, UTF-16, the encodings declared by the document, and falls back
+// to Windows-1252 for the documents that are not valid UTF-8.
+func decodeComicInfo(data []byte) (comicInfoDocument, error) {
+	data, converted := normalizeComicInfoEncoding(data)
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		// The document was already converted to UTF-8
+		if converted {
+			return input, nil
 		}
-		bookInfo.PublishedDate = date
-	}
-
-	// Language
-	if ci.LanguageISO != "" {
-		bookInfo.Language = []string{ci.LanguageISO}
+		return charset.NewReaderLabel(label, input)
 	}
 
-	// Keywords - combine Genre, Tags, Characters, Teams, Locations
-	var keywords []string
-	if ci.Genre != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Genre)...)
-	}
-	if ci.Tags != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Tags)...)
-	}
-	if ci.Characters != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Characters)...)
-	}
-	if ci.Teams != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Teams)...)
-	}
-	if ci.Locations != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Locations)...)
+	doc := comicInfoDocument{fields: map[string]string{}}
+	var root *xml.StartElement
+	for root == nil {
+		token, err := d.Token()
+		if err != nil {
+			return doc, fmt.Errorf("failed to parse ComicInfo.xml: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			root = &start
+		}
 	}
-	if len(keywords) > 0 {
-		bookInfo.Keywords = removeDuplicates(keywords)
+	if !strings.EqualFold(root.Name.Local, "ComicInfo") {
+		return doc, fmt.Errorf("failed to parse ComicInfo.xml: unexpected <%s> element", root.Name.Local)
 	}
-
-	// Page count
-	if ci.PageCount > 0 {
-		bookInfo.Pages = ci.PageCount
+	for _, attr := range root.Attr {
+		if strings.HasSuffix(strings.ToLower(attr.Name.Local), "schemalocation") {
+			if m := comicInfoSchemaVersion.FindStringSubmatch(attr.Value); m != nil {
+				doc.version = m[1]
+			}
+		}
 	}
 
-	return bookInfo
-}
-
-// convertComicInfoV2ToBookInfo converts ComicInfov2 to BookInfo
-func convertComicInfoV2ToBookInfo(ci comicinfo.ComicInfov2) BookInfo {
-	bookInfo := BookInfo{}
-
-	// Title - use Series and Number if Title is empty, or just Title
-	if ci.Title != "" {
-		bookInfo.Title = ci.Title
-	} else if ci.Series != "" {
-		if ci.Number > 0 {
-			bookInfo.Title = fmt.Sprintf("%s #%d", ci.Series, ci.Number)
-		} else {
-			bookInfo.Title = ci.Series
+	for {
+		token, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return doc, fmt.Errorf("failed to parse ComicInfo.xml: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
 		}
-	}
 
-	// Series information
-	if ci.Series != "" {
-		bookInfo.Series = ci.Series
-	}
+		name := strings.ToLower(start.Name.Local)
+		if name == "pages" {
+			pages, err := decodeComicInfoPages(d)
+			if err != nil {
+				return doc, err
+			}
+			doc.pages = append(doc.pages, pages...)
+			continue
+		}
 
-	if ci.Number > 0 {
-		bookInfo.SeriesIndex = strconv.Itoa(ci.Number)
+		var element struct {
+			Text string `xml:",chardata"`
+		}
+		if err := d.DecodeElement(&element, &start); err != nil {
+			return doc, fmt.Errorf("failed to parse ComicInfo.xml: %w", err)
+		}
+		// The first of repeated elements wins
+		if _, ok := doc.fields[name]; !ok {
+			doc.fields[name] = strings.TrimSpace(element.Text)
+		}
 	}
 
-	// Description
-	if ci.Summary != "" {
-		bookInfo.Description = ci.Summary
+	if doc.version == "" {
+		doc.version = doc.detectVersion()
 	}
+	return doc, nil
+}
 
-	// Authors - combine creators
-	var authors []string
-	if ci.Writer != "" {
-		authors = append(authors, splitCommaDelimited(ci.Writer)...)
-	}
-	if ci.Penciller != "" {
-		authors = append(authors, splitCommaDelimited(ci.Penciller)...)
-	}
-	if ci.Inker != "" {
-		authors = append(authors, splitCommaDelimited(ci.Inker)...)
-	}
-	if ci.Colorist != "" {
-		authors = append(authors, splitCommaDelimited(ci.Colorist)...)
-	}
-	if ci.Letterer != "" {
-		authors = append(authors, splitCommaDelimited(ci.Letterer)...)
-	}
-	if ci.Editor != "" {
-		authors = append(authors, splitCommaDelimited(ci.Editor)...)
-	}
-	// Remove duplicates
-	authors = removeDuplicates(authors)
-	if len(authors) > 0 {
-		bookInfo.Authors = authors
+// decodeComicInfoPages reads the <Page> elements up to the end of <Pages>
+func decodeComicInfoPages(d *xml.Decoder) ([]ComicPage, error) {
+	var pages []ComicPage
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ComicInfo.xml pages: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.EndElement:
+			return pages, nil
+		case xml.StartElement:
+			if strings.EqualFold(t.Name.Local, "Page") {
+				pages = append(pages, comicInfoPage(t.Attr))
+			}
+			if err := d.Skip(); err != nil {
+				return nil, fmt.Errorf("failed to parse ComicInfo.xml pages: %w", err)
+			}
+		}
 	}
+}
 
-	// Publisher
-	if ci.Publisher != "" {
-		bookInfo.Publisher = ci.Publisher
+// comicInfoPage reads the attributes of a <Page>, whatever their case
+func comicInfoPage(attrs []xml.Attr) ComicPage {
+	var page ComicPage
+	for _, attr := range attrs {
+		value := strings.TrimSpace(attr.Value)
+		switch strings.ToLower(attr.Name.Local) {
+		case "image":
+			page.Image = parseLenientInt(value)
+		case "type":
+			page.Type = value
+		case "doublepage":
+			page.DoublePage = parseLenientBool(value)
+		case "imagesize":
+			page.ImageSize = parseLenientInt(value)
+		case "key":
+			page.Key = value
+		case "bookmark":
+			page.Bookmark = value
+		case "imagewidth":
+			page.ImageWidth = parseLenientInt(value)
+		case "imageheight":
+			page.ImageHeight = parseLenientInt(value)
+		}
 	}
+	return page
+}
 
-	// Published date - construct from Year, Month, Day
-	if ci.Year > 0 {
-		date := strconv.Itoa(ci.Year)
-		if ci.Month > 0 {
-			date += "-" + fmt.Sprintf("%02d", ci.Month)
-			if ci.Day > 0 {
-				date += "-" + fmt.Sprintf("%02d", ci.Day)
+// detectVersion returns the oldest version defining all the elements of the
+// document
+func (d comicInfoDocument) detectVersion() string {
+	for _, v := range comicInfoElements {
+		for _, element := range v.elements {
+			if d.fields[element] != "" {
+				return v.version
 			}
 		}
-		bookInfo.PublishedDate = date
 	}
-
-	// Language
-	if ci.LanguageISO != "" {
-		bookInfo.Language = []string{ci.LanguageISO}
+	for _, page := range d.pages {
+		if page.Bookmark != "" {
+			return ComicInfoV2
+		}
 	}
+	return ComicInfoV1
+}
 
-	// Keywords - combine Genre, Characters, Teams, Locations
-	var keywords []string
-	if ci.Genre != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Genre)...)
+// normalizeComicInfoEncoding converts UTF-16 documents and documents that are
+// not valid UTF-8 without declaring another encoding to UTF-8, and drops the
+// byte order mark. It reports whether the document was converted.
+func normalizeComicInfoEncoding(data []byte) ([]byte, bool) {
+	if bytes.HasPrefix(data, []byte{0xFF, 0xFE}) || bytes.HasPrefix(data, []byte{0xFE, 0xFF}) {
+		// The byte order mark sets the endianness
+		decoded, err := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Bytes(data)
+		if err == nil {
+			return decoded, true
+		}
 	}
-	if ci.Characters != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Characters)...)
+
+	data = bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})
+	if utf8.Valid(data) {
+		return data, false
 	}
-	if ci.Teams != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Teams)...)
+	if m := comicInfoDeclaredEncoding.FindSubmatch(data); m != nil {
+		label := strings.ToLower(string(m[1]))
+		if label != "utf-8" && label != "utf8" {
+			return data, false
+		}
 	}
-	if ci.Locations != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Locations)...)
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(data)
+	if err != nil {
+		return data, false
 	}
-	if len(keywords) > 0 {
-		bookInfo.Keywords = removeDuplicates(keywords)
+	return decoded, true
+}
+
+// parseComicInfo reads the metadata of a ComicInfo.xml of any version
+func parseComicInfo(xmlData []byte) (BookInfo, error) {
+	doc, err := decodeComicInfo(xmlData)
+	if err != nil {
+		return BookInfo{}, err
 	}
+	return doc.bookInfo(), nil
+}
 
-	// Page count
-	if ci.PageCount > 0 {
-		bookInfo.Pages = ci.PageCount
+// comicInfoFrontCover returns the index of the image marked as FrontCover
+// in the Pages of a ComicInfo.xml
+func comicInfoFrontCover(xmlData []byte) (int, bool) {
+	doc, err := decodeComicInfo(xmlData)
+	if err != nil {
+		return 0, false
 	}
 
-	return bookInfo
+	for _, page := range doc.pages {
+		if strings.EqualFold(page.Type, string(comicinfo.PageTypeFrontCover)) && page.Image >= 0 {
+			return page.Image, true
+		}
+	}
+	return 0, false
 }
 
-// convertComicInfoV1ToBookInfo converts ComicInfov1 to BookInfo
-func convertComicInfoV1ToBookInfo(ci comicinfo.ComicInfov1) BookInfo {
+// bookInfo converts the document to BookInfo
+func (d comicInfoDocument) bookInfo() BookInfo {
 	bookInfo := BookInfo{}
 
-	// Title - use Series and Number if Title is empty, or just Title
-	if ci.Title != "" {
-		bookInfo.Title = ci.Title
-	} else if ci.Series != "" {
-		if ci.Number > 0 {
-			bookInfo.Title = fmt.Sprintf("%s #%d", ci.Series, ci.Number)
-		} else {
-			bookInfo.Title = ci.Series
+	// Series information, numbers below 1 are ignored
+	bookInfo.Series = d.text("series")
+	if number := d.text("number"); number != "" {
+		if n, err := strconv.ParseFloat(number, 64); err != nil || n > 0 {
+			bookInfo.SeriesIndex = number
 		}
 	}
 
-	// Series information
-	if ci.Series != "" {
-		bookInfo.Series = ci.Series
-	}
-
-	if ci.Number > 0 {
-		bookInfo.SeriesIndex = strconv.Itoa(ci.Number)
+	// Title - use Series and Number if Title is empty, or just Title
+	bookInfo.Title = d.text("title")
+	if bookInfo.Title == "" {
+		bookInfo.Title = comicInfoSeriesTitle(bookInfo.Series, bookInfo.SeriesIndex)
 	}
 
-	// Description
-	if ci.Summary != "" {
-		bookInfo.Description = ci.Summary
-	}
+	bookInfo.Description = d.text("summary")
 
 	// Authors - combine creators
-	var authors []string
-	if ci.Writer != "" {
-		authors = append(authors, splitCommaDelimited(ci.Writer)...)
-	}
-	if ci.Penciller != "" {
-		authors = append(authors, splitCommaDelimited(ci.Penciller)...)
-	}
-	if ci.Inker != "" {
-		authors = append(authors, splitCommaDelimited(ci.Inker)...)
-	}
-	if ci.Colorist != "" {
-		authors = append(authors, splitCommaDelimited(ci.Colorist)...)
-	}
-	if ci.Letterer != "" {
-		authors = append(authors, splitCommaDelimited(ci.Letterer)...)
-	}
-	if ci.Editor != "" {
-		authors = append(authors, splitCommaDelimited(ci.Editor)...)
-	}
-	// Remove duplicates
-	authors = removeDuplicates(authors)
-	if len(authors) > 0 {
-		bookInfo.Authors = authors
+	creators := []comicInfoCreator{
+		{RoleWriter, d.text("writer")},
+		{RolePenciller, d.text("penciller")},
+		{RoleInker, d.text("inker")},
+		{RoleColorist, d.text("colorist")},
+		{RoleLetterer, d.text("letterer")},
+		{RoleCoverArtist, d.text("coverartist")},
+		{RoleEditor, d.text("editor")},
+		{RoleTranslator, d.text("translator")},
 	}
+	bookInfo.Authors = comicInfoAuthors(creators...)
+	bookInfo.Contributors = comicInfoContributors(creators...)
 
-	// Publisher
-	if ci.Publisher != "" {
-		bookInfo.Publisher = ci.Publisher
-	}
+	bookInfo.Publisher = d.text("publisher")
 
-	// Published date - construct from Year, Month
-	if ci.Year > 0 {
-		date := strconv.Itoa(ci.Year)
-		if ci.Month > 0 {
-			date += "-" + fmt.Sprintf("%02d", ci.Month)
-		}
-		bookInfo.PublishedDate = date
-	}
+	// Published date - construct from Year, Month, Day
+	bookInfo.PublishedDate = formatComicDate(d.number("year"), d.number("month"), d.number("day"))
 
-	// Language
-	if ci.Language != "" {
-		bookInfo.Language = []string{ci.Language}
+	// Language, some documents use <Language>
+	if language := d.text("languageiso"); language != "" {
+		bookInfo.Language = []string{language}
+	} else if language := d.text("language"); language != "" {
+		bookInfo.Language = []string{language}
 	}
 
-	// Keywords - combine Genre
+	// Keywords - combine Genre, Tags, Characters, Teams, Locations
 	var keywords []string
-	if ci.Genre != "" {
-		keywords = append(keywords, splitCommaDelimited(ci.Genre)...)
+	for _, name := range []string{"genre", "tags", "characters", "teams", "locations"} {
+		keywords = append(keywords, d.list(name)...)
 	}
 	if len(keywords) > 0 {
 		bookInfo.Keywords = removeDuplicates(keywords)
 	}
 
 	// Page count
-	if ci.PageCount > 0 {
-		bookInfo.Pages = ci.PageCount
+	if pages := d.number("pagecount"); pages > 0 {
+		bookInfo.Pages = pages
 	}
 
+	// ISBN, stored as a GTIN
+	bookInfo.ISBN = d.text("gtin")
+
+	bookInfo.Comic = d.comic()
+
 	return bookInfo
 }
 
+// comicInfoSeriesTitle returns the title of a ComicInfo.xml without one:
+// "Series #Index", or the series alone
+func comicInfoSeriesTitle(series, index string) string {
+	if series == "" || index == "" {
+		return series
+	}
+	return fmt.Sprintf("%s #%s", series, index)
+}
+
+// formatComicDate returns YYYY, YYYY-MM or YYYY-MM-DD, the month and day
+// being ignored when 0, or an empty string without a year
+func formatComicDate(year, month, day int) string {
+	if year <= 0 {
+		return ""
+	}
+	date := strconv.Itoa(year)
+	if month > 0 {
+		date += fmt.Sprintf("-%02d", month)
+		if day > 0 {
+			date += fmt.Sprintf("-%02d", day)
+		}
+	}
+	return date
+}
+
+// comic copies the fields that have no BookInfo counterpart
+func (d comicInfoDocument) comic() *Comic {
+	comic := &Comic{
+		Version:             d.version,
+		Count:               d.number("count"),
+		Volume:              d.number("volume"),
+		AlternateSeries:     d.text("alternateseries"),
+		AlternateNumber:     d.text("alternatenumber"),
+		AlternateCount:      d.number("alternatecount"),
+		StoryArc:            d.text("storyarc"),
+		StoryArcNumber:      d.text("storyarcnumber"),
+		SeriesGroup:         d.text("seriesgroup"),
+		Imprint:             d.text("imprint"),
+		Format:              d.text("format"),
+		AgeRating:           d.text("agerating"),
+		Manga:               d.text("manga"),
+		BlackAndWhite:       d.text("blackandwhite"),
+		Genre:               d.list("genre"),
+		Tags:                d.list("tags"),
+		Characters:          d.list("characters"),
+		Teams:               d.list("teams"),
+		Locations:           d.list("locations"),
+		MainCharacterOrTeam: d.text("maincharacterorteam"),
+		Notes:               d.text("notes"),
+		Review:              d.text("review"),
+		ScanInformation:     d.text("scaninformation"),
+		Pages:               d.pages,
+	}
+	if rating, err := strconv.ParseFloat(d.text("communityrating"), 64); err == nil {
+		comic.CommunityRating = rating
+	}
+	// Several URLs are separated by spaces
+	if web := strings.Fields(d.text("web")); len(web) > 0 {
+		comic.Web = web
+	}
+	return comic
+}
+
+// comicInfoV21 returns the document as a v2.1 ComicInfo.xml, for it to be
+// written back
+func (d comicInfoDocument) comicInfoV21() comicinfo.ComicInfov21 {
+	ci := comicinfo.ComicInfov21{
+		Title:               d.text("title"),
+		Series:              d.text("series"),
+		Number:              d.number("number"),
+		Count:               d.number("count"),
+		Volume:              d.number("volume"),
+		AlternateSeries:     d.text("alternateseries"),
+		AlternateNumber:     d.number("alternatenumber"),
+		AlternateCount:      d.number("alternatecount"),
+		Summary:             d.text("summary"),
+		Notes:               d.text("notes"),
+		Year:                d.number("year"),
+		Month:               d.number("month"),
+		Day:                 d.number("day"),
+		Writer:              d.text("writer"),
+		Penciller:           d.text("penciller"),
+		Inker:               d.text("inker"),
+		Colorist:            d.text("colorist"),
+		Letterer:            d.text("letterer"),
+		CoverArtist:         d.text("coverartist"),
+		Editor:              d.text("editor"),
+		Translator:          d.text("translator"),
+		Publisher:           d.text("publisher"),
+		Imprint:             d.text("imprint"),
+		Genre:               d.text("genre"),
+		Tags:                d.text("tags"),
+		Web:                 d.text("web"),
+		PageCount:           d.number("pagecount"),
+		LanguageISO:         d.text("languageiso"),
+		Format:              d.text("format"),
+		BlackAndWhite:       comicinfo.YesNo(d.text("blackandwhite")),
+		Manga:               comicinfo.Manga(d.text("manga")),
+		Characters:          d.text("characters"),
+		Teams:               d.text("teams"),
+		Locations:           d.text("locations"),
+		ScanInformation:     d.text("scaninformation"),
+		StoryArc:            d.text("storyarc"),
+		StoryArcNumber:      d.text("storyarcnumber"),
+		SeriesGroup:         d.text("seriesgroup"),
+		AgeRating:           comicinfo.AgeRating(d.text("agerating")),
+		MainCharacterOrTeam: d.text("maincharacterorteam"),
+		Review:              d.text("review"),
+		GTIN:                d.text("gtin"),
+	}
+	if ci.LanguageISO == "" {
+		ci.LanguageISO = d.text("language")
+	}
+	if rating, err := strconv.ParseFloat(d.text("communityrating"), 64); err == nil {
+		r := comicinfo.CommunityRatingV21(rating)
+		ci.CommunityRating = &r
+	}
+	for _, page := range d.pages {
+		ci.Pages.Pages = append(ci.Pages.Pages, comicinfo.PageV2{
+			Image:       page.Image,
+			Type:        comicinfo.PageType(page.Type),
+			DoublePage:  page.DoublePage,
+			ImageSize:   page.ImageSize,
+			Key:         page.Key,
+			Bookmark:    page.Bookmark,
+			ImageWidth:  page.ImageWidth,
+			ImageHeight: page.ImageHeight,
+		})
+	}
+	return ci
+}
+
 // splitCommaDelimited splits a comma-delimited string and trims whitespace
 func splitCommaDelimited(s string) []string {
 	if s == "" {
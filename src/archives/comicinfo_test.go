@@ -1,9 +1,11 @@
 package archives
 
 import (
+	"encoding/xml"
 	"fmt"
 	"testing"
 
+	"github.com/hekmon/go-comicinfo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -167,3 +169,174 @@ func TestRemoveDuplicates(t *testing.T) {
 		})
 	}
 }
+
+func TestParseComicInfoComic(t *testing.T) {
+	xmlData := `<?xml version="1.0" encoding="utf-8"?>
+<ComicInfo>
+  <Series>Saga</Series>
+  <Number>1</Number>
+  <Count>54</Count>
+  <Volume>2012</Volume>
+  <AlternateSeries>Saga Deluxe</AlternateSeries>
+  <AlternateNumber>3</AlternateNumber>
+  <AlternateCount>4</AlternateCount>
+  <StoryArc>Chapter One</StoryArc>
+  <StoryArcNumber>1</StoryArcNumber>
+  <SeriesGroup>Image Originals</SeriesGroup>
+  <Imprint>Image</Imprint>
+  <Format>Series</Format>
+  <AgeRating>Adults Only 18+</AgeRating>
+  <Manga>No</Manga>
+  <BlackAndWhite>No</BlackAndWhite>
+  <CommunityRating>4.5</CommunityRating>
+  <GTIN>9781607066019</GTIN>
+  <Web>https://example.com/saga https://example.org/saga-1</Web>
+  <Genre>Science Fiction, Fantasy</Genre>
+  <Tags>space opera</Tags>
+  <Characters>Alana, Marko</Characters>
+  <MainCharacterOrTeam>Hazel</MainCharacterOrTeam>
+  <Notes>Tagged by hand</Notes>
+  <Review>Great</Review>
+  <ScanInformation>Digital</ScanInformation>
+  <Pages>
+    <Page Image="0" Type="FrontCover" ImageSize="1024" ImageWidth="1988" ImageHeight="3056"/>
+    <Page Image="1" DoublePage="true" Bookmark="Chapter 1" Key="k1"/>
+  </Pages>
+</ComicInfo>`
+
+	info, err := parseComicInfo([]byte(xmlData))
+	require.NoError(t, err)
+	assert.Equal(t, "9781607066019", info.ISBN)
+	assert.Equal(t, &Comic{
+		Version:             ComicInfoV21,
+		Count:               54,
+		Volume:              2012,
+		AlternateSeries:     "Saga Deluxe",
+		AlternateNumber:     "3",
+		AlternateCount:      4,
+		StoryArc:            "Chapter One",
+		StoryArcNumber:      "1",
+		SeriesGroup:         "Image Originals",
+		Imprint:             "Image",
+		Format:              "Series",
+		AgeRating:           "Adults Only 18+",
+		Manga:               "No",
+		BlackAndWhite:       "No",
+		CommunityRating:     4.5,
+		Web:                 []string{"https://example.com/saga", "https://example.org/saga-1"},
+		Genre:               []string{"Science Fiction", "Fantasy"},
+		Tags:                []string{"space opera"},
+		Characters:          []string{"Alana", "Marko"},
+		MainCharacterOrTeam: "Hazel",
+		Notes:               "Tagged by hand",
+		Review:              "Great",
+		ScanInformation:     "Digital",
+		Pages: []ComicPage{
+			{Image: 0, Type: "FrontCover", ImageSize: 1024, ImageWidth: 1988, ImageHeight: 3056},
+			{Image: 1, DoublePage: true, Bookmark: "Chapter 1", Key: "k1"},
+		},
+	}, info.Comic)
+
+	info, err = parseComicInfo([]byte(`<ComicInfo><Title>Plain</Title></ComicInfo>`))
+	require.NoError(t, err)
+	assert.Equal(t, &Comic{Version: ComicInfoV1}, info.Comic, "a document without other fields should only have a version")
+}
+
+func TestParseComicInfoVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		xmlData  string
+		expected string
+	}{
+		{"v1.0 elements", `<ComicInfo><Title>T</Title><Year>2001</Year></ComicInfo>`, ComicInfoV1},
+		{"v2.0 elements", `<ComicInfo><Title>T</Title><StoryArc>Arc</StoryArc></ComicInfo>`, ComicInfoV2},
+		{"v2.0 bookmark", `<ComicInfo><Pages><Page Image="0" Bookmark="Start"/></Pages></ComicInfo>`, ComicInfoV2},
+		{"v2.1 elements", `<ComicInfo><Title>T</Title><Day>1</Day><GTIN>9781607066019</GTIN></ComicInfo>`, ComicInfoV21},
+		{"empty v2.1 elements", `<ComicInfo><Title>T</Title><Translator></Translator></ComicInfo>`, ComicInfoV1},
+		{"schema location", `<ComicInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+  xsi:noNamespaceSchemaLocation="https://raw.githubusercontent.com/anansi-project/comicinfo/main/schema/v2.0/ComicInfo.xsd">
+  <Title>T</Title></ComicInfo>`, ComicInfoV2},
+		{"written by the library", string(mustMarshalComicInfo(t, comicinfo.ComicInfov21{Title: "T"})), ComicInfoV21},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseComicInfo([]byte(tt.xmlData))
+			require.NoError(t, err)
+			require.NotNil(t, info.Comic)
+			assert.Equal(t, tt.expected, info.Comic.Version)
+		})
+	}
+}
+
+func TestParseComicInfoTolerant(t *testing.T) {
+	utf16 := []byte{0xFF, 0xFE}
+	for _, r := range `<?xml version="1.0" encoding="utf-16"?><ComicInfo><Title>Café</Title></ComicInfo>` {
+		utf16 = append(utf16, byte(r), byte(r>>8))
+	}
+
+	tests := []struct {
+		name    string
+		xmlData []byte
+	}{
+		{"byte order mark", append([]byte{0xEF, 0xBB, 0xBF}, `<?xml version="1.0" encoding="utf-8"?><ComicInfo><Title>Café</Title></ComicInfo>`...)},
+		{"lowercase elements", []byte(`<comicinfo><title>Café</title></comicinfo>`)},
+		{"UTF-16", utf16},
+		{"invalid UTF-8", []byte("<?xml version=\"1.0\" encoding=\"utf-8\"?><ComicInfo><Title>Caf\xe9</Title></ComicInfo>")},
+		{"declared encoding", []byte("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><ComicInfo><Title>Caf\xe9</Title></ComicInfo>")},
+		{"HTML entity", []byte(`<ComicInfo><Title>Caf&eacute;</Title></ComicInfo>`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseComicInfo(tt.xmlData)
+			require.NoError(t, err)
+			assert.Equal(t, "Café", info.Title)
+		})
+	}
+
+	t.Run("non-standard values", func(t *testing.T) {
+		info, err := parseComicInfo([]byte(`<ComicInfo>
+  <series>Saga</series>
+  <number>1.5</number>
+  <Year>2012.0</Year>
+  <Month>oops</Month>
+  <Language>fr</Language>
+  <Rating>5</Rating>
+  <pages><page image="3" type="FrontCover" doublepage="True"/></pages>
+</ComicInfo>`))
+		require.NoError(t, err)
+		assert.Equal(t, "Saga #1.5", info.Title)
+		assert.Equal(t, "1.5", info.SeriesIndex)
+		assert.Equal(t, "2012", info.PublishedDate)
+		assert.Equal(t, []string{"fr"}, info.Language)
+		assert.Equal(t, []ComicPage{{Image: 3, Type: "FrontCover", DoublePage: true}}, info.Comic.Pages)
+	})
+
+	t.Run("other root", func(t *testing.T) {
+		_, err := parseComicInfo([]byte(`<html><title>Not a comic</title></html>`))
+		assert.Error(t, err)
+	})
+}
+
+func TestComicInfoV21RoundTrip(t *testing.T) {
+	rating := comicinfo.CommunityRatingV21(4.5)
+	ci := comicinfo.ComicInfov21{
+		Title: "Title", Series: "Series", Number: 2, Count: 10, Volume: 1, Year: 2020, Month: 5,
+		Writer: "Jane Doe", Penciller: "John Doe", CoverArtist: "Ann Smith", Publisher: "Publisher",
+		Imprint: "Imprint", Genre: "Comedy", Web: "https://example.com", PageCount: 2, Format: "TPB",
+		BlackAndWhite: comicinfo.Yes, Manga: comicinfo.MangaYesAndRightToLeft, CommunityRating: &rating,
+		Pages: comicinfo.PagesV2{Pages: []comicinfo.PageV2{{Image: 0, Type: comicinfo.PageTypeFrontCover, Key: "k"}}},
+	}
+
+	doc, err := decodeComicInfo(mustMarshalComicInfo(t, ci))
+	require.NoError(t, err)
+	assert.Equal(t, ci, doc.comicInfoV21())
+}
+
+func mustMarshalComicInfo(t *testing.T, ci comicinfo.ComicInfov21) []byte {
+	t.Helper()
+	data, err := xml.Marshal(ci)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,365 @@
+package archives
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hekmon/go-comicinfo"
+)
+
+// comicInfoName is the name of the ComicInfo.xml added to archives without one
+const comicInfoName = "ComicInfo.xml"
+
+// Attributes of the root element of the ComicInfo.xml written, as written by
+// go-comicinfo for v2.1
+const (
+	xmlSchemaInstance          = "http://www.w3.org/2001/XMLSchema-instance"
+	comicInfoV21SchemaLocation = "https://github.com/anansi-project/comicinfo/raw/refs/heads/main/drafts/v2.1/ComicInfo.xsd"
+)
+
+// comicInfoDate matches the dates written in ComicInfo.xml: a year, optionally
+// followed by the month and day, and a time that is ignored
+var comicInfoDate = regexp.MustCompile(`^(\d{4})(?:-(\d{1,2})(?:-(\d{1,2}))?)?(?:[T ].*)?$`)
+
+// WriteComicInfo stores the metadata of a book in the ComicInfo.xml of a CBZ
+// archive. The fields of ComicInfo.xml read into BookInfo are replaced by the
+// ones of info, the others (e.g. the pages, or the role of each creator when
+// the authors did not change) are kept. See WriteComicInfoV21 for the way the
+// archive is written.
+func WriteComicInfo(path string, info BookInfo) error {
+	return WriteComicInfoContext(context.Background(), path, info)
+}
+
+// WriteComicInfoContext is like WriteComicInfo, it stops between two entries
+// and returns the context error when ctx is done, leaving the archive untouched
+func WriteComicInfoContext(ctx context.Context, path string, info BookInfo) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("failed to open CBZ archive: %w", err)
+	}
+	defer r.Close()
+
+	var ci comicInfoFile
+	var current BookInfo
+	existing := findComicInfoZIP(r.File)
+	if existing != nil {
+		data, err := readZIPFile(existing)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %w", existing.Name, err)
+		}
+		// An unreadable ComicInfo.xml is replaced, the others are written
+		// back as v2.1
+		if doc, err := decodeComicInfo(data); err == nil {
+			ci, current = doc.comicInfoFile(), doc.bookInfo()
+		}
+	}
+
+	ci, err = comicInfoFromBookInfo(ci, current, info)
+	if err != nil {
+		return err
+	}
+	return writeComicInfoZIP(ctx, path, &r.Reader, existing, ci)
+}
+
+// WriteComicInfoV21 replaces the ComicInfo.xml of a CBZ archive, or adds it
+// at the end of the archive. The archive is written to a temporary file, then
+// renamed over the original one: the other entries are copied without being
+// recompressed, in the same order.
+func WriteComicInfoV21(path string, ci comicinfo.ComicInfov21) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("failed to open CBZ archive: %w", err)
+	}
+	defer r.Close()
+
+	return writeComicInfoZIP(context.Background(), path, &r.Reader, findComicInfoZIP(r.File), newComicInfoFile(ci))
+}
+
+// comicInfoFile is a ComicInfo.xml to write. ComicInfov21 only stores whole
+// numbers: Number and AlternateNumber replace its own ones, so that the
+// numbers of existing files such as "12.5" are written back as they are.
+type comicInfoFile struct {
+	comicinfo.ComicInfov21
+	Number          string
+	AlternateNumber string
+}
+
+// newComicInfoFile returns the file of ci
+func newComicInfoFile(ci comicinfo.ComicInfov21) comicInfoFile {
+	f := comicInfoFile{ComicInfov21: ci}
+	if ci.Number != 0 {
+		f.Number = strconv.Itoa(ci.Number)
+	}
+	if ci.AlternateNumber != 0 {
+		f.AlternateNumber = strconv.Itoa(ci.AlternateNumber)
+	}
+	return f
+}
+
+// comicInfoFile returns the document as a v2.1 file, with its numbers as
+// written
+func (d comicInfoDocument) comicInfoFile() comicInfoFile {
+	return comicInfoFile{
+		ComicInfov21:    d.comicInfoV21(),
+		Number:          d.text("number"),
+		AlternateNumber: d.text("alternatenumber"),
+	}
+}
+
+// comicInfoV21Fields are the fields of ComicInfov21, without its MarshalXML
+type comicInfoV21Fields comicinfo.ComicInfov21
+
+// comicInfoFileXML encodes a comicInfoFile. The fields up to AlternateNumber
+// come before the embedded ones, which they replace, keeping the order of
+// the schema.
+type comicInfoFileXML struct {
+	XMLName         xml.Name `xml:"ComicInfo"`
+	XSI             string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation  string   `xml:"xsi:schemaLocation,attr"`
+	Title           string   `xml:"Title,omitempty"`
+	Series          string   `xml:"Series,omitempty"`
+	Number          string   `xml:"Number,omitempty"`
+	Count           int      `xml:"Count,omitempty"`
+	Volume          int      `xml:"Volume,omitempty"`
+	AlternateSeries string   `xml:"AlternateSeries,omitempty"`
+	AlternateNumber string   `xml:"AlternateNumber,omitempty"`
+	comicInfoV21Fields
+}
+
+// findComicInfoZIP returns the ComicInfo.xml entry read by getBookInfoCB
+func findComicInfoZIP(files []*zip.File) *zip.File {
+	for _, f := range files {
+		if strings.EqualFold(filepath.Base(f.Name), comicInfoName) {
+			return f
+		}
+	}
+	return nil
+}
+
+func readZIPFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// encodeComicInfo encodes ci without validating it, as the pages of existing
+// files often miss their dimensions
+func encodeComicInfo(ci comicInfoFile) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	err := enc.Encode(comicInfoFileXML{
+		XSI:                xmlSchemaInstance,
+		SchemaLocation:     comicInfoV21SchemaLocation,
+		Title:              ci.Title,
+		Series:             ci.Series,
+		Number:             ci.Number,
+		Count:              ci.Count,
+		Volume:             ci.Volume,
+		AlternateSeries:    ci.AlternateSeries,
+		AlternateNumber:    ci.AlternateNumber,
+		comicInfoV21Fields: comicInfoV21Fields(ci.ComicInfov21),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ComicInfo.xml: %w", err)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// writeComicInfoZIP rewrites the archive at path with ci in place of the
+// existing entry, or after the last entry when existing is nil
+func writeComicInfoZIP(ctx context.Context, path string, r *zip.Reader, existing *zip.File, ci comicInfoFile) (err error) {
+	data, err := encodeComicInfo(ci)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat archive: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary archive: %w", err)
+	}
+	defer func() {
+		// Do not leave a partial archive behind
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := zip.NewWriter(tmp)
+	if err := w.SetComment(r.Comment); err != nil {
+		return fmt.Errorf("failed to copy archive comment: %w", err)
+	}
+
+	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if f == existing {
+			if err := writeZIPEntry(w, f.Name, zip.Deflate, data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.Copy(f); err != nil {
+			return fmt.Errorf("failed to copy '%s': %w", f.Name, err)
+		}
+	}
+	if existing == nil {
+		if err := writeZIPEntry(w, comicInfoName, zip.Deflate, data); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := tmp.Chmod(stat.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set archive permissions: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace archive: %w", err)
+	}
+	return nil
+}
+
+// comicInfoFromBookInfo stores info in ci, current being ci as read into a
+// BookInfo. The fields that are combined when reading a BookInfo (the
+// creators, the keywords) are only replaced when they would be read
+// differently from info. Changed contributors are written with their role,
+// otherwise changed authors are written as writers. The series index is only
+// checked when it changed, so that the ones of the file are kept.
+func comicInfoFromBookInfo(ci comicInfoFile, current, info BookInfo) (comicInfoFile, error) {
+	ci.Series = info.Series
+	index := current.SeriesIndex
+	if info.SeriesIndex != current.SeriesIndex {
+		number, err := parseComicInfoNumber(info.SeriesIndex)
+		if err != nil {
+			return ci, err
+		}
+		ci.Number, index = number, number
+	}
+
+	// Keep the title empty when it is read from the series
+	if ci.Title != "" || comicInfoSeriesTitle(ci.Series, index) != info.Title {
+		ci.Title = info.Title
+	}
+
+	ci.Summary = info.Description
+	ci.Publisher = info.Publisher
+	ci.GTIN = info.ISBN
+	ci.PageCount = info.Pages
+
+	switch {
+	case len(info.Contributors) > 0 && !slices.Equal(info.Contributors, current.Contributors):
+		setComicInfoCreators(&ci.ComicInfov21, info.Contributors)
+	case !slices.Equal(info.Authors, current.Authors):
+		ci.Writer = strings.Join(info.Authors, ", ")
+		ci.Penciller, ci.Inker, ci.Colorist, ci.Letterer, ci.Editor, ci.Translator = "", "", "", "", "", ""
+	}
+
+	if !slices.Equal(info.Keywords, current.Keywords) {
+		ci.Tags = strings.Join(info.Keywords, ", ")
+		ci.Genre, ci.Characters, ci.Teams, ci.Locations = "", "", "", ""
+	}
+
+	ci.LanguageISO = ""
+	if len(info.Language) > 0 {
+		ci.LanguageISO = info.Language[0]
+	}
+
+	if info.PublishedDate != current.PublishedDate {
+		year, month, day, err := parseComicInfoDate(info.PublishedDate)
+		if err != nil {
+			return ci, err
+		}
+		ci.Year, ci.Month, ci.Day = year, month, day
+	}
+
+	return ci, nil
+}
+
+// setComicInfoCreators replaces the creators of ci by the contributors. The
+// roles without a ComicInfo field are written as writers, the illustrators
+// and artists as pencillers.
+func setComicInfoCreators(ci *comicinfo.ComicInfov21, contributors []Contributor) {
+	fields := map[string]*string{
+		RoleWriter:      &ci.Writer,
+		RolePenciller:   &ci.Penciller,
+		RoleIllustrator: &ci.Penciller,
+		RoleArtist:      &ci.Penciller,
+		RoleInker:       &ci.Inker,
+		RoleColorist:    &ci.Colorist,
+		RoleLetterer:    &ci.Letterer,
+		RoleCoverArtist: &ci.CoverArtist,
+		RoleEditor:      &ci.Editor,
+		RoleTranslator:  &ci.Translator,
+	}
+	names := map[*string][]string{}
+	for _, c := range contributors {
+		field, ok := fields[c.Role]
+		if !ok {
+			field = &ci.Writer
+		}
+		names[field] = append(names[field], c.Name)
+	}
+	for _, field := range fields {
+		*field = strings.Join(removeDuplicates(names[field]), ", ")
+	}
+}
+
+// parseComicInfoNumber checks the index of a book in its series, which
+// ComicInfo stores as text, e.g. "12.5" or "1a". An empty index removes it.
+func parseComicInfoNumber(index string) (string, error) {
+	index = strings.TrimSpace(index)
+	if number, err := strconv.ParseFloat(index, 64); err == nil && number < 0 {
+		return "", fmt.Errorf("invalid series index %q: must not be negative", index)
+	}
+	return index, nil
+}
+
+// parseComicInfoDate splits a date such as "2006", "2006-01" or "2006-01-02"
+func parseComicInfoDate(date string) (year, month, day int, err error) {
+	date = strings.TrimSpace(date)
+	if date == "" {
+		return 0, 0, 0, nil
+	}
+
+	m := comicInfoDate.FindStringSubmatch(date)
+	if m == nil {
+		return 0, 0, 0, fmt.Errorf("invalid published date %q: expected YYYY, YYYY-MM or YYYY-MM-DD", date)
+	}
+	year, _ = strconv.Atoi(m[1])
+	month, _ = strconv.Atoi(m[2])
+	day, _ = strconv.Atoi(m[3])
+	if month > 12 || day > 31 || (m[2] != "" && month == 0) || (m[3] != "" && day == 0) {
+		return 0, 0, 0, fmt.Errorf("invalid published date %q", date)
+	}
+	return year, month, day, nil
+}
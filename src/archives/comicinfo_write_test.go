@@ -0,0 +1,336 @@
+package archives
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hekmon/go-comicinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawZIPEntry is an entry of a ZIP archive as stored, before decompression
+type rawZIPEntry struct {
+	Name   string
+	Method uint16
+	Data   []byte
+}
+
+// readRawZIP returns the entries of a ZIP archive, in order, and its comment
+func readRawZIP(t *testing.T, path string) ([]rawZIPEntry, string) {
+	t.Helper()
+
+	r, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var entries []rawZIPEntry
+	for _, f := range r.File {
+		rc, err := f.OpenRaw()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		entries = append(entries, rawZIPEntry{Name: f.Name, Method: f.Method, Data: data})
+	}
+	return entries, r.Comment
+}
+
+// createTestCBZWithComment writes a CBZ archive with a stored entry and a comment
+func createTestCBZWithComment(t *testing.T, path string, entries []zipEntry) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	w := zip.NewWriter(file)
+	require.NoError(t, w.SetComment("scanned by someone"))
+	for _, entry := range entries {
+		method := zip.Deflate
+		if filepath.Ext(entry.Name) == ".png" {
+			method = zip.Store
+		}
+		f, err := w.CreateHeader(&zip.FileHeader{Name: entry.Name, Method: method})
+		require.NoError(t, err)
+		_, err = f.Write(entry.Data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+}
+
+// assertNoTempFiles checks that only the given files are left in their folder
+func assertNoTempFiles(t *testing.T, paths ...string) {
+	t.Helper()
+
+	files, err := os.ReadDir(filepath.Dir(paths[0]))
+	require.NoError(t, err)
+	var names, want []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	for _, path := range paths {
+		want = append(want, filepath.Base(path))
+	}
+	assert.ElementsMatch(t, want, names, "temporary files should be removed")
+}
+
+func TestWriteComicInfoInsert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	createTestCBZWithComment(t, path, []zipEntry{
+		{Name: "pages/", Data: nil},
+		{Name: "pages/02.png", Data: testPNG(t, 10, 20)},
+		{Name: "pages/01.png", Data: testPNG(t, 30, 40)},
+		{Name: "notes.txt", Data: []byte("some notes")},
+	})
+	require.NoError(t, os.Chmod(path, 0640))
+	before, comment := readRawZIP(t, path)
+
+	info := BookInfo{
+		Title:         "The Book",
+		Series:        "The Series",
+		SeriesIndex:   "3",
+		Description:   "A story",
+		Authors:       []string{"Jane Doe", "John Doe"},
+		Publisher:     "Publisher",
+		PublishedDate: "2024-05-06",
+		ISBN:          "9781234567897",
+		Language:      []string{"en"},
+		Keywords:      []string{"comedy", "school"},
+		Pages:         2,
+	}
+	require.NoError(t, WriteComicInfo(path, info))
+
+	after, afterComment := readRawZIP(t, path)
+	require.Len(t, after, len(before)+1)
+	assert.Equal(t, before, after[:len(before)], "entries should be copied as-is")
+	assert.Equal(t, comicInfoName, after[len(before)].Name, "ComicInfo.xml should be added at the end")
+	assert.Equal(t, comment, afterComment)
+
+	got, err := GetBookInfo(path)
+	require.NoError(t, err)
+	info.Contributors = []Contributor{newContributor("Jane Doe", RoleWriter), newContributor("John Doe", RoleWriter)}
+	info.Comic = &Comic{Version: ComicInfoV21, Tags: []string{"comedy", "school"}}
+	assert.Equal(t, info, got, "metadata should be read back")
+
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), stat.Mode().Perm(), "permissions should be kept")
+	assertNoTempFiles(t, path)
+}
+
+func TestWriteComicInfoReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	createTestCBZWithComment(t, path, []zipEntry{
+		{Name: "01.png", Data: testPNG(t, 10, 20)},
+		{Name: "comicinfo.xml", Data: []byte(`<?xml version="1.0"?>
+<ComicInfo>
+  <Series>Series</Series>
+  <Number>1</Number>
+  <Writer>Jane Doe</Writer>
+  <Penciller>John Doe</Penciller>
+  <Genre>Comedy</Genre>
+  <Pages><Page Image="1" Type="FrontCover"/></Pages>
+</ComicInfo>`)},
+		{Name: "02.png", Data: testPNG(t, 30, 40)},
+	})
+	before, _ := readRawZIP(t, path)
+
+	r, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	data, err := readZIPFile(findComicInfoZIP(r.File))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	info, err := parseComicInfo(data)
+	require.NoError(t, err)
+	assert.Equal(t, "Series #1", info.Title)
+
+	info.Description = "A story"
+	require.NoError(t, WriteComicInfo(path, info))
+
+	after, _ := readRawZIP(t, path)
+	require.Len(t, after, 3)
+	assert.Equal(t, before[0], after[0])
+	assert.Equal(t, "comicinfo.xml", after[1].Name, "ComicInfo.xml should keep its name and position")
+	assert.Equal(t, before[2], after[2])
+
+	r, err = zip.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err = readZIPFile(r.File[1])
+	require.NoError(t, err)
+
+	got, err := parseComicInfo(data)
+	require.NoError(t, err)
+	assert.Equal(t, "A story", got.Description)
+	require.NotNil(t, got.Comic)
+	assert.Equal(t, ComicInfoV21, got.Comic.Version, "the file should be written as v2.1")
+	info.Comic.Version = got.Comic.Version
+	assert.Equal(t, info, BookInfo{Title: got.Title, Series: got.Series, SeriesIndex: got.SeriesIndex,
+		Authors: got.Authors, Contributors: got.Contributors, Keywords: got.Keywords, Description: got.Description, Comic: got.Comic})
+	assert.Contains(t, string(data), "<Penciller>John Doe</Penciller>", "unchanged creators should keep their role")
+	assert.NotContains(t, string(data), "<Title>", "the title should still be read from the series")
+	index, ok := comicInfoFrontCover(data)
+	assert.True(t, ok, "pages should be kept")
+	assert.Equal(t, 1, index)
+}
+
+func TestWriteComicInfoDecimalNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	createTestCBZ(t, path, []zipEntry{
+		{Name: "01.png", Data: testPNG(t, 10, 20)},
+		{Name: "ComicInfo.xml", Data: []byte(`<ComicInfo>
+  <Series>Saga</Series>
+  <Number>12.5</Number>
+  <AlternateSeries>Saga Specials</AlternateSeries>
+  <AlternateNumber>3.5</AlternateNumber>
+</ComicInfo>`)},
+	})
+
+	info, err := GetBookInfo(path)
+	require.NoError(t, err)
+	require.Equal(t, "12.5", info.SeriesIndex)
+	info.Title = "Saga, the half issue"
+	require.NoError(t, WriteComicInfo(path, info))
+
+	got, err := GetBookInfo(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Saga, the half issue", got.Title)
+	assert.Equal(t, "12.5", got.SeriesIndex, "the number should be written back as-is")
+	require.NotNil(t, got.Comic)
+	assert.Equal(t, "3.5", got.Comic.AlternateNumber, "the alternate number should be written back as-is")
+
+	r, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := readZIPFile(findComicInfoZIP(r.File))
+	require.NoError(t, err)
+	assert.Regexp(t, `(?s)<Series>Saga</Series>\s*<Number>12.5</Number>.*<AlternateSeries>Saga Specials</AlternateSeries>\s*<AlternateNumber>3.5</AlternateNumber>`,
+		string(data), "the elements should keep the order of the schema")
+}
+
+func TestWriteComicInfoChangedFields(t *testing.T) {
+	doc, err := decodeComicInfo([]byte(`<ComicInfo><Writer>Jane Doe</Writer><Penciller>John Doe</Penciller>
+  <Genre>Comedy</Genre><Tags>school</Tags><Year>2020</Year></ComicInfo>`))
+	require.NoError(t, err)
+	ci, current := doc.comicInfoFile(), doc.bookInfo()
+	got, err := comicInfoFromBookInfo(ci, current, BookInfo{
+		Title:         "Title",
+		Authors:       []string{"Someone Else"},
+		Keywords:      []string{"comedy"},
+		PublishedDate: "2021-02",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Title", got.Title)
+	assert.Equal(t, "Someone Else", got.Writer)
+	assert.Empty(t, got.Penciller, "creators should be replaced by the authors")
+	assert.Equal(t, "comedy", got.Tags)
+	assert.Empty(t, got.Genre, "keywords should be replaced")
+	assert.Equal(t, []int{2021, 2, 0}, []int{got.Year, got.Month, got.Day})
+
+	got, err = comicInfoFromBookInfo(ci, current, BookInfo{SeriesIndex: " 1.5 "})
+	require.NoError(t, err)
+	assert.Equal(t, "1.5", got.Number)
+
+	_, err = comicInfoFromBookInfo(ci, current, BookInfo{SeriesIndex: "-1"})
+	assert.ErrorContains(t, err, "negative")
+
+	// The decimal index of the file is not checked when it did not change
+	doc, err = decodeComicInfo([]byte(`<ComicInfo><Series>Saga</Series><Number>12.5</Number></ComicInfo>`))
+	require.NoError(t, err)
+	current = doc.bookInfo()
+	require.Equal(t, "12.5", current.SeriesIndex)
+	info := current
+	info.Title = "Another Title"
+	got, err = comicInfoFromBookInfo(doc.comicInfoFile(), current, info)
+	require.NoError(t, err)
+	assert.Equal(t, "Another Title", got.Title)
+
+	got, err = comicInfoFromBookInfo(doc.comicInfoFile(), current, current)
+	require.NoError(t, err)
+	assert.Empty(t, got.Title, "the title should still be read from the series")
+}
+
+func TestParseComicInfoDate(t *testing.T) {
+	tests := []struct {
+		date    string
+		want    []int
+		wantErr bool
+	}{
+		{"", []int{0, 0, 0}, false},
+		{"2006", []int{2006, 0, 0}, false},
+		{"2006-01", []int{2006, 1, 0}, false},
+		{"2006-01-02", []int{2006, 1, 2}, false},
+		{"2006-01-02T15:04:05Z", []int{2006, 1, 2}, false},
+		{"2006-13", nil, true},
+		{"2006-00-01", nil, true},
+		{"January 2006", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			year, month, day, err := parseComicInfoDate(tt.date)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, []int{year, month, day})
+		})
+	}
+}
+
+func TestWriteComicInfoV21(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	createTestCBZ(t, path, []zipEntry{{Name: "01.png", Data: testPNG(t, 10, 20)}})
+
+	ci := comicinfo.ComicInfov21{
+		Title:     "Title",
+		Manga:     comicinfo.MangaYesAndRightToLeft,
+		AgeRating: comicinfo.AgeRatingTeen,
+		Pages:     comicinfo.PagesV2{Pages: []comicinfo.PageV2{{Image: 0, Type: comicinfo.PageTypeFrontCover}}},
+	}
+	require.NoError(t, WriteComicInfoV21(path, ci))
+
+	r, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := readZIPFile(findComicInfoZIP(r.File))
+	require.NoError(t, err)
+
+	var got comicinfo.ComicInfov21
+	require.NoError(t, xml.Unmarshal(data, &got))
+	assert.Equal(t, ci, got)
+	assertNoTempFiles(t, path)
+}
+
+func TestWriteComicInfoErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.cbz")
+	require.NoError(t, os.WriteFile(path, []byte("Rar!\x1a\x07\x00 not a zip"), 0644))
+	assert.Error(t, WriteComicInfo(path, BookInfo{Title: "Title"}), "should not write into other archives")
+
+	err := WriteBookInfo(path, BookInfo{Title: "Title"})
+	assert.ErrorContains(t, err, "not supported", "RAR content should be detected")
+
+	path = filepath.Join(t.TempDir(), "book.cbz")
+	createTestCBZ(t, path, []zipEntry{{Name: "01.png", Data: testPNG(t, 10, 20)}})
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	assert.Error(t, WriteComicInfo(path, BookInfo{PublishedDate: "someday"}))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	assert.ErrorIs(t, WriteComicInfoContext(ctx, path, BookInfo{Title: "Title"}), context.Canceled)
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, before, after, "archive should be untouched")
+	assertNoTempFiles(t, path)
+}
@@ -0,0 +1,115 @@
+package archives
+
+import (
+	"strings"
+)
+
+// Roles of the contributors of a book, named after the creators of
+// ComicInfo.xml
+const (
+	RoleWriter      = "writer"
+	RolePenciller   = "penciller"
+	RoleInker       = "inker"
+	RoleColorist    = "colorist"
+	RoleLetterer    = "letterer"
+	RoleCoverArtist = "cover_artist"
+	RoleEditor      = "editor"
+	RoleTranslator  = "translator"
+	RoleIllustrator = "illustrator"
+	RoleArtist      = "artist"
+	RoleNarrator    = "narrator"
+	RoleContributor = "contributor"
+)
+
+// marcRelators are the MARC relator codes of the roles, as used by EPUB, see
+// https://id.loc.gov/vocabulary/relators. Letterers have no code.
+var marcRelators = map[string]string{
+	RoleWriter:      "aut",
+	RolePenciller:   "pnc",
+	RoleInker:       "ink",
+	RoleColorist:    "clr",
+	RoleCoverArtist: "cov",
+	RoleEditor:      "edt",
+	RoleTranslator:  "trl",
+	RoleIllustrator: "ill",
+	RoleArtist:      "art",
+	RoleNarrator:    "nrt",
+	RoleContributor: "ctb",
+}
+
+// Contributor is a person or an organization credited for a book
+type Contributor struct {
+	Name string `json:"name"`
+
+	// Role is one of the Role constants, or the role found in the metadata
+	// when it has no constant. Empty when unknown.
+	Role string `json:"role,omitempty"`
+
+	// MARCRelator is the MARC relator code of the role, e.g. "aut"
+	MARCRelator string `json:"marc_relator,omitempty"`
+}
+
+// newContributor returns a contributor with the MARC relator code of its role
+func newContributor(name, role string) Contributor {
+	return Contributor{Name: name, Role: role, MARCRelator: marcRelators[role]}
+}
+
+// parseContributorRole reads the role of a contributor, given either as a
+// MARC relator code ("aut", "marc:aut") or as a term ("Author", "illustrator").
+// The codes and terms without a Role constant are kept as-is.
+func parseContributorRole(name, role string) Contributor {
+	role = strings.ToLower(strings.TrimSpace(role))
+	role = strings.TrimPrefix(role, "marc:")
+	if role == "author" {
+		role = RoleWriter
+	}
+
+	for known, code := range marcRelators {
+		if role == code || role == known {
+			return newContributor(name, known)
+		}
+	}
+	if role == RoleLetterer {
+		return newContributor(name, role)
+	}
+	if len(role) == 3 {
+		return Contributor{Name: name, MARCRelator: role}
+	}
+	return Contributor{Name: name, Role: role}
+}
+
+// comicInfoCreator is a creator field of ComicInfo.xml with its role
+type comicInfoCreator struct {
+	role  string
+	names string
+}
+
+// comicInfoContributors lists the comma separated names of the creators of
+// ComicInfo.xml, in order and without duplicates
+func comicInfoContributors(creators ...comicInfoCreator) []Contributor {
+	var contributors []Contributor
+	seen := map[Contributor]bool{}
+	for _, creator := range creators {
+		for _, name := range splitCommaDelimited(creator.names) {
+			c := newContributor(name, creator.role)
+			if !seen[c] {
+				seen[c] = true
+				contributors = append(contributors, c)
+			}
+		}
+	}
+	return contributors
+}
+
+// comicInfoAuthors lists the comma separated names of the creators of
+// ComicInfo.xml, in order and without duplicates, leaving out the cover
+// artists who are not authors of the story
+func comicInfoAuthors(creators ...comicInfoCreator) []string {
+	var authors []string
+	for _, creator := range creators {
+		if creator.role != RoleCoverArtist {
+			authors = append(authors, splitCommaDelimited(creator.names)...)
+		}
+	}
+	return removeDuplicates(authors)
+}
@@ -0,0 +1,109 @@
+package archives
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContributorRole(t *testing.T) {
+	tests := []struct {
+		role string
+		want Contributor
+	}{
+		{"aut", Contributor{Name: "Jane", Role: RoleWriter, MARCRelator: "aut"}},
+		{"marc:ill", Contributor{Name: "Jane", Role: RoleIllustrator, MARCRelator: "ill"}},
+		{" Author ", Contributor{Name: "Jane", Role: RoleWriter, MARCRelator: "aut"}},
+		{"Translator", Contributor{Name: "Jane", Role: RoleTranslator, MARCRelator: "trl"}},
+		{"letterer", Contributor{Name: "Jane", Role: RoleLetterer}},
+		{"pbl", Contributor{Name: "Jane", MARCRelator: "pbl"}},
+		{"proofreader", Contributor{Name: "Jane", Role: "proofreader"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseContributorRole("Jane", tt.role))
+		})
+	}
+}
+
+func TestComicInfoContributors(t *testing.T) {
+	info, err := parseComicInfo([]byte(`<ComicInfo>
+  <Writer>Stan Lee, Stan Lee</Writer>
+  <Penciller>Steve Ditko</Penciller>
+  <Letterer>Artie Simek</Letterer>
+  <CoverArtist>Steve Ditko</CoverArtist>
+  <Translator>Jean Dupont</Translator>
+</ComicInfo>`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Contributor{
+		{Name: "Stan Lee", Role: RoleWriter, MARCRelator: "aut"},
+		{Name: "Steve Ditko", Role: RolePenciller, MARCRelator: "pnc"},
+		{Name: "Artie Simek", Role: RoleLetterer},
+		{Name: "Steve Ditko", Role: RoleCoverArtist, MARCRelator: "cov"},
+		{Name: "Jean Dupont", Role: RoleTranslator, MARCRelator: "trl"},
+	}, info.Contributors, "the role of each creator should be kept")
+	assert.Equal(t, []string{"Stan Lee", "Steve Ditko", "Artie Simek", "Jean Dupont"}, info.Authors,
+		"authors should still combine the creators")
+}
+
+func TestWriteComicInfoContributors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	createTestCBZ(t, path, []zipEntry{{Name: "01.png", Data: testPNG(t, 10, 20)}})
+
+	contributors := []Contributor{
+		newContributor("Jane Doe", RoleWriter),
+		newContributor("John Doe", RoleInker),
+		newContributor("Ann Smith", RoleIllustrator),
+	}
+	require.NoError(t, WriteComicInfo(path, BookInfo{Title: "Title", Contributors: contributors}))
+
+	info, err := GetBookInfo(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Contributor{
+		newContributor("Jane Doe", RoleWriter),
+		newContributor("Ann Smith", RolePenciller),
+		newContributor("John Doe", RoleInker),
+	}, info.Contributors, "illustrators should be written as pencillers")
+	assert.Equal(t, []string{"Jane Doe", "Ann Smith", "John Doe"}, info.Authors)
+}
+
+func TestGetBookInfoEPUBContributors(t *testing.T) {
+	opf := `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" xmlns:opf="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Test</dc:title>
+    <dc:creator id="writer">Jane Doe</dc:creator>
+    <meta refines="#writer" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator opf:role="ill">John Doe</dc:creator>
+    <dc:creator>Ann Smith</dc:creator>
+    <dc:contributor id="translator">Jean Dupont</dc:contributor>
+    <meta refines="#translator" property="role" scheme="marc:relators">trl</meta>
+    <dc:contributor>Someone Else</dc:contributor>
+  </metadata>
+  <manifest><item id="intro" href="intro.xhtml" media-type="application/xhtml+xml"/></manifest>
+  <spine><itemref idref="intro"/></spine>
+</package>`
+	path := filepath.Join(t.TempDir(), "book.epub")
+	require.NoError(t, os.WriteFile(path, testZIP(t,
+		zipEntry{"mimetype", []byte("application/epub+zip")},
+		zipEntry{"META-INF/container.xml", []byte(testEPUBContainer)},
+		zipEntry{"OEBPS/content.opf", []byte(opf)},
+		zipEntry{"OEBPS/intro.xhtml", []byte(`<html><body>Intro</body></html>`)},
+	), 0644))
+
+	info, err := GetBookInfo(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Jane Doe", "John Doe", "Ann Smith"}, info.Authors, "authors should list the creators")
+	assert.Equal(t, []Contributor{
+		newContributor("Jane Doe", RoleWriter),
+		newContributor("John Doe", RoleIllustrator),
+		newContributor("Ann Smith", RoleWriter),
+		newContributor("Jean Dupont", RoleTranslator),
+		newContributor("Someone Else", RoleContributor),
+	}, info.Contributors)
+}
@@ -0,0 +1,311 @@
+package archives
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gen2brain/go-unarr"
+	"github.com/hekmon/go-comicinfo"
+	"github.com/maruel/natural"
+)
+
+// convertBufferSize is the size of the chunks read from the source archive
+const convertBufferSize = 64 * 1024
+
+// archiveEntry is a file of an archive opened by unarr
+type archiveEntry struct {
+	name   string
+	offset int64
+}
+
+// ConvertOptions control the conversion of a book
+type ConvertOptions struct {
+	// Render is used for the pages of PDF files, the images of comic book
+	// archives are copied as-is
+	Render RenderOptions
+}
+
+// ConvertToCBZ converts a comic book archive (CBR, CB7, CBT, or a CBZ), a PDF
+// file, or a book of another ConvertHandler, into a new CBZ archive and
+// returns its number of pages
+func ConvertToCBZ(inputFile, outputFile string, opts ConvertOptions) (int, error) {
+	return ConvertToCBZContext(context.Background(), inputFile, outputFile, opts)
+}
+
+// ConvertToCBZContext converts a book into a new CBZ archive.
+//
+// The entries of comic book archives are copied: the images are stored
+// uncompressed in natural order, followed by the other files such as
+// ComicInfo.xml. The pages of PDF files are rendered with opts.Render,
+// followed by a ComicInfo.xml generated from the PDF metadata.
+//
+// The archive is written to a temporary file renamed to outputFile once its
+// number of pages is checked against the source: outputFile is not created
+// when the conversion fails or ctx is done.
+func ConvertToCBZContext(ctx context.Context, inputFile, outputFile string, opts ConvertOptions) (int, error) {
+	if err := opts.Render.Validate(); err != nil {
+		return 0, err
+	}
+	if _, err := os.Lstat(outputFile); err == nil {
+		return 0, fmt.Errorf("output file '%s' already exists", outputFile)
+	}
+
+	converter, ok := detect(inputFile).(ConvertHandler)
+	if !ok {
+		return 0, fmt.Errorf("cannot convert %s to CBZ", inputFile)
+	}
+	return converter.ConvertToCBZ(ctx, inputFile, outputFile, opts)
+}
+
+// convertArchiveToCBZ copies the entries of a comic book archive
+func convertArchiveToCBZ(ctx context.Context, inputFile, outputFile string) (int, error) {
+	a, err := unarr.NewArchive(inputFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer a.Close()
+
+	entries, err := listEntriesCB(ctx, a)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list archive: %w", err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.name
+	}
+	pages := getPagesCountCB(names)
+
+	buf := make([]byte, convertBufferSize)
+	err = writeCBZ(outputFile, pages, func(w *zip.Writer) error {
+		for _, entry := range sortEntriesCB(entries) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := a.EntryAt(entry.offset); err != nil {
+				return fmt.Errorf("failed to find '%s': %w", entry.name, err)
+			}
+
+			header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+			// Images are already compressed
+			if validImage(entry.name) {
+				header.Method = zip.Store
+			}
+			// ZIP archives cannot store dates before 1980, e.g. a missing date
+			if modified := a.ModTime(); modified.Year() >= 1980 {
+				header.Modified = modified
+			}
+
+			f, err := w.CreateHeader(header)
+			if err != nil {
+				return fmt.Errorf("failed to add '%s': %w", entry.name, err)
+			}
+			if err := copyEntryCB(f, a, buf); err != nil {
+				return fmt.Errorf("failed to copy '%s': %w", entry.name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pages, nil
+}
+
+// convertPDFToCBZ renders the pages of a PDF file, opts must have its defaults set
+func convertPDFToCBZ(ctx context.Context, inputFile, outputFile string, opts RenderOptions) (int, error) {
+	doc, err := openPDF(ctx, inputFile)
+	if err != nil {
+		return 0, err
+	}
+	defer doc.Close()
+
+	info, err := doc.bookInfo(inputFile)
+	if err != nil {
+		return 0, err
+	}
+
+	err = writeCBZ(outputFile, info.Pages, func(w *zip.Writer) error {
+		digits := max(len(strconv.Itoa(info.Pages)), 2)
+		var ci comicinfo.ComicInfov21
+		for index := range info.Pages {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			img, err := doc.renderPage(index, opts)
+			if err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			if err := opts.encode(&buf, img); err != nil {
+				return fmt.Errorf("failed to encode page %d: %w", index+1, err)
+			}
+
+			name := fmt.Sprintf("page_%0*d%s", digits, index+1, opts.extension())
+			if err := writeZIPEntry(w, name, zip.Store, buf.Bytes()); err != nil {
+				return err
+			}
+
+			page := comicinfo.PageV2{
+				Image:       index,
+				Type:        comicinfo.PageTypeStory,
+				ImageSize:   buf.Len(),
+				ImageWidth:  img.Bounds().Dx(),
+				ImageHeight: img.Bounds().Dy(),
+			}
+			if index == 0 {
+				page.Type = comicinfo.PageTypeFrontCover
+			}
+			ci.Pages.Pages = append(ci.Pages.Pages, page)
+		}
+
+		// Only the pages are set, which are not read into a BookInfo
+		file, err := comicInfoFromBookInfo(newComicInfoFile(ci), BookInfo{}, info)
+		if err != nil {
+			return err
+		}
+		data, err := encodeComicInfo(file)
+		if err != nil {
+			return err
+		}
+		return writeZIPEntry(w, comicInfoName, zip.Deflate, data)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return info.Pages, nil
+}
+
+// writeZIPEntry adds a file to a ZIP archive
+func writeZIPEntry(w *zip.Writer, name string, method uint16, data []byte) error {
+	f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write '%s': %w", name, err)
+	}
+	return nil
+}
+
+// writeCBZ writes a CBZ archive with write to a temporary file, which is
+// renamed to outputFile once it is checked to hold the expected pages
+func writeCBZ(outputFile string, pages int, write func(w *zip.Writer) error) (err error) {
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return fmt.Errorf("failed to create output folder: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(outputFile), "."+filepath.Base(outputFile)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary archive: %w", err)
+	}
+	defer func() {
+		// Do not leave a partial archive behind
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := zip.NewWriter(tmp)
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	// The source may be deleted once the archive is renamed
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	written, err := countPagesZIP(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("failed to check converted archive: %w", err)
+	}
+	if written != pages {
+		return fmt.Errorf("converted archive has %d pages instead of %d", written, pages)
+	}
+
+	if err := os.Rename(tmp.Name(), outputFile); err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	return nil
+}
+
+// listEntriesCB lists the files of an archive with their offset, stopping
+// when ctx is done
+func listEntriesCB(ctx context.Context, a *unarr.Archive) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := a.Entry()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if name := a.Name(); !strings.HasSuffix(name, "/") {
+			entries = append(entries, archiveEntry{name: name, offset: a.Offset()})
+		}
+	}
+}
+
+// sortEntriesCB puts the images first, then the other files, in natural order
+func sortEntriesCB(entries []archiveEntry) []archiveEntry {
+	sorted := append([]archiveEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].name, sorted[j].name
+		if validImage(a) != validImage(b) {
+			return validImage(a)
+		}
+		return natural.Less(a, b)
+	})
+	return sorted
+}
+
+// copyEntryCB streams the current entry of an archive to w. unarr fails to
+// read past the end of an entry, the last chunk is sized from what is left.
+func copyEntryCB(w io.Writer, a *unarr.Archive, buf []byte) error {
+	for remaining := a.Size(); remaining > 0; {
+		n := min(remaining, len(buf))
+		if _, err := a.Read(buf[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return nil
+}
+
+// countPagesZIP counts the pages of a ZIP archive like getPagesCountCB
+func countPagesZIP(path string) (int, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	names := make([]string, len(r.File))
+	for i, f := range r.File {
+		names[i] = f.Name
+	}
+	return getPagesCountCB(names), nil
+}
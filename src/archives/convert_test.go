@@ -0,0 +1,166 @@
+package archives
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestCBT writes a CBT archive containing the given entries, in order
+func createTestCBT(t *testing.T, path string, entries []zipEntry) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	w := tar.NewWriter(file)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.Name, Mode: 0644, Size: int64(len(entry.Data)), Typeflag: tar.TypeReg}
+		if entry.Data == nil {
+			header.Typeflag = tar.TypeDir
+		}
+		require.NoError(t, w.WriteHeader(header))
+		_, err := w.Write(entry.Data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+}
+
+func TestConvertToCBZ(t *testing.T) {
+	comicInfo := []byte(`<ComicInfo><Title>Converted</Title></ComicInfo>`)
+	page1, page2, page10 := testPNG(t, 10, 20), testPNG(t, 20, 30), testPNG(t, 30, 40)
+	large := make([]byte, 3*convertBufferSize+123)
+	for i := range large {
+		large[i] = byte(i)
+	}
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "book.cbt")
+	createTestCBT(t, input, []zipEntry{
+		{Name: "notes.txt", Data: large},
+		{Name: "pages/", Data: nil},
+		{Name: "pages/10.png", Data: page10},
+		{Name: "ComicInfo.xml", Data: comicInfo},
+		{Name: "pages/2.png", Data: page2},
+		{Name: "pages/1.png", Data: page1},
+	})
+
+	output := filepath.Join(dir, "book.cbz")
+	pages, err := ConvertToCBZ(input, output, ConvertOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, pages)
+
+	r, err := zip.OpenReader(output)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		if validImage(f.Name) {
+			assert.Equal(t, zip.Store, f.Method, "%s should not be recompressed", f.Name)
+		}
+	}
+	assert.Equal(t, []string{"pages/1.png", "pages/2.png", "pages/10.png", "ComicInfo.xml", "notes.txt"}, names,
+		"pages should be in natural order")
+
+	for i, want := range [][]byte{page1, page2, page10, comicInfo, large} {
+		data, err := readZIPFile(r.File[i])
+		require.NoError(t, err)
+		assert.Equal(t, want, data, "content of %s", r.File[i].Name)
+	}
+
+	info, err := GetBookInfo(output)
+	require.NoError(t, err)
+	assert.Equal(t, "Converted", info.Title, "ComicInfo.xml should be carried over")
+	assertNoTempFiles(t, input, output)
+}
+
+func TestConvertToCBZErrors(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "book.cbt")
+	createTestCBT(t, input, []zipEntry{{Name: "1.png", Data: testPNG(t, 10, 20)}})
+
+	_, err := ConvertToCBZ(filepath.Join("..", "..", "fixtures", "pg11-images-3.epub"), filepath.Join(dir, "epub.cbz"), ConvertOptions{})
+	assert.ErrorContains(t, err, "cannot convert")
+
+	_, err = ConvertToCBZ(input, input, ConvertOptions{})
+	assert.ErrorContains(t, err, "already exists")
+
+	_, err = ConvertToCBZ(input, filepath.Join(dir, "book.cbz"), ConvertOptions{Render: RenderOptions{Quality: 101}})
+	assert.ErrorContains(t, err, "invalid JPEG quality")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err = ConvertToCBZContext(ctx, input, filepath.Join(dir, "book.cbz"), ConvertOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assertNoTempFiles(t, input)
+}
+
+func TestConvertPDFToCBZ(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "book.cbz")
+	opts := ConvertOptions{Render: RenderOptions{DPI: 300, MaxWidth: 400, Format: ImageFormatPNG, Grayscale: true}}
+	pages, err := ConvertToCBZ(filepath.Join("..", "..", "fixtures", "testfile.pdf"), output, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pages)
+
+	r, err := zip.OpenReader(output)
+	require.NoError(t, err)
+	defer r.Close()
+	require.Len(t, r.File, 2)
+	assert.Equal(t, "page_01.png", r.File[0].Name)
+	assert.Equal(t, zip.Store, r.File[0].Method)
+	assert.Equal(t, comicInfoName, r.File[1].Name)
+
+	data, err := readZIPFile(r.File[0])
+	require.NoError(t, err)
+	img, format, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.IsType(t, &image.Gray{}, img, "page should be in grayscale")
+	assert.Equal(t, 400, img.Bounds().Dx(), "page should be scaled down to the maximum width")
+	pageSize := len(data)
+
+	data, err = readZIPFile(r.File[1])
+	require.NoError(t, err)
+	info, err := parseComicInfo(data)
+	require.NoError(t, err)
+	assert.Equal(t, BookInfo{Title: "Title of the Book", Pages: 1, Authors: []string{"The Author"},
+		Contributors: []Contributor{{Name: "The Author", Role: RoleWriter, MARCRelator: "aut"}}, Keywords: []string{"book", "fantasy"},
+		Comic: &Comic{Version: ComicInfoV21, Tags: []string{"book", "fantasy"}, Pages: []ComicPage{
+			{Image: 0, Type: "FrontCover", ImageSize: pageSize, ImageWidth: 400, ImageHeight: img.Bounds().Dy()},
+		}}}, info)
+	index, ok := comicInfoFrontCover(data)
+	assert.True(t, ok)
+	assert.Equal(t, 0, index)
+}
+
+func TestRenderOptionsValidate(t *testing.T) {
+	assert.NoError(t, RenderOptions{}.Validate())
+	assert.NoError(t, RenderOptions{DPI: 72, MaxHeight: 2000, Format: ImageFormatJPEG, Quality: 75}.Validate())
+	assert.Error(t, RenderOptions{DPI: -1}.Validate())
+	assert.Error(t, RenderOptions{MaxWidth: -1}.Validate())
+	assert.Error(t, RenderOptions{Format: "gif"}.Validate())
+	assert.Error(t, RenderOptions{Quality: 101}.Validate())
+	assert.NoError(t, RenderOptions{Width: 800, MaxHeight: 1000}.Validate())
+	assert.Error(t, RenderOptions{Height: -1}.Validate())
+	assert.Error(t, RenderOptions{DPI: 150, Width: 800}.Validate(), "DPI and size are exclusive")
+}
+
+func TestExtractOptionsValidate(t *testing.T) {
+	assert.NoError(t, ExtractOptions{}.Validate())
+	assert.NoError(t, ExtractOptions{FirstPage: 3, LastPage: 3}.Validate())
+	assert.NoError(t, ExtractOptions{LastPage: 3}.Validate())
+	assert.Error(t, ExtractOptions{FirstPage: 4, LastPage: 3}.Validate())
+	assert.Error(t, ExtractOptions{FirstPage: -1}.Validate())
+	assert.Error(t, ExtractOptions{Render: RenderOptions{Format: "gif"}}.Validate())
+}
@@ -0,0 +1,143 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// GetCover returns the decoded cover image of a book
+func GetCover(inputFile string) (image.Image, error) {
+	cover, err := getCover(context.Background(), inputFile)
+	if err != nil {
+		return nil, err
+	}
+	if cover.Image != nil {
+		return cover.Image, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(cover.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cover image: %w", err)
+	}
+	return img, nil
+}
+
+// ExtractCover writes the cover of a book to outputFile, encoded in the format
+// implied by its extension (.jpg, .jpeg or .png).
+// Returns the written cover with its dimensions.
+func ExtractCover(inputFile, outputFile string) (Page, error) {
+	return ExtractCoverContext(context.Background(), inputFile, outputFile)
+}
+
+// ExtractCoverContext is like ExtractCover, but stops reading the book and
+// returns the context error when ctx is done
+func ExtractCoverContext(ctx context.Context, inputFile, outputFile string) (Page, error) {
+	format, err := coverFormat(outputFile)
+	if err != nil {
+		return Page{}, err
+	}
+
+	cover, err := getCover(ctx, inputFile)
+	if err != nil {
+		return Page{}, err
+	}
+
+	// Create output folder if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return Page{}, fmt.Errorf("failed to create output folder: %w", err)
+	}
+
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to create output file %s: %w", outputFile, err)
+	}
+	defer file.Close()
+
+	width, height, err := writeCover(file, cover, format)
+	if err == nil {
+		if err = file.Close(); err != nil {
+			err = fmt.Errorf("failed to write cover: %w", err)
+		}
+	}
+	if err != nil {
+		// Do not leave a truncated image behind
+		os.Remove(outputFile)
+		return Page{}, err
+	}
+
+	return Page{
+		Path:   outputFile,
+		Width:  width,
+		Height: height,
+	}, nil
+}
+
+// getCover dispatches to the cover reader of the book format
+func getCover(ctx context.Context, inputFile string) (CoverImage, error) {
+	if err := ctx.Err(); err != nil {
+		return CoverImage{}, err
+	}
+
+	covers, ok := detect(inputFile).(CoverHandler)
+	if !ok {
+		return CoverImage{}, fmt.Errorf("unsupported file format: %s", filepath.Ext(inputFile))
+	}
+	return covers.GetCover(ctx, inputFile)
+}
+
+// coverFormat returns the image format matching the extension of the output file
+func coverFormat(outputFile string) (string, error) {
+	switch getFileExtension(outputFile) {
+	case "jpg", "jpeg":
+		return "jpeg", nil
+	case "png":
+		return "png", nil
+	default:
+		return "", fmt.Errorf("unsupported cover format '%s', use .jpg or .png", filepath.Ext(outputFile))
+	}
+}
+
+// writeCover encodes the cover in the requested format. When the cover is
+// already stored in that format, its data is copied as-is to avoid a lossy
+// re-encoding.
+func writeCover(w io.Writer, cover CoverImage, format string) (int, int, error) {
+	img := cover.Image
+	if img == nil {
+		config, sourceFormat, err := image.DecodeConfig(bytes.NewReader(cover.Data))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to decode cover image: %w", err)
+		}
+
+		if sourceFormat == format {
+			if _, err := w.Write(cover.Data); err != nil {
+				return 0, 0, fmt.Errorf("failed to write cover: %w", err)
+			}
+			return config.Width, config.Height, nil
+		}
+
+		img, _, err = image.Decode(bytes.NewReader(cover.Data))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to decode cover image: %w", err)
+		}
+	}
+
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(w, img)
+	default:
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to encode cover as %s: %w", format, err)
+	}
+
+	return img.Bounds().Dx(), img.Bounds().Dy(), nil
+}
@@ -0,0 +1,129 @@
+package archives
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractCover(t *testing.T) {
+	tests := []struct {
+		name       string
+		filename   string
+		output     string
+		wantWidth  int
+		wantHeight int
+	}{
+		{"CBZ as PNG", "dummy_book.cbz", "cover.png", 844, 428},
+		{"CBZ as JPEG", "dummy_book.cbz", "cover.jpg", 844, 428},
+		{"EPUB cover-image", "pg11-images-3.epub", "cover.jpeg", 800, 1104},
+		{"EPUB as PNG", "pg76832-images.epub", "cover.png", 1600, 2133},
+		{"PDF first page", "testfile.pdf", "cover.jpg", 1241, 1754},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputPath := filepath.Join("..", "..", "fixtures", tt.filename)
+			outputPath := filepath.Join(t.TempDir(), "covers", tt.output)
+
+			cover, err := ExtractCover(inputPath, outputPath)
+			require.NoError(t, err, "should extract cover from %s", tt.filename)
+
+			assert.Equal(t, outputPath, cover.Path)
+			assert.Equal(t, tt.wantWidth, cover.Width, "cover width")
+			assert.Equal(t, tt.wantHeight, cover.Height, "cover height")
+
+			// Verify the written file is in the requested format
+			file, err := os.Open(outputPath)
+			require.NoError(t, err, "cover file should exist")
+			defer file.Close()
+			config, format, err := image.DecodeConfig(file)
+			require.NoError(t, err, "cover should be a valid image")
+			expectedFormat, _ := coverFormat(tt.output)
+			assert.Equal(t, expectedFormat, format, "cover should be encoded as %s", expectedFormat)
+			assert.Equal(t, tt.wantWidth, config.Width)
+		})
+	}
+}
+
+func TestExtractCoverKeepsOriginalData(t *testing.T) {
+	// The pages of the dummy book are PNG, extracting as PNG should not re-encode them
+	inputPath := filepath.Join("..", "..", "fixtures", "dummy_book.cbz")
+	outputPath := filepath.Join(t.TempDir(), "cover.png")
+
+	_, err := ExtractCover(inputPath, outputPath)
+	require.NoError(t, err)
+
+	info, err := os.Stat(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(739881), info.Size(), "cover should be copied as-is")
+}
+
+func TestExtractCoverComicInfoFrontCover(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "book.cbz")
+	createTestCBZ(t, inputPath, []zipEntry{
+		{"ComicInfo.xml", []byte(`<ComicInfo><Pages>
+  <Page Image="0" Type="InnerCover" />
+  <Page Image="1" Type="FrontCover" />
+</Pages></ComicInfo>`)},
+		{"page10.png", testPNG(t, 30, 40)},
+		{"page2.png", testPNG(t, 20, 30)},
+		{"page1.png", testPNG(t, 10, 20)},
+	})
+
+	// Natural order is page1, page2, page10: the FrontCover is page2
+	cover, err := ExtractCover(inputPath, filepath.Join(dir, "cover.png"))
+	require.NoError(t, err)
+	assert.Equal(t, 20, cover.Width)
+	assert.Equal(t, 30, cover.Height)
+}
+
+func TestExtractCoverFirstPage(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "book.cbz")
+	createTestCBZ(t, inputPath, []zipEntry{
+		{"page10.png", testPNG(t, 30, 40)},
+		{"page2.png", testPNG(t, 20, 30)},
+		{"page1.png", testPNG(t, 10, 20)},
+	})
+
+	cover, err := ExtractCover(inputPath, filepath.Join(dir, "cover.png"))
+	require.NoError(t, err)
+	assert.Equal(t, 10, cover.Width, "should use the first page in natural order")
+}
+
+func TestExtractCoverErrorCases(t *testing.T) {
+	dir := t.TempDir()
+	emptyCBZ := filepath.Join(dir, "empty.cbz")
+	createTestCBZ(t, emptyCBZ, []zipEntry{{"readme.txt", []byte("no pages")}})
+
+	tests := []struct {
+		name       string
+		inputPath  string
+		outputPath string
+	}{
+		{"unsupported output format", filepath.Join("..", "..", "fixtures", "dummy_book.cbz"), filepath.Join(dir, "cover.gif")},
+		{"unsupported book format", "book.txt", filepath.Join(dir, "cover.jpg")},
+		{"nonexistent file", "nonexistent.cbz", filepath.Join(dir, "cover.jpg")},
+		{"archive without images", emptyCBZ, filepath.Join(dir, "cover.jpg")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ExtractCover(tt.inputPath, tt.outputPath)
+			assert.Error(t, err, "should return error for %s", tt.name)
+			assert.NoFileExists(t, tt.outputPath, "should not leave a file behind")
+		})
+	}
+}
+
+func TestGetCover(t *testing.T) {
+	img, err := GetCover(filepath.Join("..", "..", "fixtures", "pg11-images-3.epub"))
+	require.NoError(t, err)
+	assert.Equal(t, 800, img.Bounds().Dx())
+}
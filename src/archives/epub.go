@@ -1,8 +1,11 @@
 package archives
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/pirmd/epub"
@@ -46,11 +49,19 @@ func getBookInfoEPUB(path string) (BookInfo, error) {
 		}
 	}
 
-	// Extract authors
+	// Extract authors, with the role of the creators and contributors from
+	// their opf:role attribute or their refining role meta
 	var authors []string
+	var contributors []Contributor
 	for _, creator := range info.Creator {
 		if creator.FullName != "" {
 			authors = append(authors, creator.FullName)
+			contributors = append(contributors, contributorEPUB(creator, RoleWriter))
+		}
+	}
+	for _, contributor := range info.Contributor {
+		if contributor.FullName != "" {
+			contributors = append(contributors, contributorEPUB(contributor, RoleContributor))
 		}
 	}
 
@@ -98,8 +109,113 @@ func getBookInfoEPUB(path string) (BookInfo, error) {
 		SeriesIndex:   seriesIndex,
 		Pages:         pages,
 		Authors:       authors,
+		Contributors:  contributors,
 		Publisher:     publisher,
 		PublishedDate: publishedDate,
 		Keywords:      keywords,
 	}, nil
 }
+
+// contributorEPUB reads a creator or a contributor, role is used when it has none
+func contributorEPUB(author epub.Author, role string) Contributor {
+	if strings.TrimSpace(author.Role) == "" {
+		return newContributor(author.FullName, role)
+	}
+	return parseContributorRole(author.FullName, author.Role)
+}
+
+// getCoverEPUB returns the cover image declared in the EPUB package document
+func getCoverEPUB(path string) ([]byte, error) {
+	book, err := epub.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB file: %w", err)
+	}
+	defer book.Close()
+
+	pkg, err := book.Package()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EPUB package: %w", err)
+	}
+
+	item := findCoverItemEPUB(pkg)
+	if item == nil {
+		return nil, fmt.Errorf("no cover found in EPUB '%s'", path)
+	}
+
+	r, err := book.OpenItem(item.Href)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cover '%s': %w", item.Href, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cover '%s': %w", item.Href, err)
+	}
+	return data, nil
+}
+
+// findCoverItemEPUB looks for the cover image in the manifest, using in order
+// the EPUB3 cover-image property, the EPUB2 <meta name="cover"> and finally
+// an image item named like a cover
+func findCoverItemEPUB(pkg *epub.PackageDocument) *epub.Item {
+	if pkg.Manifest == nil {
+		return nil
+	}
+	items := pkg.Manifest.Items
+
+	for i, item := range items {
+		if slices.Contains(strings.Fields(item.Properties), "cover-image") {
+			return &items[i]
+		}
+	}
+
+	if pkg.Metadata != nil {
+		for _, meta := range pkg.Metadata.Meta {
+			if meta.Name != "cover" || meta.Content == "" {
+				continue
+			}
+			// The content should be an item ID, but some tools put the href instead
+			for i, item := range items {
+				if (item.ID == meta.Content || item.Href == meta.Content) && isImageMediaType(item.MediaType) {
+					return &items[i]
+				}
+			}
+		}
+	}
+
+	for i, item := range items {
+		if isImageMediaType(item.MediaType) && strings.Contains(strings.ToLower(item.ID+" "+item.Href), "cover") {
+			return &items[i]
+		}
+	}
+
+	return nil
+}
+
+// isImageMediaType checks if a manifest media type is an image
+func isImageMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "image/")
+}
+
+// epubHandler reads EPUB files
+type epubHandler struct{}
+
+func (epubHandler) Name() string { return "epub" }
+
+func (epubHandler) Extensions() []string { return []string{"epub"} }
+
+func (epubHandler) Sniff(header []byte) bool { return isEPUB(header) }
+
+func (epubHandler) GetBookInfo(_ context.Context, path string) (BookInfo, error) {
+	return getBookInfoEPUB(path)
+}
+
+func (epubHandler) Extract(ctx context.Context, path, outputFolder string, opts ExtractOptions) ([]Page, error) {
+	return extractEPUB(ctx, path, outputFolder, opts)
+}
+
+func (epubHandler) GetCover(_ context.Context, path string) (CoverImage, error) {
+	data, err := getCoverEPUB(path)
+	return CoverImage{Data: data}, err
+}
@@ -0,0 +1,559 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pirmd/epub"
+	"golang.org/x/net/html"
+)
+
+// EPUB books are extracted as chapters: every item of the spine is written at
+// the root of the output folder, prefixed by its position in the reading
+// order. The other resources of the manifest (images, stylesheets, fonts) are
+// written below "assets" with their original layout, so that stylesheets still
+// find their fonts and images. The links of the XHTML documents are rewritten
+// to the new locations.
+//
+// Fixed-layout books (comics and manga, "rendition:layout" set to
+// "pre-paginated") are extracted like a comic book archive instead: the image
+// of each page of the spine is written as-is, in reading order.
+//
+// A page range selects the chapters, or the pages of fixed-layout books. The
+// resources are written whatever the range, the links to the chapters left
+// out are broken.
+//
+// Like the entries of comic book archives, the files are checked against the
+// ExtractLimits and written below the output folder only.
+
+// epubAssetsFolder holds the resources of the manifest that are not chapters
+const epubAssetsFolder = "assets"
+
+var (
+	// epubLinkAttr matches the attributes of a tag holding a link
+	epubLinkAttr = regexp.MustCompile(`(?i)(\s(?:href|src|xlink:href|poster)\s*=\s*)("[^"]*"|'[^']*')`)
+	// epubCSSURL matches the url() of a stylesheet
+	epubCSSURL = regexp.MustCompile(`(url\(\s*['"]?)([^'")]+)(['"]?\s*\))`)
+)
+
+// epubResource is an item of the manifest
+type epubResource struct {
+	item epub.Item
+	// path is unescaped, slash separated and relative to the package document
+	path string
+	// output is slash separated and relative to the output folder
+	output  string
+	chapter bool
+	// excluded chapters are outside the page range
+	excluded bool
+}
+
+// extractEPUB writes the chapters and their resources to the output folder
+// and returns the chapters in reading order
+func extractEPUB(ctx context.Context, inputFile, outputFolder string, opts ExtractOptions) ([]Page, error) {
+	guard, err := newExtractGuard(inputFile, opts.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	book, err := epub.Open(inputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB file: %w", err)
+	}
+	defer book.Close()
+
+	if err := os.MkdirAll(outputFolder, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output folder: %w", err)
+	}
+	root, err := os.OpenRoot(outputFolder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output folder: %w", err)
+	}
+	defer root.Close()
+
+	pkg, err := book.Package()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EPUB package: %w", err)
+	}
+	if pkg.Manifest == nil || pkg.Spine == nil {
+		return nil, errors.New("EPUB package has no manifest or spine")
+	}
+
+	var resources []*epubResource
+	byPath := map[string]*epubResource{}
+	byID := map[string]*epubResource{}
+	for _, item := range pkg.Manifest.Items {
+		p, ok := epubItemPath(item.Href)
+		if !ok {
+			// Remote resources are not part of the book
+			continue
+		}
+		r := &epubResource{item: item, path: p, output: path.Join(epubAssetsFolder, p)}
+		resources = append(resources, r)
+		byPath[p] = r
+		byID[item.ID] = r
+	}
+
+	var chapters []*epubResource
+	for _, ref := range pkg.Spine.Itemrefs {
+		r, ok := byID[ref.IDref]
+		if !ok {
+			return nil, fmt.Errorf("spine item '%s' is not in the manifest", ref.IDref)
+		}
+		if !r.chapter {
+			r.chapter = true
+			chapters = append(chapters, r)
+		}
+	}
+	if isFixedLayoutEPUB(pkg) {
+		pages, err := extractFixedLayoutEPUB(ctx, book, chapters, byPath, root, guard, opts)
+		if err != nil || len(pages) > 0 {
+			return pages, err
+		}
+		// Without any image, the pages are extracted as chapters
+	}
+
+	first, last, err := opts.pageRange(len(chapters))
+	if err != nil {
+		return nil, err
+	}
+	digits := max(len(strconv.Itoa(len(chapters))), 2)
+	for i, r := range chapters {
+		r.output = fmt.Sprintf("%0*d-%s", digits, i+1, path.Base(r.path))
+		r.excluded = i < first || i >= last
+	}
+
+	for _, r := range resources {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if r.excluded {
+			continue
+		}
+		err := writeEPUBResource(book, r, byPath, root, guard)
+		if errors.Is(err, fs.ErrNotExist) && !r.chapter {
+			// Manifests often list resources missing from the archive
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The table of contents is optional, chapters are listed without title when it cannot be read
+	titles := readEPUBTitles(book, pkg, resources, byID)
+
+	pages := make([]Page, 0, last-first)
+	for _, r := range chapters[first:last] {
+		pages = append(pages, Page{
+			Path:      filepath.FromSlash(r.output),
+			ID:        r.item.ID,
+			Title:     titles[r.path],
+			MediaType: r.item.MediaType,
+		})
+	}
+	return pages, nil
+}
+
+// isFixedLayoutEPUB checks if the pages of the book are pre-paginated, either
+// for the whole book or for every item of the spine
+func isFixedLayoutEPUB(pkg *epub.PackageDocument) bool {
+	if pkg.Metadata != nil {
+		for _, meta := range pkg.Metadata.Meta {
+			if meta.Meta != nil && meta.Property == "rendition:layout" && strings.TrimSpace(meta.Value) == "pre-paginated" {
+				return true
+			}
+			// Kindle comics declare it with an OPF 2 meta
+			if meta.Name == "fixed-layout" && meta.Content == "true" {
+				return true
+			}
+		}
+	}
+
+	for _, ref := range pkg.Spine.Itemrefs {
+		if !slices.Contains(strings.Fields(ref.Properties), "rendition:layout-pre-paginated") {
+			return false
+		}
+	}
+	return len(pkg.Spine.Itemrefs) > 0
+}
+
+// extractFixedLayoutEPUB writes the image of the selected pages of the spine,
+// named after their position in the reading order. Pages without image, such
+// as a text-only copyright page, are skipped.
+func extractFixedLayoutEPUB(ctx context.Context, book *epub.Epub, chapters []*epubResource, resources map[string]*epubResource, root *os.Root, guard *extractGuard, opts ExtractOptions) ([]Page, error) {
+	var images []string
+	for _, r := range chapters {
+		if p, ok := epubPageImage(book, r); ok {
+			images = append(images, p)
+		}
+	}
+	if len(images) == 0 {
+		return nil, nil
+	}
+	first, last, err := opts.pageRange(len(images))
+	if err != nil {
+		return nil, err
+	}
+
+	digits := max(len(strconv.Itoa(len(images))), 2)
+	var pages []Page
+	for _, p := range images[first:last] {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		f, size, err := openEPUBFile(book, p, resources, guard)
+		if err != nil {
+			return nil, err
+		}
+		var head bytes.Buffer
+		config, _, err := image.DecodeConfig(io.TeeReader(f, &head))
+		if err != nil {
+			// Like in comic book archives, images that cannot be decoded are skipped
+			f.Close()
+			continue
+		}
+
+		filename := fmt.Sprintf("page_%0*d%s", digits, first+len(pages)+1, strings.ToLower(path.Ext(p)))
+		err = guard.writeEntry(root, filename, size, io.MultiReader(&head, f))
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, Page{
+			Path:   filename,
+			Width:  config.Width,
+			Height: config.Height,
+		})
+	}
+	return pages, nil
+}
+
+// epubPageImage returns the path of the image of a fixed-layout page: the
+// item itself for an image, or the first <img> or SVG <image> of a document
+func epubPageImage(book *epub.Epub, r *epubResource) (string, bool) {
+	if strings.HasPrefix(r.item.MediaType, "image/") {
+		return r.path, true
+	}
+	if !isXHTML(r.item.MediaType) && r.item.MediaType != "image/svg+xml" {
+		return "", false
+	}
+
+	f, err := book.OpenItem(r.item.Href)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	doc, err := html.Parse(f)
+	if err != nil {
+		return "", false
+	}
+	for n := range doc.Descendants() {
+		var link string
+		switch {
+		case n.Type != html.ElementNode:
+			continue
+		case n.Data == "img":
+			link = htmlAttr(n, "src")
+		case n.Data == "image":
+			// Both href and xlink:href, the namespace is not part of the key
+			link = htmlAttr(n, "href")
+		}
+		u, err := url.Parse(link)
+		if link == "" || err != nil || u.Scheme != "" || u.Path == "" {
+			continue
+		}
+		return path.Join(path.Dir(r.path), u.Path), true
+	}
+	return "", false
+}
+
+// openEPUBFile opens a file of the package folder, listed in the manifest or
+// not, once checked by guard from its stored size. Links are rejected.
+func openEPUBFile(book *epub.Epub, p string, resources map[string]*epubResource, guard *extractGuard) (fs.File, int64, error) {
+	href := (&url.URL{Path: p}).EscapedPath()
+	if r, ok := resources[p]; ok {
+		href = r.item.Href
+	}
+
+	f, err := book.OpenItem(href)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open '%s': %w", p, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("failed to stat '%s': %w", p, err)
+	}
+	if stat.Mode()&fs.ModeSymlink != 0 {
+		f.Close()
+		return nil, 0, UnsafeEntryError{Name: p, Reason: "link"}
+	}
+	if err := guard.checkEntry(p, p, stat.Size()); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, stat.Size(), nil
+}
+
+// epubItemPath returns the path of a manifest item relative to the package
+// document, items outside of the package folder are rejected
+func epubItemPath(href string) (string, bool) {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	p := path.Clean(u.Path)
+	return p, filepath.IsLocal(filepath.FromSlash(p))
+}
+
+// isXHTML checks if a resource is a content document whose links are rewritten
+func isXHTML(mediaType string) bool {
+	return mediaType == "application/xhtml+xml" || mediaType == "text/html"
+}
+
+// writeEPUBResource copies a resource to its output location below root,
+// rewriting the links of content documents
+func writeEPUBResource(book *epub.Epub, r *epubResource, resources map[string]*epubResource, root *os.Root, guard *extractGuard) error {
+	f, size, err := openEPUBFile(book, r.path, resources, guard)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var data io.Reader = f
+	if isXHTML(r.item.MediaType) {
+		content, err := io.ReadAll(f)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %w", r.path, err)
+		}
+		data = bytes.NewReader(rewriteEPUBLinks(content, r, resources))
+	}
+	return guard.writeEntry(root, r.output, size, data)
+}
+
+// rewriteEPUBLinks points the links of a content document to the extracted
+// resources. Only the attributes of the tags and the stylesheets are
+// modified, the rest of the document is copied as-is.
+func rewriteEPUBLinks(data []byte, from *epubResource, resources map[string]*epubResource) []byte {
+	var out bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(data))
+	inStyle := false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// Keep whatever could not be tokenized
+			out.Write(z.Raw())
+			return out.Bytes()
+		}
+		// TagName lower-cases the raw buffer, copy it first
+		raw := bytes.Clone(z.Raw())
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			inStyle = tt == html.StartTagToken && string(name) == "style"
+			raw = epubLinkAttr.ReplaceAllFunc(raw, func(attr []byte) []byte {
+				m := epubLinkAttr.FindSubmatch(attr)
+				value := m[2]
+				link := html.UnescapeString(string(value[1 : len(value)-1]))
+				rewritten, ok := rewriteEPUBLink(link, from, resources)
+				if !ok {
+					return attr
+				}
+				return fmt.Appendf(nil, `%s"%s"`, m[1], html.EscapeString(rewritten))
+			})
+		case html.TextToken:
+			if inStyle {
+				raw = rewriteCSSLinks(raw, from, resources)
+			}
+		case html.EndTagToken:
+			inStyle = false
+		}
+		out.Write(raw)
+	}
+}
+
+// rewriteCSSLinks rewrites the url() of a stylesheet embedded in a document
+func rewriteCSSLinks(css []byte, from *epubResource, resources map[string]*epubResource) []byte {
+	return epubCSSURL.ReplaceAllFunc(css, func(match []byte) []byte {
+		m := epubCSSURL.FindSubmatch(match)
+		rewritten, ok := rewriteEPUBLink(string(m[2]), from, resources)
+		if !ok {
+			return match
+		}
+		return bytes.Join([][]byte{m[1], []byte(rewritten), m[3]}, nil)
+	})
+}
+
+// rewriteEPUBLink returns the link to a resource relative to the output of
+// the document, external links and unknown resources are left unchanged
+func rewriteEPUBLink(link string, from *epubResource, resources map[string]*epubResource) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+	target, ok := resources[path.Join(path.Dir(from.path), u.Path)]
+	if !ok {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(from.output)), filepath.FromSlash(target.output))
+	if err != nil {
+		return "", false
+	}
+	rewritten := (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath()
+	if u.RawQuery != "" {
+		rewritten += "?" + u.RawQuery
+	}
+	if u.Fragment != "" {
+		rewritten += "#" + u.EscapedFragment()
+	}
+	return rewritten, rewritten != link
+}
+
+// readEPUBTitles returns the titles of the table of contents by resource
+// path, from the navigation document (EPUB 3) or the NCX (EPUB 2)
+func readEPUBTitles(book *epub.Epub, pkg *epub.PackageDocument, resources []*epubResource, byID map[string]*epubResource) map[string]string {
+	titles := map[string]string{}
+	add := func(from *epubResource, href, title string) {
+		u, err := url.Parse(href)
+		title = strings.Join(strings.Fields(title), " ")
+		if err != nil || u.Path == "" || title == "" {
+			return
+		}
+		p := path.Join(path.Dir(from.path), u.Path)
+		if _, ok := titles[p]; !ok {
+			titles[p] = title
+		}
+	}
+
+	for _, r := range resources {
+		if slices.Contains(strings.Fields(r.item.Properties), "nav") {
+			readEPUBNav(book, r, add)
+		}
+	}
+	if len(titles) > 0 {
+		return titles
+	}
+
+	ncx := byID[pkg.Spine.Toc]
+	for _, r := range resources {
+		if ncx == nil && r.item.MediaType == "application/x-dtbncx+xml" {
+			ncx = r
+		}
+	}
+	if ncx != nil {
+		readEPUBNCX(book, ncx, add)
+	}
+	return titles
+}
+
+// readEPUBNav reads the links of the toc <nav> of an EPUB 3 navigation
+// document, or of its first <nav>
+func readEPUBNav(book *epub.Epub, r *epubResource, add func(from *epubResource, href, title string)) {
+	f, err := book.OpenItem(r.item.Href)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	doc, err := html.Parse(f)
+	if err != nil {
+		return
+	}
+
+	var navs []*html.Node
+	for n := range doc.Descendants() {
+		if n.Type == html.ElementNode && n.Data == "nav" {
+			navs = append(navs, n)
+		}
+	}
+	if len(navs) == 0 {
+		return
+	}
+	toc := navs[0]
+	for _, nav := range navs {
+		if slices.Contains(strings.Fields(htmlAttr(nav, "epub:type")), "toc") {
+			toc = nav
+			break
+		}
+	}
+
+	for n := range toc.Descendants() {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			add(r, htmlAttr(n, "href"), htmlText(n))
+		}
+	}
+}
+
+// htmlAttr returns the value of an attribute of a node
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// htmlText returns the text content of a node
+func htmlText(n *html.Node) string {
+	var text strings.Builder
+	for c := range n.Descendants() {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+		}
+	}
+	return text.String()
+}
+
+// ncxNavPoint is an entry of the NCX navigation map, with its children
+type ncxNavPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Children []ncxNavPoint `xml:"navPoint"`
+}
+
+// readEPUBNCX reads the navigation map of an EPUB 2 NCX document
+func readEPUBNCX(book *epub.Epub, r *epubResource, add func(from *epubResource, href, title string)) {
+	f, err := book.OpenItem(r.item.Href)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var ncx struct {
+		Points []ncxNavPoint `xml:"navMap>navPoint"`
+	}
+	if err := xml.NewDecoder(f).Decode(&ncx); err != nil {
+		return
+	}
+
+	var walk func(points []ncxNavPoint)
+	walk = func(points []ncxNavPoint) {
+		for _, p := range points {
+			add(r, p.Content.Src, p.Label)
+			walk(p.Children)
+		}
+	}
+	walk(ncx.Points)
+}
@@ -0,0 +1,351 @@
+package archives
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testEPUBContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+// testEPUBPackage returns a package document listing two chapters, with
+// the EPUB 3 navigation document when nav is set
+func testEPUBPackage(nav bool) string {
+	navItem := ""
+	if nav {
+		navItem = `<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`
+	}
+	return `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Test</dc:title></metadata>
+  <manifest>
+    ` + navItem + `
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="intro" href="Text/intro.xhtml" media-type="application/xhtml+xml"/>
+    <item id="chapter" href="Text/chapter%201.xhtml" media-type="application/xhtml+xml"/>
+    <item id="css" href="Styles/style.css" media-type="text/css"/>
+    <item id="image" href="Images/figure.png" media-type="image/png"/>
+    <item id="missing" href="Images/missing.png" media-type="image/png"/>
+    <item id="remote" href="https://example.com/font.woff" media-type="font/woff"/>
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="intro"/>
+    <itemref idref="chapter"/>
+  </spine>
+</package>`
+}
+
+const testEPUBNav = `<?xml version="1.0"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+  <nav epub:type="landmarks"><ol><li><a href="Text/chapter%201.xhtml">Start</a></li></ol></nav>
+  <nav epub:type="toc"><ol>
+    <li><a href="Text/intro.xhtml">Introduction</a></li>
+    <li><a href="Text/chapter%201.xhtml#start">Chapter
+      <em>One</em></a></li>
+  </ol></nav>
+</body></html>`
+
+const testEPUBNCX = `<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><navMap>
+  <navPoint id="p1"><navLabel><text>Preface</text></navLabel><content src="Text/intro.xhtml"/>
+    <navPoint id="p2"><navLabel><text>First chapter</text></navLabel><content src="Text/chapter%201.xhtml"/></navPoint>
+  </navPoint>
+</navMap></ncx>`
+
+const testEPUBIntro = `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Intro</title><link rel="stylesheet" type="text/css" href="../Styles/style.css"/>
+<style>body { background: url('../Images/figure.png'); }</style></head>
+<body><p>See <a href="chapter%201.xhtml#start">the first chapter</a>, <a href="#top">the top</a> or
+<a href="https://example.com/?a=1&amp;b=2">the site</a>.</p>
+<img src="../Images/figure.png" alt="figure"/><svg><image xlink:href="../Images/figure.png"/></svg></body>
+</html>`
+
+// createTestEPUB writes an EPUB with two chapters and their resources
+func createTestEPUB(t *testing.T, path string, nav bool) {
+	t.Helper()
+
+	entries := []zipEntry{
+		{"mimetype", []byte("application/epub+zip")},
+		{"META-INF/container.xml", []byte(testEPUBContainer)},
+		{"OEBPS/content.opf", []byte(testEPUBPackage(nav))},
+		{"OEBPS/toc.ncx", []byte(testEPUBNCX)},
+		{"OEBPS/Text/intro.xhtml", []byte(testEPUBIntro)},
+		{"OEBPS/Text/chapter 1.xhtml", []byte(`<html><body><h1 id="start">One</h1><a href="intro.xhtml">back</a></body></html>`)},
+		{"OEBPS/Styles/style.css", []byte(`p { background: url(../Images/figure.png) }`)},
+		{"OEBPS/Images/figure.png", testPNG(t, 2, 3)},
+	}
+	if nav {
+		entries = append(entries, zipEntry{"OEBPS/nav.xhtml", []byte(testEPUBNav)})
+	}
+	require.NoError(t, os.WriteFile(path, testZIP(t, entries...), 0644))
+}
+
+func TestExtractEPUB(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.epub")
+	createTestEPUB(t, path, true)
+
+	output := filepath.Join(dir, "out")
+	pages, err := Extract(path, output)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Page{
+		{Path: "01-intro.xhtml", ID: "intro", Title: "Introduction", MediaType: "application/xhtml+xml"},
+		{Path: "02-chapter 1.xhtml", ID: "chapter", Title: "Chapter One", MediaType: "application/xhtml+xml"},
+	}, pages, "should list the chapters in reading order with their titles from the nav")
+
+	intro, err := os.ReadFile(filepath.Join(output, "01-intro.xhtml"))
+	require.NoError(t, err)
+	expected := strings.NewReplacer(
+		`href="../Styles/style.css"`, `href="assets/Styles/style.css"`,
+		`url('../Images/figure.png')`, `url('assets/Images/figure.png')`,
+		`href="chapter%201.xhtml#start"`, `href="02-chapter%201.xhtml#start"`,
+		`src="../Images/figure.png"`, `src="assets/Images/figure.png"`,
+		`xlink:href="../Images/figure.png"`, `xlink:href="assets/Images/figure.png"`,
+	).Replace(testEPUBIntro)
+	assert.Equal(t, expected, string(intro), "should only rewrite the links to the resources")
+
+	chapter, err := os.ReadFile(filepath.Join(output, "02-chapter 1.xhtml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(chapter), `href="01-intro.xhtml"`)
+
+	css, err := os.ReadFile(filepath.Join(output, "assets", "Styles", "style.css"))
+	require.NoError(t, err)
+	assert.Equal(t, `p { background: url(../Images/figure.png) }`, string(css), "assets keep their layout")
+	assert.FileExists(t, filepath.Join(output, "assets", "Images", "figure.png"))
+	assert.FileExists(t, filepath.Join(output, "assets", "nav.xhtml"))
+}
+
+func TestExtractEPUBNCX(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.epub")
+	createTestEPUB(t, path, false)
+
+	pages, err := Extract(path, filepath.Join(dir, "out"))
+	require.NoError(t, err)
+	require.Len(t, pages, 2)
+	assert.Equal(t, "Preface", pages[0].Title, "should read the titles from the NCX without nav")
+	assert.Equal(t, "First chapter", pages[1].Title, "should read the nested navigation points")
+}
+
+func TestExtractEPUBFixture(t *testing.T) {
+	path := filepath.Join("..", "..", "fixtures", "pg11-images-3.epub")
+	info, err := GetBookInfo(path)
+	require.NoError(t, err)
+
+	output := t.TempDir()
+	pages, err := Extract(path, output)
+	require.NoError(t, err)
+	assert.Len(t, pages, info.Pages, "should extract every item of the spine")
+	assert.Equal(t, "CHAPTER I. Down the Rabbit-Hole", pages[2].Title)
+	for _, page := range pages {
+		assert.FileExists(t, filepath.Join(output, page.Path))
+	}
+}
+
+func TestExtractEPUBPageRange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.epub")
+	createTestEPUB(t, path, true)
+
+	output := filepath.Join(dir, "out")
+	pages, err := ExtractWithOptions(path, output, ExtractOptions{FirstPage: 2})
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+	assert.Equal(t, "02-chapter 1.xhtml", pages[0].Path, "chapters should keep their position")
+	assert.NoFileExists(t, filepath.Join(output, "01-intro.xhtml"))
+	assert.FileExists(t, filepath.Join(output, "assets", "Styles", "style.css"), "resources should be extracted")
+
+	path = filepath.Join(dir, "manga.epub")
+	createTestFixedLayoutEPUB(t, path, `<meta property="rendition:layout">pre-paginated</meta>`, "")
+	pages, err = ExtractWithOptions(path, filepath.Join(dir, "manga"), ExtractOptions{FirstPage: 2, LastPage: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []Page{{Path: "page_02.png", Width: 20, Height: 10}}, pages)
+}
+
+func TestRewriteEPUBLink(t *testing.T) {
+	from := &epubResource{path: "Text/a.xhtml", output: "01-a.xhtml"}
+	resources := map[string]*epubResource{
+		"Text/b.xhtml":    {path: "Text/b.xhtml", output: "02-b.xhtml"},
+		"Images/c d.png":  {path: "Images/c d.png", output: "assets/Images/c d.png"},
+		"Text/a.xhtml":    from,
+		"Styles/main.css": {path: "Styles/main.css", output: "assets/Styles/main.css"},
+	}
+
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"b.xhtml", "02-b.xhtml"},
+		{"b.xhtml#note-1", "02-b.xhtml#note-1"},
+		{"./../Text/b.xhtml", "02-b.xhtml"},
+		{"../Images/c%20d.png", "assets/Images/c%20d.png"},
+		{"../Styles/main.css?v=2", "assets/Styles/main.css?v=2"},
+		{"a.xhtml#top", "01-a.xhtml#top"},
+		{"#top", ""},
+		{"missing.xhtml", ""},
+		{"https://example.com/b.xhtml", ""},
+		{"mailto:someone@example.com", ""},
+		{"/Text/b.xhtml", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			got, ok := rewriteEPUBLink(tt.link, from, resources)
+			assert.Equal(t, tt.want != "", ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// createTestFixedLayoutEPUB writes a pre-paginated EPUB whose pages hold an
+// <img>, an SVG <image>, an image item and some text
+func createTestFixedLayoutEPUB(t *testing.T, path, metadata, properties string) {
+	t.Helper()
+
+	opf := `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Manga</dc:title>` + metadata + `</metadata>
+  <manifest>
+    <item id="p1" href="xhtml/p1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="p2" href="xhtml/p2.xhtml" media-type="application/xhtml+xml" properties="svg"/>
+    <item id="p3" href="image/p3.jpg" media-type="image/jpeg"/>
+    <item id="p4" href="xhtml/p4.xhtml" media-type="application/xhtml+xml"/>
+    <item id="i1" href="image/p1.png" media-type="image/png"/>
+    <item id="i2" href="image/p2.png" media-type="image/png"/>
+  </manifest>
+  <spine page-progression-direction="rtl">
+    <itemref idref="p1" properties="` + properties + `"/>
+    <itemref idref="p2" properties="` + properties + `"/>
+    <itemref idref="p3" properties="` + properties + `"/>
+    <itemref idref="p4" properties="` + properties + `"/>
+  </spine>
+</package>`
+
+	var jpg bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 30, 40)), nil))
+
+	require.NoError(t, os.WriteFile(path, testZIP(t,
+		zipEntry{"mimetype", []byte("application/epub+zip")},
+		zipEntry{"META-INF/container.xml", []byte(testEPUBContainer)},
+		zipEntry{"OEBPS/content.opf", []byte(opf)},
+		zipEntry{"OEBPS/xhtml/p1.xhtml", []byte(`<html><body><div><img src="../image/p1.png" alt=""/></div></body></html>`)},
+		zipEntry{"OEBPS/xhtml/p2.xhtml", []byte(`<html xmlns:xlink="http://www.w3.org/1999/xlink"><body>
+<svg viewBox="0 0 20 10"><image width="20" height="10" xlink:href="../image/p2.png"/></svg></body></html>`)},
+		zipEntry{"OEBPS/xhtml/p4.xhtml", []byte(`<html><body><p>Copyright</p></body></html>`)},
+		zipEntry{"OEBPS/image/p1.png", testPNG(t, 10, 20)},
+		zipEntry{"OEBPS/image/p2.png", testPNG(t, 20, 10)},
+		zipEntry{"OEBPS/image/p3.jpg", jpg.Bytes()},
+	), 0644))
+}
+
+func TestExtractFixedLayoutEPUB(t *testing.T) {
+	tests := []struct {
+		name       string
+		metadata   string
+		properties string
+	}{
+		{"rendition metadata", `<meta property="rendition:layout">pre-paginated</meta>`, ""},
+		{"spine properties", "", "rendition:layout-pre-paginated page-spread-right"},
+		{"Kindle metadata", `<meta name="fixed-layout" content="true"/>`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "manga.epub")
+			createTestFixedLayoutEPUB(t, path, tt.metadata, tt.properties)
+
+			output := filepath.Join(dir, "out")
+			pages, err := Extract(path, output)
+			require.NoError(t, err)
+			assert.Equal(t, []Page{
+				{Path: "page_01.png", Width: 10, Height: 20},
+				{Path: "page_02.png", Width: 20, Height: 10},
+				{Path: "page_03.jpg", Width: 30, Height: 40},
+			}, pages, "should extract the image of every page in spine order")
+
+			data, err := os.ReadFile(filepath.Join(output, "page_01.png"))
+			require.NoError(t, err)
+			assert.Equal(t, testPNG(t, 10, 20), data, "images should be copied as-is")
+		})
+	}
+}
+
+func TestExtractReflowableEPUBWithImages(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.epub")
+	createTestFixedLayoutEPUB(t, path, `<meta property="rendition:layout">reflowable</meta>`, "")
+
+	pages, err := Extract(path, filepath.Join(dir, "out"))
+	require.NoError(t, err)
+	require.Len(t, pages, 4, "a reflowable book should be extracted as chapters")
+	assert.Equal(t, "01-p1.xhtml", pages[0].Path)
+}
+
+func TestExtractEPUBLimits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.epub")
+	createTestEPUB(t, path, true)
+
+	_, err := ExtractWithOptions(path, filepath.Join(dir, "out"), ExtractOptions{Limits: ExtractLimits{MaxEntries: 2}})
+	var limit LimitError
+	require.True(t, errors.As(err, &limit), "should return a LimitError, got %v", err)
+	assert.Equal(t, "entry count", limit.Limit)
+
+	// A folder of the output linking out of it is not followed
+	outside := t.TempDir()
+	output := filepath.Join(dir, "linked")
+	require.NoError(t, os.MkdirAll(output, 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(output, epubAssetsFolder)))
+	_, err = Extract(path, output)
+	assert.Error(t, err)
+	entries, err := os.ReadDir(outside)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "should not write outside of the output folder")
+}
+
+func TestExtractEPUBLink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	w := zip.NewWriter(file)
+	for _, entry := range []zipEntry{
+		{"mimetype", []byte("application/epub+zip")},
+		{"META-INF/container.xml", []byte(testEPUBContainer)},
+		{"OEBPS/content.opf", []byte(testEPUBPackage(false))},
+		{"OEBPS/Text/intro.xhtml", []byte(testEPUBIntro)},
+		{"OEBPS/Text/chapter 1.xhtml", []byte("/etc/passwd")},
+	} {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Store}
+		if strings.HasSuffix(entry.Name, "chapter 1.xhtml") {
+			header.SetMode(fs.ModeSymlink | 0777)
+		}
+		f, err := w.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = f.Write(entry.Data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, file.Close())
+
+	_, err = Extract(path, t.TempDir())
+	var unsafe UnsafeEntryError
+	require.True(t, errors.As(err, &unsafe), "should return an UnsafeEntryError, got %v", err)
+	assert.Equal(t, "link", unsafe.Reason)
+}
@@ -0,0 +1,376 @@
+package archives
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// FictionBook 2 is a single XML document: the metadata are in <description>,
+// followed by the <body> of the book and the images, base64 encoded in
+// <binary> elements. See http://www.fictionbook.org/index.php/Eng:XML_Schema_Fictionbook_2.1
+
+type fb2Description struct {
+	TitleInfo   fb2TitleInfo   `xml:"title-info"`
+	PublishInfo fb2PublishInfo `xml:"publish-info"`
+}
+
+type fb2TitleInfo struct {
+	Genres     []string      `xml:"genre"`
+	Authors    []fb2Author   `xml:"author"`
+	BookTitle  string        `xml:"book-title"`
+	Annotation fb2Text       `xml:"annotation"`
+	Keywords   string        `xml:"keywords"`
+	Date       fb2Date       `xml:"date"`
+	Coverpage  fb2Image      `xml:"coverpage>image"`
+	Lang       string        `xml:"lang"`
+	Sequences  []fb2Sequence `xml:"sequence"`
+}
+
+type fb2PublishInfo struct {
+	BookName  string        `xml:"book-name"`
+	Publisher string        `xml:"publisher"`
+	Year      string        `xml:"year"`
+	ISBN      string        `xml:"isbn"`
+	Sequences []fb2Sequence `xml:"sequence"`
+}
+
+type fb2Author struct {
+	FirstName  string `xml:"first-name"`
+	MiddleName string `xml:"middle-name"`
+	LastName   string `xml:"last-name"`
+	Nickname   string `xml:"nickname"`
+}
+
+// name returns the full name of the author, or its nickname
+func (a fb2Author) name() string {
+	name := strings.Join(strings.Fields(a.FirstName+" "+a.MiddleName+" "+a.LastName), " ")
+	if name == "" {
+		return strings.TrimSpace(a.Nickname)
+	}
+	return name
+}
+
+type fb2Date struct {
+	Value string `xml:"value,attr"`
+	Text  string `xml:",chardata"`
+}
+
+type fb2Image struct {
+	Href string `xml:"href,attr"`
+}
+
+type fb2Sequence struct {
+	Name   string `xml:"name,attr"`
+	Number string `xml:"number,attr"`
+}
+
+type fb2Binary struct {
+	ID   string `xml:"id,attr"`
+	Data string `xml:",chardata"`
+}
+
+// fb2Text is the text of a formatted element such as <annotation>, with one
+// line per paragraph
+type fb2Text string
+
+func (t *fb2Text) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var lines []string
+	var line strings.Builder
+	flush := func() {
+		if s := strings.Join(strings.Fields(line.String()), " "); s != "" {
+			lines = append(lines, s)
+		}
+		line.Reset()
+	}
+
+	for depth := 0; ; {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				flush()
+				*t = fb2Text(strings.Join(lines, "\n"))
+				return nil
+			}
+			depth--
+			if token.Name.Local == "p" || token.Name.Local == "subtitle" {
+				flush()
+			}
+		case xml.CharData:
+			line.Write(token)
+			line.WriteByte(' ')
+		}
+	}
+}
+
+// openFB2 opens the XML document of a FictionBook, either a plain .fb2 file
+// or the first .fb2 file of a ZIP archive (usually named .fb2.zip)
+func openFB2(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FB2 file: %w", err)
+	}
+
+	magic := make([]byte, len(zipMagic))
+	n, _ := io.ReadFull(file, magic)
+	if !isZIP(magic[:n]) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read FB2 file: %w", err)
+		}
+		return file, nil
+	}
+
+	r, err := openZippedFB2(file, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// openZippedFB2 opens the first .fb2 entry of the archive
+func openZippedFB2(file *os.File, path string) (io.ReadCloser, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FB2 archive: %w", err)
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FB2 archive: %w", err)
+	}
+	for _, f := range archive.File {
+		if strings.EqualFold(filepath.Ext(f.Name), ".fb2") {
+			r, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open '%s': %w", f.Name, err)
+			}
+			return &zipEntryReader{ReadCloser: r, file: file}, nil
+		}
+	}
+	return nil, fmt.Errorf("no FB2 file found in '%s'", path)
+}
+
+// zipEntryReader closes the archive file along with the entry
+type zipEntryReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r *zipEntryReader) Close() error {
+	r.ReadCloser.Close()
+	return r.file.Close()
+}
+
+// newFB2Decoder returns a lenient XML decoder, FictionBooks are often
+// encoded in windows-1251 or KOI8-R and use HTML entities
+func newFB2Decoder(r io.Reader) *xml.Decoder {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charset.NewReaderLabel
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	return d
+}
+
+// readFB2Description decodes the <description> element, leaving the decoder
+// right after it
+func readFB2Description(d *xml.Decoder) (fb2Description, error) {
+	var desc fb2Description
+	for {
+		token, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return desc, errors.New("no description found in FB2 file")
+		}
+		if err != nil {
+			return desc, fmt.Errorf("failed to parse FB2 file: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "description" {
+			if err := d.DecodeElement(&desc, &start); err != nil {
+				return desc, fmt.Errorf("failed to parse FB2 description: %w", err)
+			}
+			return desc, nil
+		}
+	}
+}
+
+// getBookInfoFB2 extracts metadata from FictionBook files
+func getBookInfoFB2(path string) (BookInfo, error) {
+	r, err := openFB2(path)
+	if err != nil {
+		return BookInfo{}, err
+	}
+	defer r.Close()
+
+	desc, err := readFB2Description(newFB2Decoder(r))
+	if err != nil {
+		return BookInfo{}, err
+	}
+	titleInfo := desc.TitleInfo
+	publishInfo := desc.PublishInfo
+
+	// Extract title - use filename as fallback
+	title := strings.TrimSuffix(filepath.Base(path), "."+getFileExtension(path))
+	if t := strings.TrimSpace(titleInfo.BookTitle); t != "" {
+		title = t
+	} else if t := strings.TrimSpace(publishInfo.BookName); t != "" {
+		title = t
+	}
+
+	var authors []string
+	for _, author := range titleInfo.Authors {
+		if name := author.name(); name != "" {
+			authors = append(authors, name)
+		}
+	}
+
+	var language []string
+	if lang := strings.TrimSpace(titleInfo.Lang); lang != "" {
+		language = []string{lang}
+	}
+
+	// The series of the book, or of the edition
+	var series, seriesIndex string
+	for _, sequence := range append(titleInfo.Sequences, publishInfo.Sequences...) {
+		if name := strings.TrimSpace(sequence.Name); name != "" {
+			series = name
+			seriesIndex = strings.TrimSpace(sequence.Number)
+			break
+		}
+	}
+
+	// The year of the edition, or the date the book was written
+	publishedDate := strings.TrimSpace(publishInfo.Year)
+	if publishedDate == "" {
+		publishedDate = strings.TrimSpace(titleInfo.Date.Value)
+	}
+	if publishedDate == "" {
+		publishedDate = strings.TrimSpace(titleInfo.Date.Text)
+	}
+
+	var keywords []string
+	for _, genre := range titleInfo.Genres {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			keywords = append(keywords, genre)
+		}
+	}
+	for _, keyword := range strings.Split(titleInfo.Keywords, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+
+	return BookInfo{
+		Title:         title,
+		Language:      language,
+		Description:   string(titleInfo.Annotation),
+		Series:        series,
+		SeriesIndex:   seriesIndex,
+		Authors:       authors,
+		Publisher:     strings.TrimSpace(publishInfo.Publisher),
+		PublishedDate: publishedDate,
+		ISBN:          strings.TrimSpace(publishInfo.ISBN),
+		Keywords:      keywords,
+	}, nil
+}
+
+// getCoverFB2 decodes the <binary> referenced by the <coverpage>, or the
+// first image named like a cover
+func getCoverFB2(path string) ([]byte, error) {
+	r, err := openFB2(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	d := newFB2Decoder(r)
+	desc, err := readFB2Description(d)
+	if err != nil {
+		return nil, err
+	}
+	coverID := strings.TrimPrefix(desc.TitleInfo.Coverpage.Href, "#")
+
+	for {
+		token, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no cover found in FB2 '%s'", path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse FB2 file: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "binary" {
+			continue
+		}
+
+		if !isFB2Cover(start, coverID) {
+			// Do not decode the images that are not the cover
+			if err := d.Skip(); err != nil {
+				return nil, fmt.Errorf("failed to parse FB2 file: %w", err)
+			}
+			continue
+		}
+
+		var binary fb2Binary
+		if err := d.DecodeElement(&binary, &start); err != nil {
+			return nil, fmt.Errorf("failed to parse FB2 binary: %w", err)
+		}
+
+		// Base64 data is usually wrapped on several lines
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(binary.Data), ""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode FB2 cover '%s': %w", binary.ID, err)
+		}
+		return data, nil
+	}
+}
+
+// isFB2Cover checks if a <binary> element holds the cover, when the book has
+// no <coverpage> the first image named like a cover is used
+func isFB2Cover(start xml.StartElement, coverID string) bool {
+	var id, contentType string
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "id":
+			id = attr.Value
+		case "content-type":
+			contentType = attr.Value
+		}
+	}
+	if coverID != "" {
+		return id == coverID
+	}
+	return isImageMediaType(contentType) && strings.Contains(strings.ToLower(id), "cover")
+}
+
+// fb2Handler reads FictionBook 2 files, plain or zipped
+type fb2Handler struct{}
+
+func (fb2Handler) Name() string { return "fb2" }
+
+func (fb2Handler) Extensions() []string { return []string{"fb2", "fb2.zip"} }
+
+func (fb2Handler) Sniff(header []byte) bool { return isFB2(header) || isZippedFB2(header) }
+
+func (fb2Handler) GetBookInfo(_ context.Context, path string) (BookInfo, error) {
+	return getBookInfoFB2(path)
+}
+
+func (fb2Handler) GetCover(_ context.Context, path string) (CoverImage, error) {
+	data, err := getCoverFB2(path)
+	return CoverImage{Data: data}, err
+}
@@ -0,0 +1,165 @@
+package archives
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// testFB2 returns a FictionBook with the given cover, encoded in base64 on several lines
+func testFB2(cover []byte) string {
+	data := base64.StdEncoding.EncodeToString(cover)
+	var wrapped strings.Builder
+	for len(data) > 76 {
+		wrapped.WriteString(data[:76] + "\n")
+		data = data[76:]
+	}
+	wrapped.WriteString(data)
+
+	return `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+  <description>
+    <title-info>
+      <genre>prose_classic</genre>
+      <genre>prose_rus_classic</genre>
+      <author>
+        <first-name>Фёдор</first-name>
+        <middle-name>Михайлович</middle-name>
+        <last-name>Достоевский</last-name>
+      </author>
+      <author><nickname>Anonymous</nickname></author>
+      <book-title>Игрок</book-title>
+      <annotation>
+        <p>Роман о   рулетке.</p>
+        <p>Written&nbsp;in 1866.</p>
+      </annotation>
+      <keywords>roulette, gambling</keywords>
+      <date value="1866-01-01">1866</date>
+      <coverpage><image l:href="#cover.png"/></coverpage>
+      <lang>ru</lang>
+      <sequence name="Собрание сочинений" number="5"/>
+    </title-info>
+    <publish-info>
+      <publisher>Наука</publisher>
+      <year>1973</year>
+      <isbn>978-5-00-000000-0</isbn>
+    </publish-info>
+  </description>
+  <body><section><p>Text</p></section></body>
+  <binary id="other.png" content-type="image/png">not base64</binary>
+  <binary id="cover.png" content-type="image/png">` + wrapped.String() + `</binary>
+</FictionBook>`
+}
+
+var expectedFB2 = BookInfo{
+	Title:         "Игрок",
+	Language:      []string{"ru"},
+	Description:   "Роман о рулетке.\nWritten in 1866.",
+	Series:        "Собрание сочинений",
+	SeriesIndex:   "5",
+	Authors:       []string{"Фёдор Михайлович Достоевский", "Anonymous"},
+	Publisher:     "Наука",
+	PublishedDate: "1973",
+	ISBN:          "978-5-00-000000-0",
+	Keywords:      []string{"prose_classic", "prose_rus_classic", "roulette", "gambling"},
+}
+
+func TestGetBookInfoFB2(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.fb2")
+	require.NoError(t, os.WriteFile(path, []byte(testFB2(nil)), 0644))
+
+	book, err := GetBookInfo(path)
+	require.NoError(t, err, "should successfully read FB2 file")
+	assert.Equal(t, expectedFB2, book)
+}
+
+func TestGetBookInfoFB2Windows1251(t *testing.T) {
+	content := strings.Replace(testFB2(nil), `encoding="utf-8"`, `encoding="windows-1251"`, 1)
+	encoded, err := charmap.Windows1251.NewEncoder().String(content)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "book.fb2")
+	require.NoError(t, os.WriteFile(path, []byte(encoded), 0644))
+
+	book, err := getBookInfoFB2(path)
+	require.NoError(t, err, "should decode windows-1251 books")
+	assert.Equal(t, expectedFB2, book)
+}
+
+func TestGetBookInfoFB2Zip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Book.FB2.zip")
+	createTestCBZ(t, path, []zipEntry{{Name: "book.fb2", Data: []byte(testFB2(nil))}})
+
+	assert.True(t, IsValidBookFile(path))
+	book, err := GetBookInfo(path)
+	require.NoError(t, err, "should successfully read zipped FB2 file")
+	assert.Equal(t, expectedFB2, book)
+}
+
+func TestGetBookInfoFB2Fallbacks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "My Book.fb2.zip")
+	createTestCBZ(t, path, []zipEntry{{Name: "book.fb2", Data: []byte(`<FictionBook><description>
+		<title-info><date>1900</date></title-info>
+		<publish-info><sequence name="Series" number="2"/></publish-info>
+	</description></FictionBook>`)}})
+
+	book, err := getBookInfoFB2(path)
+	require.NoError(t, err)
+	assert.Equal(t, BookInfo{
+		Title:         "My Book",
+		Series:        "Series",
+		SeriesIndex:   "2",
+		PublishedDate: "1900",
+	}, book)
+}
+
+func TestGetBookInfoFB2Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	noDescription := filepath.Join(dir, "empty.fb2")
+	require.NoError(t, os.WriteFile(noDescription, []byte(`<FictionBook><body/></FictionBook>`), 0644))
+	emptyZip := filepath.Join(dir, "empty.fb2.zip")
+	createTestCBZ(t, emptyZip, []zipEntry{{Name: "readme.txt", Data: []byte("text")}})
+
+	for _, path := range []string{filepath.Join(dir, "missing.fb2"), noDescription, emptyZip} {
+		_, err := getBookInfoFB2(path)
+		assert.Error(t, err, "should fail to read %s", filepath.Base(path))
+	}
+}
+
+func TestGetCoverFB2(t *testing.T) {
+	dir := t.TempDir()
+	cover := testPNG(t, 30, 40)
+
+	path := filepath.Join(dir, "book.fb2")
+	require.NoError(t, os.WriteFile(path, []byte(testFB2(cover)), 0644))
+
+	data, err := getCoverFB2(path)
+	require.NoError(t, err)
+	assert.Equal(t, cover, data, "should decode the binary referenced by the coverpage")
+
+	page, err := ExtractCover(path, filepath.Join(dir, "cover.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, 30, page.Width)
+	assert.Equal(t, 40, page.Height)
+
+	// Without coverpage, an image named like a cover is used
+	content := strings.Replace(testFB2(cover), `<coverpage><image l:href="#cover.png"/></coverpage>`, "", 1)
+	path = filepath.Join(dir, "nocoverpage.fb2.zip")
+	createTestCBZ(t, path, []zipEntry{{Name: "book.fb2", Data: []byte(content)}})
+	data, err = getCoverFB2(path)
+	require.NoError(t, err)
+	assert.Equal(t, cover, data)
+
+	content = strings.Replace(content, `id="cover.png"`, `id="image.png"`, 1)
+	path = filepath.Join(dir, "nocover.fb2")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	_, err = getCoverFB2(path)
+	assert.Error(t, err, "should fail when there is no cover")
+}
@@ -0,0 +1,273 @@
+package archives
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Handler is a book format known to the package. A handler only describes
+// the format, what it can do with the books is given by the optional
+// interfaces it implements: InfoHandler, ExtractHandler, PageHandler,
+// CoverHandler and WriteHandler.
+type Handler interface {
+	// Name identifies the format, e.g. "pdf"
+	Name() string
+
+	// Extensions lists the file extensions of the format, without the
+	// leading dot, e.g. "cbz" or "fb2.zip"
+	Extensions() []string
+}
+
+// Sniffer is implemented by handlers that recognize their books from the
+// first bytes of the file
+type Sniffer interface {
+	Handler
+
+	// Sniff checks if the header, the first SniffLength bytes of a file (or
+	// less for smaller files), belongs to the format
+	Sniff(header []byte) bool
+}
+
+// SniffLength is the number of bytes given to Sniffer.Sniff
+const SniffLength = 512
+
+// InfoHandler is implemented by handlers that read the metadata of a book
+type InfoHandler interface {
+	Handler
+	GetBookInfo(ctx context.Context, path string) (BookInfo, error)
+}
+
+// ExtractHandler is implemented by handlers that extract the pages of a book
+// to an existing folder, opts is validated
+type ExtractHandler interface {
+	Handler
+	Extract(ctx context.Context, path, outputFolder string, opts ExtractOptions) ([]Page, error)
+}
+
+// PageHandler is implemented by handlers that extract a single page of a
+// book, opts is validated and has no page range
+type PageHandler interface {
+	Handler
+	ExtractPage(ctx context.Context, path string, index int, opts ExtractOptions) (io.ReadCloser, Page, error)
+}
+
+// CoverHandler is implemented by handlers that find the cover of a book
+type CoverHandler interface {
+	Handler
+	GetCover(ctx context.Context, path string) (CoverImage, error)
+}
+
+// WriteHandler is implemented by handlers that store metadata in a book
+type WriteHandler interface {
+	Handler
+	WriteBookInfo(ctx context.Context, path string, info BookInfo) error
+}
+
+// ConvertHandler is implemented by handlers that convert a book to a new CBZ
+// archive at outputFile, which does not exist, and return its number of
+// pages. opts is validated.
+type ConvertHandler interface {
+	Handler
+	ConvertToCBZ(ctx context.Context, path, outputFile string, opts ConvertOptions) (int, error)
+}
+
+// CoverImage holds a cover either as encoded image data, as found in the
+// book, or as an already decoded image (e.g. a rendered PDF page)
+type CoverImage struct {
+	Data  []byte
+	Image image.Image
+}
+
+// Capability is a set of operations supported by a handler
+type Capability uint
+
+const (
+	// CapabilityInfo is set for InfoHandler
+	CapabilityInfo Capability = 1 << iota
+	// CapabilityExtract is set for ExtractHandler
+	CapabilityExtract
+	// CapabilityCover is set for CoverHandler
+	CapabilityCover
+	// CapabilityWrite is set for WriteHandler
+	CapabilityWrite
+	// CapabilityPage is set for PageHandler
+	CapabilityPage
+	// CapabilityConvert is set for ConvertHandler
+	CapabilityConvert
+)
+
+var capabilityNames = []struct {
+	capability Capability
+	name       string
+}{
+	{CapabilityInfo, "info"},
+	{CapabilityExtract, "extract"},
+	{CapabilityPage, "page"},
+	{CapabilityCover, "cover"},
+	{CapabilityWrite, "write"},
+	{CapabilityConvert, "convert"},
+}
+
+// Has checks if all the capabilities of other are in c
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+// Names returns the names of the capabilities, e.g. ["info", "cover"]
+func (c Capability) Names() []string {
+	names := []string{}
+	for _, n := range capabilityNames {
+		if c.Has(n.capability) {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (c Capability) String() string {
+	return strings.Join(c.Names(), ",")
+}
+
+// Capabilities returns the operations supported by a handler
+func Capabilities(h Handler) Capability {
+	var c Capability
+	if _, ok := h.(InfoHandler); ok {
+		c |= CapabilityInfo
+	}
+	if _, ok := h.(ExtractHandler); ok {
+		c |= CapabilityExtract
+	}
+	if _, ok := h.(PageHandler); ok {
+		c |= CapabilityPage
+	}
+	if _, ok := h.(CoverHandler); ok {
+		c |= CapabilityCover
+	}
+	if _, ok := h.(WriteHandler); ok {
+		c |= CapabilityWrite
+	}
+	if _, ok := h.(ConvertHandler); ok {
+		c |= CapabilityConvert
+	}
+	return c
+}
+
+var (
+	// registryMu guards handlers and extensions
+	registryMu sync.RWMutex
+	// handlers in registration order
+	handlers []Handler
+	// extensions maps a lowercase extension to the index of its handler
+	extensions = map[string]int{}
+)
+
+func init() {
+	builtins := []Handler{
+		cbzHandler{comicHandler{ext: "cbz"}}, comicHandler{ext: "cbr"}, comicHandler{ext: "cb7"}, comicHandler{ext: "cbt"},
+		pdfHandler{}, epubHandler{}, mobiHandler{}, fb2Handler{},
+	}
+	for _, h := range builtins {
+		if err := Register(h); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Register adds a book format, so that every function of the package (and
+// every command) handles its extensions. A handler registered later for an
+// extension takes precedence, which allows replacing a built-in format.
+func Register(h Handler) error {
+	if h == nil || h.Name() == "" {
+		return errors.New("a format must have a name")
+	}
+	if len(h.Extensions()) == 0 {
+		return fmt.Errorf("format '%s' has no extension", h.Name())
+	}
+	exts := make([]string, len(h.Extensions()))
+	for i, ext := range h.Extensions() {
+		exts[i] = normalizeExtension(ext)
+		if exts[i] == "" {
+			return fmt.Errorf("format '%s' has an empty extension", h.Name())
+		}
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	handlers = append(handlers, h)
+	for _, ext := range exts {
+		extensions[ext] = len(handlers) - 1
+	}
+	return nil
+}
+
+// normalizeExtension lowercases an extension and removes its leading dot
+func normalizeExtension(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+// Handlers returns the registered formats that still handle at least one
+// extension, in registration order
+func Handlers() []Handler {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return activeHandlers()
+}
+
+// activeHandlers returns the handlers of at least one extension, registryMu
+// must be held
+func activeHandlers() []Handler {
+	used := map[int]bool{}
+	for _, i := range extensions {
+		used[i] = true
+	}
+
+	var active []Handler
+	for i, h := range handlers {
+		if used[i] {
+			active = append(active, h)
+		}
+	}
+	return active
+}
+
+// sniffers returns the active handlers recognizing their books, from the
+// last registered to the first
+func sniffers() []Sniffer {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var sniffers []Sniffer
+	active := activeHandlers()
+	for i := len(active) - 1; i >= 0; i-- {
+		if s, ok := active[i].(Sniffer); ok {
+			sniffers = append(sniffers, s)
+		}
+	}
+	return sniffers
+}
+
+// HandlerFor returns the format handling the extension of path. The longest
+// registered extension wins, e.g. "fb2.zip" over "zip".
+func HandlerFor(path string) (Handler, bool) {
+	name := strings.ToLower(filepath.Base(path))
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for i := 0; i < len(name); i++ {
+		if name[i] != '.' {
+			continue
+		}
+		if index, ok := extensions[name[i+1:]]; ok {
+			return handlers[index], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,184 @@
+package archives
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// textHandler is an in-house format: a text file whose first line is the
+// title and whose other lines are the pages
+type textHandler struct {
+	name string
+}
+
+func (h textHandler) Name() string { return h.name }
+
+func (textHandler) Extensions() []string { return []string{".TXTBOOK"} }
+
+func (textHandler) GetBookInfo(_ context.Context, path string) (BookInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return BookInfo{}, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	return BookInfo{Title: lines[0], Pages: len(lines) - 1}, nil
+}
+
+func (textHandler) Extract(_ context.Context, path, outputFolder string, _ ExtractOptions) ([]Page, error) {
+	page := Page{Path: filepath.Join(outputFolder, "page.txt")}
+	return []Page{page}, os.WriteFile(page.Path, []byte("page"), 0644)
+}
+
+// restoreRegistry puts the registered formats back at the end of the test
+func restoreRegistry(t *testing.T) {
+	t.Helper()
+	registryMu.RLock()
+	savedHandlers := append([]Handler(nil), handlers...)
+	savedExtensions := maps.Clone(extensions)
+	registryMu.RUnlock()
+
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		handlers = savedHandlers
+		extensions = savedExtensions
+	})
+}
+
+func TestRegisterCustomFormat(t *testing.T) {
+	restoreRegistry(t)
+	require.NoError(t, Register(textHandler{name: "text"}))
+
+	path := filepath.Join(t.TempDir(), "book.txtbook")
+	require.NoError(t, os.WriteFile(path, []byte("My Book\nfirst\nsecond\n"), 0644))
+
+	assert.True(t, IsValidBookFile(path), "registered extension should be valid")
+
+	info, err := GetBookInfo(path)
+	require.NoError(t, err)
+	assert.Equal(t, BookInfo{Title: "My Book", Pages: 2}, info)
+
+	output := filepath.Join(t.TempDir(), "pages")
+	pages, err := Extract(path, output)
+	require.NoError(t, err)
+	assert.Equal(t, []Page{{Path: filepath.Join(output, "page.txt")}}, pages)
+
+	_, err = GetCover(path)
+	assert.ErrorContains(t, err, "unsupported file format", "format has no cover capability")
+
+	assert.Equal(t, CapabilityInfo|CapabilityExtract, Capabilities(textHandler{}))
+
+	_, err = ConvertToCBZ(path, filepath.Join(t.TempDir(), "book.cbz"), ConvertOptions{})
+	assert.ErrorContains(t, err, "cannot convert", "format has no convert capability")
+}
+
+// convertibleTextHandler is a textHandler converted to a CBZ, with a blank
+// image per page
+type convertibleTextHandler struct {
+	textHandler
+}
+
+func (convertibleTextHandler) ConvertToCBZ(ctx context.Context, path, outputFile string, _ ConvertOptions) (int, error) {
+	info, err := textHandler{}.GetBookInfo(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Pages, writeCBZ(outputFile, info.Pages, func(w *zip.Writer) error {
+		for i := range info.Pages {
+			if _, err := w.Create(fmt.Sprintf("%02d.png", i+1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func TestRegisterConvertibleFormat(t *testing.T) {
+	restoreRegistry(t)
+	require.NoError(t, Register(convertibleTextHandler{textHandler{name: "text"}}))
+
+	path := filepath.Join(t.TempDir(), "book.txtbook")
+	require.NoError(t, os.WriteFile(path, []byte("My Book\nfirst\nsecond\n"), 0644))
+
+	output := filepath.Join(t.TempDir(), "book.cbz")
+	pages, err := ConvertToCBZ(path, output, ConvertOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, pages)
+	assert.FileExists(t, output)
+	assert.Equal(t, CapabilityInfo|CapabilityExtract|CapabilityConvert, Capabilities(convertibleTextHandler{}))
+}
+
+func TestRegisterOverride(t *testing.T) {
+	restoreRegistry(t)
+	require.NoError(t, Register(textHandler{name: "first"}))
+	require.NoError(t, Register(textHandler{name: "second"}))
+
+	h, ok := HandlerFor("book.txtbook")
+	require.True(t, ok)
+	assert.Equal(t, "second", h.Name(), "last registration should win")
+
+	var names []string
+	for _, h := range Handlers() {
+		names = append(names, h.Name())
+	}
+	assert.Equal(t, []string{"cbz", "cbr", "cb7", "cbt", "pdf", "epub", "mobi", "fb2", "second"}, names,
+		"replaced formats should not be listed")
+}
+
+func TestRegisterErrors(t *testing.T) {
+	restoreRegistry(t)
+	assert.Error(t, Register(nil))
+	assert.Error(t, Register(textHandler{}), "format without a name")
+	assert.Error(t, Register(emptyHandler{}), "format without extension")
+}
+
+type emptyHandler struct{}
+
+func (emptyHandler) Name() string { return "empty" }
+
+func (emptyHandler) Extensions() []string { return []string{"."} }
+
+func TestHandlerFor(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"book.cbz", "cbz"},
+		{filepath.Join("dir.pdf", "BOOK.CBR"), "cbr"},
+		{"book.fb2.zip", "fb2"},
+		{"my.book.fb2", "fb2"},
+		{"book.azw3", "mobi"},
+		{"book.zip", ""},
+		{"book", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			h, ok := HandlerFor(tt.path)
+			if tt.want == "" {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tt.want, h.Name())
+		})
+	}
+}
+
+func TestCapabilityNames(t *testing.T) {
+	assert.Equal(t, []string{}, Capability(0).Names())
+	assert.Equal(t, "info,extract,page,cover,write,convert", Capabilities(cbzHandler{comicHandler{ext: "cbz"}}).String())
+	assert.Equal(t, "info,extract,page,cover,convert", Capabilities(comicHandler{ext: "cbr"}).String())
+	assert.Equal(t, "info,cover", Capabilities(mobiHandler{}).String())
+	assert.True(t, Capabilities(pdfHandler{}).Has(CapabilityInfo|CapabilityPage|CapabilityCover))
+	assert.False(t, Capabilities(pdfHandler{}).Has(CapabilityWrite))
+}
@@ -0,0 +1,329 @@
+package archives
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Default limits of the extraction of comic book archives and EPUB books
+const (
+	DefaultMaxTotalSize        = 8 << 30
+	DefaultMaxEntrySize        = 1 << 30
+	DefaultMaxEntries          = 10000
+	DefaultMaxImagePixels      = 16384 * 16384
+	DefaultMaxCompressionRatio = 100
+)
+
+// compressionRatioMinSize is the size extracted from an archive before its
+// compression ratio is checked, small archives of text files compress well
+const compressionRatioMinSize = 1 << 20
+
+// ExtractLimits protect the extraction of comic book archives and EPUB books
+// against archive bombs. Zero means the default limit, a negative value means no limit.
+type ExtractLimits struct {
+	// MaxTotalSize is the number of bytes written, DefaultMaxTotalSize when zero
+	MaxTotalSize int64
+
+	// MaxEntrySize is the number of bytes written for a single file,
+	// DefaultMaxEntrySize when zero
+	MaxEntrySize int64
+
+	// MaxEntries is the number of files written, DefaultMaxEntries when zero
+	MaxEntries int
+
+	// MaxImagePixels is the width times the height of an image, as decoding
+	// it allocates memory for every pixel. DefaultMaxImagePixels when zero.
+	MaxImagePixels int64
+
+	// MaxCompressionRatio is the number of bytes written for a byte of the
+	// archive, DefaultMaxCompressionRatio when zero
+	MaxCompressionRatio float64
+}
+
+// withDefaults returns the limits with the zero values replaced by defaults
+func (l ExtractLimits) withDefaults() ExtractLimits {
+	if l.MaxTotalSize == 0 {
+		l.MaxTotalSize = DefaultMaxTotalSize
+	}
+	if l.MaxEntrySize == 0 {
+		l.MaxEntrySize = DefaultMaxEntrySize
+	}
+	if l.MaxEntries == 0 {
+		l.MaxEntries = DefaultMaxEntries
+	}
+	if l.MaxImagePixels == 0 {
+		l.MaxImagePixels = DefaultMaxImagePixels
+	}
+	if l.MaxCompressionRatio == 0 {
+		l.MaxCompressionRatio = DefaultMaxCompressionRatio
+	}
+	return l
+}
+
+// UnsafeEntryError is returned for an entry of an archive that would be
+// written outside of the output folder, or that is a link
+type UnsafeEntryError struct {
+	Name   string
+	Reason string
+}
+
+func (e UnsafeEntryError) Error() string {
+	return fmt.Sprintf("unsafe archive entry '%s': %s", e.Name, e.Reason)
+}
+
+// LimitError is returned when extracting an archive would exceed one of its
+// ExtractLimits, Name is the entry that exceeds it
+type LimitError struct {
+	Name  string
+	Limit string
+	Value float64
+	Max   float64
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("archive entry '%s' exceeds the %s limit: %g > %g", e.Name, e.Limit, e.Value, e.Max)
+}
+
+// extractGuard checks the entries of an archive before and while they are
+// written
+type extractGuard struct {
+	limits      ExtractLimits
+	archiveSize int64
+	// unsafe are the entries rejected when listing the archive, by the name
+	// returned by unarr
+	unsafe map[string]UnsafeEntryError
+
+	entries int
+	total   int64
+}
+
+// newExtractGuard checks the entries of the archive at path against limits
+func newExtractGuard(path string, limits ExtractLimits) (*extractGuard, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat archive: %w", err)
+	}
+	unsafe, err := unsafeEntries(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive: %w", err)
+	}
+	return &extractGuard{limits: limits.withDefaults(), archiveSize: stat.Size(), unsafe: unsafe}, nil
+}
+
+// checkEntry checks an entry before it is read from the size stored in the
+// archive: rawName is its name as stored in the archive, empty when unarr does
+// not know it, name the one sanitized by unarr
+func (g *extractGuard) checkEntry(rawName, name string, size int64) error {
+	if rawName != "" {
+		if err := checkEntryName(rawName); err != nil {
+			return err
+		}
+	}
+	if err, ok := g.unsafe[name]; ok {
+		return err
+	}
+
+	g.entries++
+	l := g.limits
+	if l.MaxEntries > 0 && g.entries > l.MaxEntries {
+		return LimitError{Name: rawName, Limit: "entry count", Value: float64(g.entries), Max: float64(l.MaxEntries)}
+	}
+	if l.MaxEntrySize > 0 && size > l.MaxEntrySize {
+		return LimitError{Name: rawName, Limit: "entry size", Value: float64(size), Max: float64(l.MaxEntrySize)}
+	}
+	g.total += size
+	return g.checkTotal(rawName)
+}
+
+// checkTotal checks the size extracted from the archive so far
+func (g *extractGuard) checkTotal(rawName string) error {
+	l := g.limits
+	if l.MaxTotalSize > 0 && g.total > l.MaxTotalSize {
+		return LimitError{Name: rawName, Limit: "total size", Value: float64(g.total), Max: float64(l.MaxTotalSize)}
+	}
+	if l.MaxCompressionRatio > 0 && g.total > compressionRatioMinSize {
+		ratio := float64(g.total) / float64(max(g.archiveSize, 1))
+		if ratio > l.MaxCompressionRatio {
+			return LimitError{Name: rawName, Limit: "compression ratio", Value: ratio, Max: l.MaxCompressionRatio}
+		}
+	}
+	return nil
+}
+
+// checkImage checks the dimensions of an image entry from its header, and
+// returns r rewound to its beginning. Images that cannot be decoded are
+// accepted, they are not listed as pages.
+func (g *extractGuard) checkImage(name string, r io.Reader) (io.Reader, error) {
+	var head bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+	r = io.MultiReader(&head, r)
+	if err != nil || g.limits.MaxImagePixels < 0 {
+		return r, nil
+	}
+	pixels := int64(config.Width) * int64(config.Height)
+	if pixels > g.limits.MaxImagePixels {
+		return nil, LimitError{Name: name, Limit: "image pixels", Value: float64(pixels), Max: float64(g.limits.MaxImagePixels)}
+	}
+	return r, nil
+}
+
+// writeEntry writes an entry checked by checkEntry below root, name being
+// slash separated. The entry is streamed to the file and counted, so that an
+// entry larger than its stored size still stops at the limits. The file is
+// removed when a limit is exceeded.
+func (g *extractGuard) writeEntry(root *os.Root, name string, size int64, r io.Reader) error {
+	if validImage(name) {
+		var err error
+		if r, err = g.checkImage(name, r); err != nil {
+			return err
+		}
+	}
+
+	w := &entryWriter{guard: g, name: name, size: size}
+	err := writeFileInRoot(root, name, func(f io.Writer) error {
+		w.w = f
+		_, err := io.Copy(w, r)
+		return err
+	})
+	var limit LimitError
+	if errors.As(err, &limit) {
+		root.Remove(name)
+	}
+	return err
+}
+
+// entryWriter counts the bytes of an entry written to w against the limits
+// of guard, past the stored size of the entry
+type entryWriter struct {
+	w       io.Writer
+	guard   *extractGuard
+	name    string
+	size    int64
+	written int64
+}
+
+func (w *entryWriter) Write(p []byte) (int, error) {
+	l := w.guard.limits
+	written := w.written + int64(len(p))
+	if l.MaxEntrySize > 0 && written > l.MaxEntrySize {
+		return 0, LimitError{Name: w.name, Limit: "entry size", Value: float64(written), Max: float64(l.MaxEntrySize)}
+	}
+	if extra := written - max(w.written, w.size); extra > 0 {
+		w.guard.total += extra
+		if err := w.guard.checkTotal(w.name); err != nil {
+			return 0, err
+		}
+	}
+	w.written = written
+	return w.w.Write(p)
+}
+
+// checkEntryName rejects the names of entries that are absolute or outside
+// of the output folder, with "/" or "\\" as separator
+func checkEntryName(rawName string) error {
+	name := strings.ReplaceAll(rawName, `\`, "/")
+	clean := path.Clean(name)
+	switch {
+	case name == "" || clean == "." || strings.ContainsRune(name, 0):
+		return UnsafeEntryError{Name: rawName, Reason: "invalid name"}
+	case strings.HasPrefix(clean, "/") || (len(clean) >= 2 && clean[1] == ':'):
+		return UnsafeEntryError{Name: rawName, Reason: "absolute path"}
+	case clean == ".." || strings.HasPrefix(clean, "../"):
+		return UnsafeEntryError{Name: rawName, Reason: "path outside of the output folder"}
+	}
+	return nil
+}
+
+// unarrName returns the name unarr gives to an entry, stripped of its leading
+// "/" and "../"
+func unarrName(name string) string {
+	name = strings.TrimPrefix(filepath.Clean(name), "/")
+	for strings.HasPrefix(name, "../") {
+		name = name[len("../"):]
+	}
+	return name
+}
+
+// unsafeEntries lists the unsafe entries of ZIP and tar archives by their
+// unarr name: unarr reads links as regular files and does not return the raw
+// name of tar entries. The links of the other formats are not detected.
+func unsafeEntries(path string) (map[string]UnsafeEntryError, error) {
+	header, err := readHeader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	unsafe := map[string]UnsafeEntryError{}
+	add := func(name string, link bool) {
+		if err := checkEntryName(name); err != nil {
+			unsafe[unarrName(name)] = err.(UnsafeEntryError)
+		} else if link {
+			unsafe[unarrName(name)] = UnsafeEntryError{Name: name, Reason: "link"}
+		}
+	}
+
+	switch {
+	case isZIP(header):
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			// unarr reports the errors of the archive
+			return unsafe, nil
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			add(f.Name, f.Mode()&fs.ModeSymlink != 0)
+		}
+	case isTar(header):
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r := tar.NewReader(file)
+		for {
+			h, err := r.Next()
+			if err != nil {
+				// unarr reports the errors of the archive
+				break
+			}
+			add(h.Name, h.Typeflag == tar.TypeSymlink || h.Typeflag == tar.TypeLink)
+		}
+	}
+	return unsafe, nil
+}
+
+// writeFileInRoot creates a file below root, name being slash separated, and
+// fills it with write. The root prevents following a link out of the output
+// folder.
+func writeFileInRoot(root *os.Root, name string, write func(io.Writer) error) error {
+	dir := ""
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			continue
+		}
+		dir = path.Join(dir, part)
+		if err := root.Mkdir(dir, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+
+	f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,141 @@
+package archives
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractArchiveUnsafeEntries(t *testing.T) {
+	page := testPNG(t, 10, 20)
+
+	tests := []struct {
+		name   string
+		create func(t *testing.T, path string)
+		entry  string
+	}{
+		{"traversal", func(t *testing.T, path string) {
+			createTestCBT(t, path, []zipEntry{{Name: "1.png", Data: page}, {Name: "../../evil.png", Data: page}})
+		}, "../../evil.png"},
+		{"absolute path", func(t *testing.T, path string) {
+			createTestCBT(t, path, []zipEntry{{Name: "/tmp/evil.png", Data: page}})
+		}, "/tmp/evil.png"},
+		{"tar symlink", func(t *testing.T, path string) {
+			file, err := os.Create(path)
+			require.NoError(t, err)
+			defer file.Close()
+			w := tar.NewWriter(file)
+			require.NoError(t, w.WriteHeader(&tar.Header{Name: "1.png", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}))
+			require.NoError(t, w.Close())
+		}, "1.png"},
+		{"zip symlink", func(t *testing.T, path string) {
+			file, err := os.Create(path)
+			require.NoError(t, err)
+			defer file.Close()
+			w := zip.NewWriter(file)
+			header := &zip.FileHeader{Name: "1.png"}
+			header.SetMode(fs.ModeSymlink | 0777)
+			f, err := w.CreateHeader(header)
+			require.NoError(t, err)
+			_, err = f.Write([]byte("/etc/passwd"))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+		}, "1.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "book.cbz")
+			tt.create(t, input)
+
+			output := filepath.Join(dir, "a", "b")
+			_, err := Extract(input, output)
+			var unsafe UnsafeEntryError
+			require.True(t, errors.As(err, &unsafe), "should return an UnsafeEntryError, got %v", err)
+			assert.Equal(t, tt.entry, unsafe.Name)
+
+			_, err = os.Stat(filepath.Join(dir, "evil.png"))
+			assert.True(t, os.IsNotExist(err), "should not write outside of the output folder")
+		})
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	page := testPNG(t, 20, 30)
+	zeros := make([]byte, 4<<20)
+
+	tests := []struct {
+		name    string
+		entries []zipEntry
+		limits  ExtractLimits
+		limit   string
+	}{
+		{"entries", []zipEntry{{Name: "1.png", Data: page}, {Name: "2.png", Data: page}}, ExtractLimits{MaxEntries: 1}, "entry count"},
+		{"total size", []zipEntry{{Name: "1.png", Data: page}, {Name: "2.png", Data: page}}, ExtractLimits{MaxTotalSize: int64(len(page)) + 1}, "total size"},
+		{"entry size", []zipEntry{{Name: "1.png", Data: page}, {Name: "zeros.bin", Data: zeros}}, ExtractLimits{MaxEntrySize: 1 << 20}, "entry size"},
+		{"image pixels", []zipEntry{{Name: "1.png", Data: page}}, ExtractLimits{MaxImagePixels: 599}, "image pixels"},
+		{"compression ratio", []zipEntry{{Name: "1.png", Data: page}, {Name: "zeros.bin", Data: zeros}}, ExtractLimits{}, "compression ratio"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := filepath.Join(t.TempDir(), "book.cbz")
+			createTestCBZ(t, input, tt.entries)
+
+			_, err := ExtractWithOptions(input, t.TempDir(), ExtractOptions{Limits: tt.limits})
+			var limit LimitError
+			require.True(t, errors.As(err, &limit), "should return a LimitError, got %v", err)
+			assert.Equal(t, tt.limit, limit.Limit)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		input := filepath.Join(t.TempDir(), "book.cbz")
+		createTestCBZ(t, input, []zipEntry{{Name: "1.png", Data: page}, {Name: "zeros.bin", Data: zeros}})
+
+		limits := ExtractLimits{MaxTotalSize: -1, MaxEntrySize: -1, MaxEntries: -1, MaxImagePixels: -1, MaxCompressionRatio: -1}
+		pages, err := ExtractWithOptions(input, t.TempDir(), ExtractOptions{Limits: limits})
+		require.NoError(t, err)
+		assert.Len(t, pages, 1)
+	})
+}
+
+func TestExtractGuardWriteEntry(t *testing.T) {
+	root, err := os.OpenRoot(t.TempDir())
+	require.NoError(t, err)
+	defer root.Close()
+
+	// An entry larger than its stored size stops at the limits
+	guard := &extractGuard{limits: ExtractLimits{MaxEntrySize: 10}.withDefaults()}
+	require.NoError(t, guard.checkEntry("big.bin", "big.bin", 4))
+	err = guard.writeEntry(root, "big.bin", 4, bytes.NewReader(make([]byte, 100)))
+	var limit LimitError
+	require.True(t, errors.As(err, &limit), "should return a LimitError, got %v", err)
+	assert.Equal(t, "entry size", limit.Limit)
+	_, err = root.Stat("big.bin")
+	assert.True(t, os.IsNotExist(err), "should remove the partial file")
+
+	guard = &extractGuard{limits: ExtractLimits{MaxTotalSize: 50}.withDefaults()}
+	require.NoError(t, guard.checkEntry("big.bin", "big.bin", 4))
+	err = guard.writeEntry(root, "big.bin", 4, bytes.NewReader(make([]byte, 100)))
+	require.True(t, errors.As(err, &limit), "should return a LimitError, got %v", err)
+	assert.Equal(t, "total size", limit.Limit)
+
+	// Images are checked from their header, and written entirely
+	page := testPNG(t, 20, 30)
+	guard = &extractGuard{limits: ExtractLimits{}.withDefaults()}
+	require.NoError(t, guard.checkEntry("dir/1.png", "dir/1.png", int64(len(page))))
+	require.NoError(t, guard.writeEntry(root, "dir/1.png", int64(len(page)), bytes.NewReader(page)))
+	data, err := os.ReadFile(filepath.Join(root.Name(), "dir", "1.png"))
+	require.NoError(t, err)
+	assert.Equal(t, page, data)
+}
@@ -0,0 +1,366 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Kindle books (.mobi, .azw, .azw3, .prc) are Palm databases: a header
+// followed by a list of records. The first record holds the PalmDOC header,
+// the MOBI header and the EXTH metadata records, images are stored in
+// consecutive records starting at the first image index of the MOBI header.
+// KF8 (.azw3) files use the same container and keep their metadata in the
+// first record as well.
+//
+// See https://wiki.mobileread.com/wiki/MOBI and https://wiki.mobileread.com/wiki/PDB
+
+const (
+	pdbHeaderSize    = 78
+	pdbRecordEntry   = 8
+	palmDOCHeader    = 16
+	mobiEncodingUTF8 = 65001
+	mobiEXTHFlag     = 0x40
+	mobiNoIndex      = 0xFFFFFFFF
+)
+
+// EXTH record types
+const (
+	exthAuthor       = 100
+	exthPublisher    = 101
+	exthDescription  = 103
+	exthISBN         = 104
+	exthSubject      = 105
+	exthPublished    = 106
+	exthCoverOffset  = 201
+	exthThumbOffset  = 202
+	exthUpdatedTitle = 503
+	exthLanguage     = 524
+)
+
+// mobiLanguages maps the primary language of a Windows locale, as found in
+// the MOBI header, to its ISO 639-1 code
+var mobiLanguages = map[uint32]string{
+	0x01: "ar", 0x04: "zh", 0x05: "cs", 0x06: "da", 0x07: "de", 0x08: "el",
+	0x09: "en", 0x0a: "es", 0x0b: "fi", 0x0c: "fr", 0x0d: "he", 0x0e: "hu",
+	0x10: "it", 0x11: "ja", 0x12: "ko", 0x13: "nl", 0x14: "no", 0x15: "pl",
+	0x16: "pt", 0x19: "ru", 0x1d: "sv", 0x1f: "tr",
+}
+
+// mobiFile is an opened Palm database
+type mobiFile struct {
+	file    *os.File
+	size    int64
+	name    string
+	kind    string
+	offsets []int64
+}
+
+// mobiHeader holds the fields of the first record used by bookkeeper
+type mobiHeader struct {
+	fullName   string
+	locale     uint32
+	firstImage uint32
+	utf8       bool
+	exth       map[uint32][][]byte
+}
+
+func openMOBI(path string) (*mobiFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MOBI file: %w", err)
+	}
+
+	m, err := readPDB(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read MOBI file: %w", err)
+	}
+	return m, nil
+}
+
+// readPDB reads the Palm database header and its record list
+func readPDB(file *os.File) (*mobiFile, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, pdbHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("invalid Palm database header: %w", err)
+	}
+
+	m := &mobiFile{
+		file: file,
+		size: info.Size(),
+		name: string(bytes.TrimRight(header[:32], "\x00")),
+		kind: string(header[60:68]),
+	}
+	if m.kind != "BOOKMOBI" && m.kind != "TEXtREAd" {
+		return nil, fmt.Errorf("unsupported Palm database type '%s'", m.kind)
+	}
+
+	count := int(binary.BigEndian.Uint16(header[76:78]))
+	if count == 0 {
+		return nil, errors.New("Palm database has no records")
+	}
+	list := make([]byte, count*pdbRecordEntry)
+	if _, err := file.ReadAt(list, pdbHeaderSize); err != nil {
+		return nil, fmt.Errorf("invalid Palm database record list: %w", err)
+	}
+
+	m.offsets = make([]int64, count)
+	for i := range count {
+		offset := int64(binary.BigEndian.Uint32(list[i*pdbRecordEntry:]))
+		if offset > m.size || (i > 0 && offset < m.offsets[i-1]) {
+			return nil, fmt.Errorf("invalid offset for record %d", i)
+		}
+		m.offsets[i] = offset
+	}
+	return m, nil
+}
+
+func (m *mobiFile) Close() error {
+	return m.file.Close()
+}
+
+// record reads the content of the record at index
+func (m *mobiFile) record(index int) ([]byte, error) {
+	if index < 0 || index >= len(m.offsets) {
+		return nil, fmt.Errorf("record %d out of range", index)
+	}
+	end := m.size
+	if index+1 < len(m.offsets) {
+		end = m.offsets[index+1]
+	}
+
+	data := make([]byte, end-m.offsets[index])
+	if _, err := m.file.ReadAt(data, m.offsets[index]); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read record %d: %w", index, err)
+	}
+	return data, nil
+}
+
+// header parses the MOBI header and the EXTH records of the first record.
+// Plain PalmDOC files have no MOBI header, an empty header is returned.
+func (m *mobiFile) header() (mobiHeader, error) {
+	h := mobiHeader{firstImage: mobiNoIndex, exth: map[uint32][][]byte{}}
+
+	record, err := m.record(0)
+	if err != nil {
+		return h, err
+	}
+	if len(record) < palmDOCHeader+8 || string(record[palmDOCHeader:palmDOCHeader+4]) != "MOBI" {
+		return h, nil
+	}
+
+	mobi := record[palmDOCHeader:]
+	headerLength := int(binary.BigEndian.Uint32(mobi[4:8]))
+	if headerLength < 116 || palmDOCHeader+headerLength > len(record) {
+		return h, fmt.Errorf("invalid MOBI header length %d", headerLength)
+	}
+
+	h.utf8 = binary.BigEndian.Uint32(mobi[12:16]) == mobiEncodingUTF8
+	h.locale = binary.BigEndian.Uint32(mobi[76:80])
+	h.firstImage = binary.BigEndian.Uint32(mobi[92:96])
+
+	nameOffset := int(binary.BigEndian.Uint32(mobi[68:72]))
+	nameLength := int(binary.BigEndian.Uint32(mobi[72:76]))
+	if nameOffset > 0 && nameOffset+nameLength <= len(record) {
+		h.fullName = h.decode(record[nameOffset : nameOffset+nameLength])
+	}
+
+	if binary.BigEndian.Uint32(mobi[112:116])&mobiEXTHFlag != 0 {
+		if err := h.readEXTH(record[palmDOCHeader+headerLength:]); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// readEXTH collects the EXTH records by type
+func (h *mobiHeader) readEXTH(data []byte) error {
+	if len(data) < 12 || string(data[:4]) != "EXTH" {
+		return errors.New("invalid EXTH header")
+	}
+
+	count := binary.BigEndian.Uint32(data[8:12])
+	data = data[12:]
+	for range count {
+		if len(data) < 8 {
+			return errors.New("truncated EXTH record")
+		}
+		recordType := binary.BigEndian.Uint32(data[:4])
+		length := binary.BigEndian.Uint32(data[4:8])
+		if length < 8 || int64(length) > int64(len(data)) {
+			return fmt.Errorf("invalid length for EXTH record %d", recordType)
+		}
+		h.exth[recordType] = append(h.exth[recordType], data[8:length])
+		data = data[length:]
+	}
+	return nil
+}
+
+// decode converts a string of the book to UTF-8, older books are encoded in CP1252
+func (h *mobiHeader) decode(data []byte) string {
+	if h.utf8 {
+		return strings.TrimSpace(string(data))
+	}
+	s, err := charmap.Windows1252.NewDecoder().Bytes(data)
+	if err != nil {
+		return strings.TrimSpace(string(data))
+	}
+	return strings.TrimSpace(string(s))
+}
+
+// strings returns the non-empty values of an EXTH record type
+func (h *mobiHeader) strings(recordType uint32) []string {
+	var values []string
+	for _, data := range h.exth[recordType] {
+		if s := h.decode(data); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// string returns the first non-empty value of an EXTH record type
+func (h *mobiHeader) string(recordType uint32) string {
+	values := h.strings(recordType)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// offset returns the value of a numeric EXTH record type
+func (h *mobiHeader) offset(recordType uint32) (uint32, bool) {
+	values := h.exth[recordType]
+	if len(values) == 0 || len(values[0]) != 4 {
+		return 0, false
+	}
+	offset := binary.BigEndian.Uint32(values[0])
+	return offset, offset != mobiNoIndex
+}
+
+// getBookInfoMOBI extracts metadata from Kindle books
+func getBookInfoMOBI(path string) (BookInfo, error) {
+	m, err := openMOBI(path)
+	if err != nil {
+		return BookInfo{}, err
+	}
+	defer m.Close()
+
+	h, err := m.header()
+	if err != nil {
+		return BookInfo{}, fmt.Errorf("failed to read MOBI header: %w", err)
+	}
+
+	// Extract title - use filename as fallback
+	title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if t := h.string(exthUpdatedTitle); t != "" {
+		title = t
+	} else if h.fullName != "" {
+		title = h.fullName
+	} else if m.name != "" {
+		title = m.name
+	}
+
+	var language []string
+	if lang := h.string(exthLanguage); lang != "" {
+		language = []string{lang}
+	} else if lang, ok := mobiLanguages[h.locale&0xFF]; ok {
+		language = []string{lang}
+	}
+
+	// Subjects are sometimes stored in a single record, separated by semicolons
+	var keywords []string
+	for _, subject := range h.strings(exthSubject) {
+		for _, s := range strings.Split(subject, ";") {
+			if s = strings.TrimSpace(s); s != "" {
+				keywords = append(keywords, s)
+			}
+		}
+	}
+
+	return BookInfo{
+		Title:         title,
+		Language:      language,
+		Description:   h.string(exthDescription),
+		Authors:       h.strings(exthAuthor),
+		Publisher:     h.string(exthPublisher),
+		PublishedDate: h.string(exthPublished),
+		ISBN:          h.string(exthISBN),
+		Keywords:      keywords,
+	}, nil
+}
+
+// getCoverMOBI returns the image record referenced as the cover in the EXTH
+// records, falling back to the thumbnail and then to the first image
+func getCoverMOBI(path string) ([]byte, error) {
+	m, err := openMOBI(path)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	h, err := m.header()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MOBI header: %w", err)
+	}
+	if h.firstImage == mobiNoIndex {
+		return nil, fmt.Errorf("no cover found in MOBI '%s'", path)
+	}
+
+	var candidates []uint32
+	if offset, ok := h.offset(exthCoverOffset); ok {
+		candidates = append(candidates, offset)
+	}
+	if offset, ok := h.offset(exthThumbOffset); ok {
+		candidates = append(candidates, offset)
+	}
+	candidates = append(candidates, 0)
+
+	for _, offset := range candidates {
+		index := int64(h.firstImage) + int64(offset)
+		if index >= int64(len(m.offsets)) {
+			continue
+		}
+		data, err := m.record(int(index))
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("no cover found in MOBI '%s'", path)
+}
+
+// mobiHandler reads Kindle books
+type mobiHandler struct{}
+
+func (mobiHandler) Name() string { return "mobi" }
+
+func (mobiHandler) Extensions() []string { return []string{"mobi", "azw", "azw3", "prc"} }
+
+func (mobiHandler) Sniff(header []byte) bool { return isMOBI(header) }
+
+func (mobiHandler) GetBookInfo(_ context.Context, path string) (BookInfo, error) {
+	return getBookInfoMOBI(path)
+}
+
+func (mobiHandler) GetCover(_ context.Context, path string) (CoverImage, error) {
+	data, err := getCoverMOBI(path)
+	return CoverImage{Data: data}, err
+}
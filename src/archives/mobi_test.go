@@ -0,0 +1,168 @@
+package archives
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exthRecord is an EXTH metadata record of a test MOBI file
+type exthRecord struct {
+	Type uint32
+	Data []byte
+}
+
+// exthOffset encodes a numeric EXTH record value
+func exthOffset(offset uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, offset)
+}
+
+// createTestMOBI writes a minimal MOBI file: a first record holding the
+// headers, a text record and the given image records
+func createTestMOBI(t *testing.T, path string, fullName string, encoding uint32, exth []exthRecord, images [][]byte) {
+	t.Helper()
+
+	var exthData bytes.Buffer
+	for _, r := range exth {
+		binary.Write(&exthData, binary.BigEndian, r.Type)
+		binary.Write(&exthData, binary.BigEndian, uint32(len(r.Data)+8))
+		exthData.Write(r.Data)
+	}
+
+	const mobiHeaderLength = 232
+	mobi := make([]byte, mobiHeaderLength)
+	copy(mobi, "MOBI")
+	binary.BigEndian.PutUint32(mobi[4:], mobiHeaderLength)
+	binary.BigEndian.PutUint32(mobi[8:], 2)
+	binary.BigEndian.PutUint32(mobi[12:], encoding)
+	binary.BigEndian.PutUint32(mobi[20:], 6)
+	nameOffset := palmDOCHeader + mobiHeaderLength + 12 + exthData.Len()
+	binary.BigEndian.PutUint32(mobi[68:], uint32(nameOffset))
+	binary.BigEndian.PutUint32(mobi[72:], uint32(len(fullName)))
+	binary.BigEndian.PutUint32(mobi[76:], 0x0409)
+	binary.BigEndian.PutUint32(mobi[92:], 2)
+	binary.BigEndian.PutUint32(mobi[112:], mobiEXTHFlag)
+
+	var record0 bytes.Buffer
+	record0.Write(make([]byte, palmDOCHeader))
+	record0.Write(mobi)
+	record0.WriteString("EXTH")
+	binary.Write(&record0, binary.BigEndian, uint32(12+exthData.Len()))
+	binary.Write(&record0, binary.BigEndian, uint32(len(exth)))
+	record0.Write(exthData.Bytes())
+	record0.WriteString(fullName)
+
+	records := append([][]byte{record0.Bytes(), []byte("<html><body>Text</body></html>")}, images...)
+
+	header := make([]byte, pdbHeaderSize)
+	copy(header, "Test_Book")
+	copy(header[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(header[76:], uint16(len(records)))
+
+	var file bytes.Buffer
+	file.Write(header)
+	offset := pdbHeaderSize + len(records)*pdbRecordEntry
+	for i, record := range records {
+		binary.Write(&file, binary.BigEndian, uint32(offset))
+		binary.Write(&file, binary.BigEndian, uint32(i))
+		offset += len(record)
+	}
+	for _, record := range records {
+		file.Write(record)
+	}
+
+	require.NoError(t, os.WriteFile(path, file.Bytes(), 0644))
+}
+
+func TestGetBookInfoMOBI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.azw3")
+	createTestMOBI(t, path, "Full Name", mobiEncodingUTF8, []exthRecord{
+		{exthAuthor, []byte("Jules Verne")},
+		{exthAuthor, []byte("Another Author")},
+		{exthPublisher, []byte("Hetzel")},
+		{exthDescription, []byte("A journey around the world.")},
+		{exthISBN, []byte("9780000000002")},
+		{exthSubject, []byte("Adventure; Travel")},
+		{exthPublished, []byte("1872-11-06")},
+		{exthUpdatedTitle, []byte("Le Tour du monde en quatre-vingts jours")},
+		{exthLanguage, []byte("fr")},
+	}, nil)
+
+	book, err := GetBookInfo(path)
+	require.NoError(t, err, "should successfully read MOBI file")
+
+	assert.Equal(t, BookInfo{
+		Title:         "Le Tour du monde en quatre-vingts jours",
+		Language:      []string{"fr"},
+		Description:   "A journey around the world.",
+		Authors:       []string{"Jules Verne", "Another Author"},
+		Publisher:     "Hetzel",
+		PublishedDate: "1872-11-06",
+		ISBN:          "9780000000002",
+		Keywords:      []string{"Adventure", "Travel"},
+	}, book)
+}
+
+func TestGetBookInfoMOBIFallbacks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.mobi")
+	// "Café" in CP1252
+	createTestMOBI(t, path, "Caf\xe9", 1252, []exthRecord{{exthAuthor, []byte("J\xe9r\xf4me")}}, nil)
+
+	book, err := getBookInfoMOBI(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Café", book.Title, "should use the full name and decode CP1252")
+	assert.Equal(t, []string{"Jérôme"}, book.Authors)
+	assert.Equal(t, []string{"en"}, book.Language, "should use the locale of the MOBI header")
+}
+
+func TestGetBookInfoMOBIErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	notPDB := filepath.Join(dir, "text.mobi")
+	require.NoError(t, os.WriteFile(notPDB, bytes.Repeat([]byte("text"), 40), 0644))
+	truncated := filepath.Join(dir, "truncated.mobi")
+	require.NoError(t, os.WriteFile(truncated, []byte("short"), 0644))
+
+	for _, path := range []string{filepath.Join(dir, "missing.mobi"), notPDB, truncated} {
+		_, err := getBookInfoMOBI(path)
+		assert.Error(t, err, "should fail to read %s", filepath.Base(path))
+	}
+}
+
+func TestGetCoverMOBI(t *testing.T) {
+	dir := t.TempDir()
+	thumbnail := testPNG(t, 10, 20)
+	cover := testPNG(t, 30, 40)
+
+	path := filepath.Join(dir, "book.mobi")
+	createTestMOBI(t, path, "Book", mobiEncodingUTF8, []exthRecord{
+		{exthCoverOffset, exthOffset(1)},
+		{exthThumbOffset, exthOffset(0)},
+	}, [][]byte{thumbnail, cover})
+
+	data, err := getCoverMOBI(path)
+	require.NoError(t, err)
+	assert.Equal(t, cover, data, "should use the cover offset")
+
+	page, err := ExtractCover(path, filepath.Join(dir, "cover.png"))
+	require.NoError(t, err)
+	assert.Equal(t, 30, page.Width)
+	assert.Equal(t, 40, page.Height)
+
+	// Without cover offset, the first image is used
+	path = filepath.Join(dir, "first.mobi")
+	createTestMOBI(t, path, "Book", mobiEncodingUTF8, nil, [][]byte{thumbnail, cover})
+	data, err = getCoverMOBI(path)
+	require.NoError(t, err)
+	assert.Equal(t, thumbnail, data)
+
+	path = filepath.Join(dir, "nocover.mobi")
+	createTestMOBI(t, path, "Book", mobiEncodingUTF8, nil, nil)
+	_, err = getCoverMOBI(path)
+	assert.Error(t, err, "should fail when there is no image")
+}
@@ -1,46 +1,156 @@
 package archives
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"image/jpeg"
+	"image"
+	"image/color"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/klippa-app/go-pdfium"
+	"github.com/klippa-app/go-pdfium/enums"
+	"github.com/klippa-app/go-pdfium/references"
 	"github.com/klippa-app/go-pdfium/requests"
 	"github.com/klippa-app/go-pdfium/webassembly"
 )
 
 var (
-	pool     pdfium.Pool
-	instance pdfium.Pdfium
+	// pdfiumMu guards pdfiumPool and pdfiumConfig
+	pdfiumMu sync.RWMutex
+	// pdfiumPool is initialized on first use, so that reading other formats
+	// does not start a WebAssembly runtime
+	pdfiumPool pdfium.Pool
+	// pdfiumConfig is the configuration of the WebAssembly pool
+	pdfiumConfig = webassembly.Config{
+		MinIdle:  1, // Ensures at least 1 worker is always available
+		MaxIdle:  1, // Keeps the workers around when idle
+		MaxTotal: 1, // Number of PDF files processed concurrently
+	}
 )
 
-func init() {
-	// Initialize PDFium pool with WebAssembly
-	var err error
-	pool, err = webassembly.Init(webassembly.Config{
-		MinIdle:  1, // Ensures at least 1 worker is always available
-		MaxIdle:  1, // Limits to 1 worker when idle
-		MaxTotal: 1, // Maximum 1 worker total
-	})
-	if err != nil {
-		panic(fmt.Sprintf("failed to initialize PDFium pool: %v", err))
+// getPDFiumPool returns the PDFium pool, initializing it on first use.
+// A failed initialization is retried on the next call.
+func getPDFiumPool() (pdfium.Pool, error) {
+	pdfiumMu.RLock()
+	p := pdfiumPool
+	pdfiumMu.RUnlock()
+	if p != nil {
+		return p, nil
 	}
 
-	instance, err = pool.GetInstance(time.Second * 30)
-	if err != nil {
-		panic(fmt.Sprintf("failed to get PDFium instance: %v", err))
+	pdfiumMu.Lock()
+	defer pdfiumMu.Unlock()
+	if pdfiumPool == nil {
+		p, err := webassembly.Init(pdfiumConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize PDFium: %w", err)
+		}
+		pdfiumPool = p
+	}
+	return pdfiumPool, nil
+}
+
+// replacePDFiumPool swaps the current pool and closes the previous one
+func replacePDFiumPool(p pdfium.Pool) error {
+	pdfiumMu.Lock()
+	old := pdfiumPool
+	pdfiumPool = p
+	pdfiumMu.Unlock()
+
+	if old == nil {
+		return nil
+	}
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("failed to close PDFium pool: %w", err)
+	}
+	return nil
+}
+
+// ConfigurePDFium sets the configuration of the WebAssembly PDFium pool. The
+// current pool, if any, is closed and a new one is initialized on first use.
+// It must not be called while PDF files are being processed.
+func ConfigurePDFium(config webassembly.Config) error {
+	pdfiumMu.Lock()
+	pdfiumConfig = config
+	pdfiumMu.Unlock()
+
+	return replacePDFiumPool(nil)
+}
+
+// SetPDFiumPool makes the package use the given pool to process PDF files,
+// e.g. a native build of PDFium. The package takes ownership of the pool,
+// which is closed by Close. It must not be called while PDF files are being
+// processed.
+func SetPDFiumPool(p pdfium.Pool) error {
+	if p == nil {
+		return fmt.Errorf("invalid nil PDFium pool")
 	}
+	return replacePDFiumPool(p)
 }
 
-func getBookInfoPDF(path string) (BookInfo, error) {
+// SetPDFiumPoolSize changes the maximum number of PDFium instances, and thus
+// the number of PDF files that can be processed concurrently. Each instance
+// runs its own WebAssembly runtime, so memory usage grows with the pool size.
+// It must not be called while PDF files are being processed.
+func SetPDFiumPoolSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("invalid PDFium pool size %d", size)
+	}
+
+	pdfiumMu.RLock()
+	config := pdfiumConfig
+	pdfiumMu.RUnlock()
+
+	config.MaxIdle = size
+	config.MaxTotal = size
+	return ConfigurePDFium(config)
+}
+
+// Close releases the PDFium pool. Processing another PDF file initializes
+// a new one.
+func Close() error {
+	return replacePDFiumPool(nil)
+}
+
+// pdfDocument is a PDF file opened in a PDFium instance of the pool
+type pdfDocument struct {
+	instance pdfium.Pdfium
+	handle   references.FPDF_DOCUMENT
+}
+
+// openPDF loads a PDF file in a PDFium instance, the document must be released
+// with Close. PDFium calls cannot be interrupted (Kill leaks the instance in
+// the WebAssembly pool), so ctx is only checked between calls by the callers.
+func openPDF(ctx context.Context, path string) (*pdfDocument, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Load the PDF file into a byte array
 	pdfBytes, err := os.ReadFile(path)
 	if err != nil {
-		return BookInfo{}, fmt.Errorf("failed to read PDF file: %w", err)
+		return nil, fmt.Errorf("failed to read PDF file: %w", err)
+	}
+
+	pool, err := getPDFiumPool()
+	if err != nil {
+		return nil, err
+	}
+	getCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+	instance, err := pool.GetInstanceWithContext(getCtx)
+	cancel()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("failed to get PDFium instance: %w", err)
 	}
 
 	// Open the PDF using PDFium
@@ -48,29 +158,186 @@ func getBookInfoPDF(path string) (BookInfo, error) {
 		File: &pdfBytes,
 	})
 	if err != nil {
-		return BookInfo{}, fmt.Errorf("failed to open PDF document: %w", err)
+		instance.Close()
+		return nil, fmt.Errorf("failed to open PDF document: %w", err)
 	}
+	return &pdfDocument{instance: instance, handle: doc.Document}, nil
+}
 
-	// Always close the document to release resources
-	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
-		Document: doc.Document,
+// Close releases the document and gives the instance back to the pool
+func (d *pdfDocument) Close() {
+	d.instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
+		Document: d.handle,
 	})
+	d.instance.Close()
+}
 
-	// Get page count
-	pageCount, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
-		Document: doc.Document,
+// pageCount returns the number of pages of the document
+func (d *pdfDocument) pageCount() (int, error) {
+	pageCount, err := d.instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
+		Document: d.handle,
 	})
 	if err != nil {
-		return BookInfo{}, fmt.Errorf("failed to get page count: %w", err)
+		return 0, fmt.Errorf("failed to get page count: %w", err)
+	}
+	return pageCount.PageCount, nil
+}
+
+// page returns the reference of a page of the document
+func (d *pdfDocument) page(index int) requests.Page {
+	return requests.Page{
+		ByIndex: &requests.PageByIndex{
+			Document: d.handle,
+			Index:    index,
+		},
+	}
+}
+
+// renderPage renders a page of the document, opts must have its defaults set.
+// The image is a copy that stays valid after the next PDFium call.
+func (d *pdfDocument) renderPage(index int, opts RenderOptions) (image.Image, error) {
+	page := d.page(index)
+
+	inPixels := opts.Width > 0 || opts.Height > 0
+	if !inPixels {
+		size, err := d.instance.GetPageSizeInPixels(&requests.GetPageSizeInPixels{Page: page, DPI: opts.DPI})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get size of page %d: %w", index+1, err)
+		}
+		inPixels = (opts.MaxWidth > 0 && size.Width > opts.MaxWidth) || (opts.MaxHeight > 0 && size.Height > opts.MaxHeight)
+	}
+
+	var img image.Image
+	if inPixels {
+		// PDFium fits the page in the given size, keeping its aspect ratio
+		width, height := opts.boxSize()
+		render, err := d.instance.RenderPageInPixels(&requests.RenderPageInPixels{
+			Page:   page,
+			Width:  width,
+			Height: height,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to render page %d: %w", index+1, err)
+		}
+		img = opts.copyImage(render.Result.Image)
+		render.Cleanup()
+	} else {
+		render, err := d.instance.RenderPageInDPI(&requests.RenderPageInDPI{
+			Page: page,
+			DPI:  opts.DPI,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to render page %d: %w", index+1, err)
+		}
+		img = opts.copyImage(render.Result.Image)
+		render.Cleanup()
+	}
+	return img, nil
+}
+
+// embeddedImage returns the JPEG image of a page made of a single image
+// covering the whole page, as stored in the document. ok is false for any
+// other page, or when PDFium fails to tell, which has to be rendered.
+func (d *pdfDocument) embeddedImage(index int) (data []byte, config image.Config, ok bool) {
+	page := d.page(index)
+
+	rotation, err := d.instance.FPDFPage_GetRotation(&requests.FPDFPage_GetRotation{Page: page})
+	if err != nil || rotation.PageRotation != enums.FPDF_PAGE_ROTATION_NONE {
+		return nil, config, false
+	}
+	count, err := d.instance.FPDFPage_CountObjects(&requests.FPDFPage_CountObjects{Page: page})
+	if err != nil || count.Count != 1 {
+		return nil, config, false
+	}
+	obj, err := d.instance.FPDFPage_GetObject(&requests.FPDFPage_GetObject{Page: page, Index: 0})
+	if err != nil {
+		return nil, config, false
+	}
+	objType, err := d.instance.FPDFPageObj_GetType(&requests.FPDFPageObj_GetType{PageObject: obj.PageObject})
+	if err != nil || objType.Type != enums.FPDF_PAGEOBJ_IMAGE {
+		return nil, config, false
+	}
+
+	// Only JPEG images can be copied as-is, other streams need decoding
+	filters, err := d.instance.FPDFImageObj_GetImageFilterCount(&requests.FPDFImageObj_GetImageFilterCount{ImageObject: obj.PageObject})
+	if err != nil || filters.Count != 1 {
+		return nil, config, false
+	}
+	filter, err := d.instance.FPDFImageObj_GetImageFilter(&requests.FPDFImageObj_GetImageFilter{ImageObject: obj.PageObject, Index: 0})
+	if err != nil || filter.ImageFilter != "DCTDecode" {
+		return nil, config, false
+	}
+
+	// A soft mask would be lost
+	transparency, err := d.instance.FPDFPageObj_HasTransparency(&requests.FPDFPageObj_HasTransparency{PageObject: obj.PageObject})
+	if err != nil || transparency.HasTransparency {
+		return nil, config, false
+	}
+
+	// The image must be drawn upright, neither rotated nor flipped, over the whole page
+	matrix, err := d.instance.FPDFPageObj_GetMatrix(&requests.FPDFPageObj_GetMatrix{PageObject: obj.PageObject})
+	if err != nil || matrix.Matrix.B != 0 || matrix.Matrix.C != 0 || matrix.Matrix.A <= 0 || matrix.Matrix.D <= 0 {
+		return nil, config, false
+	}
+	bounds, err := d.instance.FPDFPageObj_GetBounds(&requests.FPDFPageObj_GetBounds{PageObject: obj.PageObject})
+	if err != nil {
+		return nil, config, false
+	}
+	width, err := d.instance.FPDF_GetPageWidthF(&requests.FPDF_GetPageWidthF{Page: page})
+	if err != nil {
+		return nil, config, false
+	}
+	height, err := d.instance.FPDF_GetPageHeightF(&requests.FPDF_GetPageHeightF{Page: page})
+	if err != nil {
+		return nil, config, false
+	}
+	if !coversPDFPage(bounds.Right-bounds.Left, width.PageWidth) || !coversPDFPage(bounds.Top-bounds.Bottom, height.PageHeight) {
+		return nil, config, false
+	}
+
+	raw, err := d.instance.FPDFImageObj_GetImageDataRaw(&requests.FPDFImageObj_GetImageDataRaw{ImageObject: obj.PageObject})
+	if err != nil {
+		return nil, config, false
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(raw.Data))
+	// CMYK images are often stored inverted in PDF files, they are rendered
+	if err != nil || format != "jpeg" || config.ColorModel == color.CMYKModel {
+		return nil, config, false
+	}
+	return raw.Data, config, true
+}
+
+// coversPDFPage checks if the size of an object matches the size of the page,
+// up to 1%
+func coversPDFPage(size, pageSize float32) bool {
+	return pageSize > 0 && size >= pageSize*0.99 && size <= pageSize*1.01
+}
+
+func getBookInfoPDF(ctx context.Context, path string) (BookInfo, error) {
+	doc, err := openPDF(ctx, path)
+	if err != nil {
+		return BookInfo{}, err
+	}
+	defer doc.Close()
+
+	return doc.bookInfo(path)
+}
+
+// bookInfo reads the metadata of the document, path is used as a fallback title
+func (d *pdfDocument) bookInfo(path string) (BookInfo, error) {
+	pageCount, err := d.pageCount()
+	if err != nil {
+		return BookInfo{}, err
 	}
 
 	title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
 	var authors []string
+	var contributors []Contributor
 	var keywords []string
 
 	// Get metadata
-	metadata, err := instance.GetMetaData(&requests.GetMetaData{
-		Document: doc.Document,
+	metadata, err := d.instance.GetMetaData(&requests.GetMetaData{
+		Document: d.handle,
 	})
 	if err == nil && metadata != nil {
 		for _, tag := range metadata.Tags {
@@ -78,6 +345,7 @@ func getBookInfoPDF(path string) (BookInfo, error) {
 			case "Author":
 				if tag.Value != "" {
 					authors = []string{tag.Value}
+					contributors = []Contributor{newContributor(tag.Value, RoleWriter)}
 				}
 			case "Title":
 				if tag.Value != "" && tag.Value != ".pdf" {
@@ -97,88 +365,152 @@ func getBookInfoPDF(path string) (BookInfo, error) {
 	}
 
 	return BookInfo{
-		Title:    title,
-		Pages:    pageCount.PageCount,
-		Authors:  authors,
-		Keywords: keywords,
+		Title:        title,
+		Pages:        pageCount,
+		Authors:      authors,
+		Contributors: contributors,
+		Keywords:     keywords,
 	}, nil
 }
 
-// extractPDF renders PDF pages as JPEG images using go-pdfium
-func extractPDF(inputFile, outputFolder string) ([]Page, error) {
-	// Load the PDF file into a byte array
-	pdfBytes, err := os.ReadFile(inputFile)
+// extractPDF renders the selected pages of a PDF file with opts.Render, or
+// copies their embedded image with opts.EmbeddedImages. The files are named
+// after the page numbers, padded to the number of pages.
+func extractPDF(ctx context.Context, inputFile, outputFolder string, opts ExtractOptions) ([]Page, error) {
+	doc, err := openPDF(ctx, inputFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read PDF file: %w", err)
+		return nil, err
 	}
+	defer doc.Close()
 
-	// Open the PDF using PDFium
-	doc, err := instance.OpenDocument(&requests.OpenDocument{
-		File: &pdfBytes,
-	})
+	pageCount, err := doc.pageCount()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open PDF document: %w", err)
+		return nil, err
 	}
-
-	// Always close the document to release resources
-	defer instance.FPDF_CloseDocument(&requests.FPDF_CloseDocument{
-		Document: doc.Document,
-	})
-
-	// Get page count
-	pageCount, err := instance.FPDF_GetPageCount(&requests.FPDF_GetPageCount{
-		Document: doc.Document,
-	})
+	first, last, err := opts.pageRange(pageCount)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get page count: %w", err)
+		return nil, err
 	}
 
 	var pages []Page
+	opts.Render = opts.Render.withDefaults()
+	for pageNum := first; pageNum < last; pageNum++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-	// Extract each page as JPEG
-	for pageNum := 0; pageNum < pageCount.PageCount; pageNum++ {
-		// Render page to image using go-pdfium
-		renderPage, err := instance.RenderPageInDPI(&requests.RenderPageInDPI{
-			Page: requests.Page{
-				ByIndex: &requests.PageByIndex{
-					Document: doc.Document,
-					Index:    pageNum,
-				},
-			},
-			DPI: 150, // Set DPI for good quality
-		})
+		data, page, err := doc.extractPage(pageNum, pageCount, opts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to render page %d: %w", pageNum+1, err)
+			return nil, err
 		}
+		if err := os.WriteFile(filepath.Join(outputFolder, page.Path), data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write page %d: %w", pageNum+1, err)
+		}
+		pages = append(pages, page)
+	}
 
-		// Get image dimensions from the rendered image
-		width := renderPage.Result.Image.Bounds().Dx()
-		height := renderPage.Result.Image.Bounds().Dy()
+	return pages, nil
+}
 
-		// Generate filename with zero-padded page number
-		filename := fmt.Sprintf("page_%02d.jpg", pageNum+1)
-		outputPath := filepath.Join(outputFolder, filename)
+// extractPage returns the image of a page of a document with pageCount pages,
+// opts.Render must have its defaults set. The page is named after its number.
+func (d *pdfDocument) extractPage(index, pageCount int, opts ExtractOptions) ([]byte, Page, error) {
+	digits := max(len(strconv.Itoa(pageCount)), 2)
 
-		// Create output file
-		file, err := os.Create(outputPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create output file %s: %w", outputPath, err)
+	if opts.EmbeddedImages {
+		if data, config, ok := d.embeddedImage(index); ok {
+			return data, Page{
+				Path:   fmt.Sprintf("page_%0*d.jpg", digits, index+1),
+				Width:  config.Width,
+				Height: config.Height,
+				Method: PageEmbedded,
+			}, nil
 		}
+	}
 
-		// Encode as JPEG
-		err = jpeg.Encode(file, renderPage.Result.Image, &jpeg.Options{Quality: 90})
-		file.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed to encode page %d as JPEG: %w", pageNum+1, err)
-		}
+	// Render page to image using go-pdfium
+	img, err := d.renderPage(index, opts.Render)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	var buf bytes.Buffer
+	if err := opts.Render.encode(&buf, img); err != nil {
+		return nil, Page{}, fmt.Errorf("failed to encode page %d: %w", index+1, err)
+	}
+	return buf.Bytes(), Page{
+		Path:   fmt.Sprintf("page_%0*d%s", digits, index+1, opts.Render.extension()),
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+		Method: PageRendered,
+	}, nil
+}
 
-		// Add to pages list with dimensions
-		pages = append(pages, Page{
-			Path:   filename,
-			Width:  width,
-			Height: height,
-		})
+// extractPagePDF returns a single page of a PDF file, see extractPDF
+func extractPagePDF(ctx context.Context, inputFile string, index int, opts ExtractOptions) (io.ReadCloser, Page, error) {
+	doc, err := openPDF(ctx, inputFile)
+	if err != nil {
+		return nil, Page{}, err
 	}
+	defer doc.Close()
 
-	return pages, nil
+	pageCount, err := doc.pageCount()
+	if err != nil {
+		return nil, Page{}, err
+	}
+	if index < 0 || index >= pageCount {
+		return nil, Page{}, pageOutOfRange(index, pageCount)
+	}
+
+	opts.Render = opts.Render.withDefaults()
+	data, page, err := doc.extractPage(index, pageCount, opts)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), page, nil
+}
+
+// getCoverPDF renders the first page of a PDF as its cover
+func getCoverPDF(ctx context.Context, inputFile string) (image.Image, error) {
+	doc, err := openPDF(ctx, inputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+
+	// Same quality as extracted pages
+	img, err := doc.renderPage(0, RenderOptions{}.withDefaults())
+	if err != nil {
+		return nil, fmt.Errorf("failed to render cover page: %w", err)
+	}
+	return img, nil
+}
+
+// pdfHandler reads PDF files with PDFium
+type pdfHandler struct{}
+
+func (pdfHandler) Name() string { return "pdf" }
+
+func (pdfHandler) Extensions() []string { return []string{"pdf"} }
+
+func (pdfHandler) Sniff(header []byte) bool { return isPDF(header) }
+
+func (pdfHandler) GetBookInfo(ctx context.Context, path string) (BookInfo, error) {
+	return getBookInfoPDF(ctx, path)
+}
+
+func (pdfHandler) Extract(ctx context.Context, path, outputFolder string, opts ExtractOptions) ([]Page, error) {
+	return extractPDF(ctx, path, outputFolder, opts)
+}
+
+func (pdfHandler) ExtractPage(ctx context.Context, path string, index int, opts ExtractOptions) (io.ReadCloser, Page, error) {
+	return extractPagePDF(ctx, path, index, opts)
+}
+
+func (pdfHandler) ConvertToCBZ(ctx context.Context, path, outputFile string, opts ConvertOptions) (int, error) {
+	return convertPDFToCBZ(ctx, path, outputFile, opts.Render.withDefaults())
+}
+
+func (pdfHandler) GetCover(ctx context.Context, path string) (CoverImage, error) {
+	img, err := getCoverPDF(ctx, path)
+	return CoverImage{Image: img}, err
 }
@@ -1,11 +1,19 @@
 package archives
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/klippa-app/go-pdfium/webassembly"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -13,7 +21,7 @@ import (
 func TestReadMetadata(t *testing.T) {
 	path := filepath.Join("..", "..", "fixtures", "testfile.pdf")
 
-	book, err := getBookInfoPDF(path)
+	book, err := getBookInfoPDF(t.Context(), path)
 	require.NoError(t, err, "should successfully read PDF metadata")
 
 	assert.Equal(t, 1, book.Pages, "should have exactly 1 page")
@@ -28,7 +36,7 @@ func TestExtractPDF(t *testing.T) {
 	outputDir := t.TempDir()
 
 	// Extract files
-	extractedFiles, err := extractPDF(inputPath, outputDir)
+	extractedFiles, err := extractPDF(t.Context(), inputPath, outputDir, ExtractOptions{})
 	require.NoError(t, err, "should successfully extract PDF")
 
 	// Verify extraction results
@@ -103,7 +111,7 @@ func TestExtractPDFErrorCases(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := extractPDF(tt.inputPath, tt.outputDir)
+			_, err := extractPDF(t.Context(), tt.inputPath, tt.outputDir, ExtractOptions{})
 			if tt.expectError {
 				assert.Error(t, err, "should return error for %s", tt.name)
 			} else {
@@ -113,6 +121,121 @@ func TestExtractPDFErrorCases(t *testing.T) {
 	}
 }
 
+func TestExtractPDFOptions(t *testing.T) {
+	inputPath := filepath.Join("..", "..", "fixtures", "testfile.pdf")
+	outputDir := t.TempDir()
+
+	opts := ExtractOptions{Render: RenderOptions{Width: 300, Format: ImageFormatPNG}, FirstPage: 1, LastPage: 5}
+	pages, err := ExtractWithOptions(inputPath, outputDir, opts)
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+	assert.Equal(t, "page_01.png", pages[0].Path)
+	assert.Equal(t, 300, pages[0].Width, "page should be rendered to the requested width")
+
+	file, err := os.Open(filepath.Join(outputDir, pages[0].Path))
+	require.NoError(t, err)
+	defer file.Close()
+	config, format, err := image.DecodeConfig(file)
+	require.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, pages[0].Height, config.Height)
+
+	pages, err = ExtractWithOptions(inputPath, t.TempDir(), ExtractOptions{Render: RenderOptions{Width: 300, MaxWidth: 200}})
+	require.NoError(t, err)
+	assert.Equal(t, 200, pages[0].Width, "the maximum width should win")
+
+	_, err = ExtractWithOptions(inputPath, t.TempDir(), ExtractOptions{FirstPage: 2})
+	assert.ErrorContains(t, err, "out of range")
+	_, err = ExtractWithOptions(inputPath, t.TempDir(), ExtractOptions{Render: RenderOptions{DPI: 72, Width: 300}})
+	assert.ErrorContains(t, err, "cannot be combined")
+}
+
+// createTestImagePDF writes a PDF file of two pages the size of a JPEG image:
+// the first one shows the image over the whole page, the second one at half
+// its size
+func createTestImagePDF(t *testing.T, path string, jpg []byte, width, height int) {
+	t.Helper()
+
+	full := fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", width, height)
+	half := fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", width/2, height/2)
+	page := "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /XObject << /Im0 4 0 R >> >> /Contents %d 0 R >>"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 5 0 R] /Count 2 >>",
+		fmt.Sprintf(page, width, height, 6),
+		fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n%s\nendstream",
+			width, height, len(jpg), jpg),
+		fmt.Sprintf(page, width, height, 7),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(full), full),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(half), half),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+}
+
+func TestExtractPDFEmbeddedImages(t *testing.T) {
+	var jpg bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 120, 160)), nil))
+	path := filepath.Join(t.TempDir(), "scan.pdf")
+	createTestImagePDF(t, path, jpg.Bytes(), 120, 160)
+
+	outputDir := t.TempDir()
+	pages, err := ExtractWithOptions(path, outputDir, ExtractOptions{EmbeddedImages: true, Render: RenderOptions{Format: ImageFormatPNG}})
+	require.NoError(t, err)
+	assert.Equal(t, []Page{
+		{Path: "page_01.jpg", Width: 120, Height: 160, Method: PageEmbedded},
+		{Path: "page_02.png", Width: 251, Height: 334, Method: PageRendered},
+	}, pages, "only the page made of a single image should be copied")
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "page_01.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, jpg.Bytes(), data, "the image should be copied unchanged")
+
+	pages, err = ExtractWithOptions(path, t.TempDir(), ExtractOptions{LastPage: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []Page{{Path: "page_01.jpg", Width: 251, Height: 334, Method: PageRendered}}, pages,
+		"pages should be rendered by default")
+}
+
+func TestExtractPagePDF(t *testing.T) {
+	var jpg bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 120, 160)), nil))
+	path := filepath.Join(t.TempDir(), "scan.pdf")
+	createTestImagePDF(t, path, jpg.Bytes(), 120, 160)
+
+	rc, page, err := ExtractPageWithOptions(path, 0, ExtractOptions{EmbeddedImages: true})
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, jpg.Bytes(), data)
+	assert.Equal(t, Page{Path: "page_01.jpg", Width: 120, Height: 160, Method: PageEmbedded}, page)
+
+	rc, page, err = ExtractPageWithOptions(path, 1, ExtractOptions{Render: RenderOptions{Format: ImageFormatPNG, Height: 80}})
+	require.NoError(t, err)
+	defer rc.Close()
+	img, format, err := image.Decode(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, Page{Path: "page_02.png", Width: img.Bounds().Dx(), Height: 80, Method: PageRendered}, page)
+
+	_, _, err = ExtractPage(path, 2)
+	assert.ErrorContains(t, err, "page 3 is out of range, the book has 2 pages")
+}
+
 func TestExtractPDFWithUnidocLicense(t *testing.T) {
 	// This test verifies that the PDF extraction works even without a license
 	// (unidoc/unipdf has trial functionality)
@@ -120,7 +243,7 @@ func TestExtractPDFWithUnidocLicense(t *testing.T) {
 	outputDir := t.TempDir()
 
 	// Extract files
-	extractedFiles, err := extractPDF(inputPath, outputDir)
+	extractedFiles, err := extractPDF(t.Context(), inputPath, outputDir, ExtractOptions{})
 	require.NoError(t, err, "should successfully extract PDF with unidoc/unipdf")
 
 	// Should successfully extract at least one file
@@ -140,3 +263,86 @@ func TestExtractPDFWithUnidocLicense(t *testing.T) {
 
 	t.Logf("Successfully extracted %d files with unidoc/unipdf", len(extractedFiles))
 }
+
+func TestGetBookInfoPDFConcurrent(t *testing.T) {
+	require.NoError(t, SetPDFiumPoolSize(4))
+	t.Cleanup(func() { SetPDFiumPoolSize(1) })
+
+	path := filepath.Join("..", "..", "fixtures", "testfile.pdf")
+	errs := make(chan error, 8)
+	for range 8 {
+		go func() {
+			book, err := getBookInfoPDF(t.Context(), path)
+			if err == nil && book.Title != "Title of the Book" {
+				err = fmt.Errorf("unexpected title %q", book.Title)
+			}
+			errs <- err
+		}()
+	}
+
+	for range 8 {
+		assert.NoError(t, <-errs, "concurrent reads should succeed")
+	}
+}
+
+func TestSetPDFiumPoolSizeInvalid(t *testing.T) {
+	assert.Error(t, SetPDFiumPoolSize(0))
+}
+
+func TestPDFiumLazyInit(t *testing.T) {
+	path := filepath.Join("..", "..", "fixtures", "testfile.pdf")
+
+	require.NoError(t, Close())
+	assert.Nil(t, pdfiumPool, "the pool should be released")
+	assert.NoError(t, Close(), "closing twice should not fail")
+
+	_, err := getBookInfoPDF(t.Context(), path)
+	require.NoError(t, err, "should initialize a new pool")
+	assert.NotNil(t, pdfiumPool)
+}
+
+func TestConfigurePDFiumError(t *testing.T) {
+	t.Cleanup(func() { require.NoError(t, ConfigurePDFium(webassembly.Config{MinIdle: 1, MaxIdle: 1, MaxTotal: 1})) })
+
+	require.NoError(t, ConfigurePDFium(webassembly.Config{MaxIdle: 1, MaxTotal: 1, WASM: []byte("not WebAssembly")}))
+
+	path := filepath.Join("..", "..", "fixtures", "testfile.pdf")
+	_, err := GetBookInfo(path)
+	assert.ErrorContains(t, err, "failed to initialize PDFium", "should return an error instead of panicking")
+	_, err = Extract(path, t.TempDir())
+	assert.ErrorContains(t, err, "failed to initialize PDFium")
+
+	// Other formats do not need PDFium
+	_, err = GetBookInfo(filepath.Join("..", "..", "fixtures", "pg11-images-3.epub"))
+	assert.NoError(t, err)
+}
+
+func TestSetPDFiumPool(t *testing.T) {
+	assert.Error(t, SetPDFiumPool(nil))
+
+	p, err := webassembly.Init(webassembly.Config{MinIdle: 1, MaxIdle: 2, MaxTotal: 2})
+	require.NoError(t, err)
+	require.NoError(t, SetPDFiumPool(p))
+	t.Cleanup(func() { require.NoError(t, Close()) })
+
+	book, err := getBookInfoPDF(t.Context(), filepath.Join("..", "..", "fixtures", "testfile.pdf"))
+	require.NoError(t, err, "should use the injected pool")
+	assert.Equal(t, "Title of the Book", book.Title)
+	assert.Same(t, p, pdfiumPool)
+}
+
+func TestExtractPDFTimeout(t *testing.T) {
+	path := filepath.Join("..", "..", "fixtures", "testfile.pdf")
+
+	ctx, cancel := context.WithDeadline(t.Context(), time.Now())
+	defer cancel()
+	_, err := extractPDF(ctx, path, t.TempDir(), ExtractOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = getCoverPDF(ctx, path)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// No instance is leaked
+	book, err := getBookInfoPDF(t.Context(), path)
+	require.NoError(t, err, "the pool should still be usable")
+	assert.Equal(t, "Title of the Book", book.Title)
+}
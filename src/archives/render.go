@@ -0,0 +1,126 @@
+package archives
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Image formats of the rendered pages
+const (
+	ImageFormatJPEG = "jpeg"
+	ImageFormatPNG  = "png"
+)
+
+// Default rendering of the pages of PDF files
+const (
+	DefaultDPI         = 150
+	DefaultJPEGQuality = 90
+)
+
+// RenderOptions control how the pages of PDF files are rendered, the zero
+// value renders JPEG images at DefaultDPI
+type RenderOptions struct {
+	// DPI is the resolution of the pages, DefaultDPI when zero
+	DPI int
+
+	// Width and Height render the pages to this size instead of a resolution,
+	// keeping their aspect ratio: with both set, the pages fit in the box.
+	// They cannot be combined with DPI.
+	Width  int
+	Height int
+
+	// MaxWidth and MaxHeight scale down the pages larger than them, keeping
+	// their aspect ratio. Zero means no limit.
+	MaxWidth  int
+	MaxHeight int
+
+	// Format of the images, ImageFormatJPEG (default) or ImageFormatPNG
+	Format string
+
+	// Quality of the JPEG images, from 1 to 100, DefaultJPEGQuality when zero
+	Quality int
+
+	// Grayscale renders the pages in shades of gray
+	Grayscale bool
+}
+
+// withDefaults returns the options with the zero values replaced by defaults
+func (o RenderOptions) withDefaults() RenderOptions {
+	if o.DPI == 0 && o.Width == 0 && o.Height == 0 {
+		o.DPI = DefaultDPI
+	}
+	if o.Format == "" {
+		o.Format = ImageFormatJPEG
+	}
+	if o.Quality == 0 {
+		o.Quality = DefaultJPEGQuality
+	}
+	return o
+}
+
+// Validate checks the values of the options
+func (o RenderOptions) Validate() error {
+	switch {
+	case o.DPI < 0:
+		return fmt.Errorf("invalid DPI %d", o.DPI)
+	case o.Width < 0 || o.Height < 0:
+		return fmt.Errorf("invalid size %dx%d", o.Width, o.Height)
+	case o.DPI > 0 && (o.Width > 0 || o.Height > 0):
+		return fmt.Errorf("DPI and size cannot be combined")
+	case o.MaxWidth < 0 || o.MaxHeight < 0:
+		return fmt.Errorf("invalid maximum size %dx%d", o.MaxWidth, o.MaxHeight)
+	case o.Quality < 0 || o.Quality > 100:
+		return fmt.Errorf("invalid JPEG quality %d, expected 1 to 100", o.Quality)
+	case o.Format != "" && o.Format != ImageFormatJPEG && o.Format != ImageFormatPNG:
+		return fmt.Errorf("unknown image format %q", o.Format)
+	}
+	return nil
+}
+
+// boxSize returns the box the pages are rendered in: the requested size,
+// reduced to the maximum size
+func (o RenderOptions) boxSize() (width, height int) {
+	return minSize(o.Width, o.MaxWidth), minSize(o.Height, o.MaxHeight)
+}
+
+// minSize returns the smallest of two sizes, zero being no size
+func minSize(a, b int) int {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// extension returns the file extension of the rendered images
+func (o RenderOptions) extension() string {
+	if o.Format == ImageFormatPNG {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// copyImage copies a rendered image, which is only valid until the next
+// PDFium call, converting it to grayscale when asked to
+func (o RenderOptions) copyImage(src image.Image) image.Image {
+	bounds := src.Bounds()
+	var dst draw.Image
+	if o.Grayscale {
+		dst = image.NewGray(bounds)
+	} else {
+		dst = image.NewRGBA(bounds)
+	}
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+	return dst
+}
+
+// encode writes a rendered image in the format of the options
+func (o RenderOptions) encode(w io.Writer, img image.Image) error {
+	if o.Format == ImageFormatPNG {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: o.Quality})
+}
@@ -0,0 +1,191 @@
+package archives
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Magic numbers of the containers used by the book formats
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	rar4Magic     = []byte("Rar!\x1a\x07\x00")
+	rar5Magic     = []byte("Rar!\x1a\x07\x01\x00")
+	sevenZipMagic = []byte("7z\xbc\xaf\x27\x1c")
+	pdfMagic      = []byte("%PDF-")
+	utf8BOM       = []byte("\xef\xbb\xbf")
+)
+
+const (
+	// zipLocalHeader is the size of the fixed part of a ZIP local file header
+	zipLocalHeader = 30
+	// zipDataDescriptor is the flag of the entries whose sizes follow their data
+	zipDataDescriptor = 0x8
+	// pdfGarbageWindow is the number of leading bytes searched for the PDF
+	// header when the file does not start with it
+	pdfGarbageWindow = 128
+)
+
+func isZIP(header []byte) bool {
+	return bytes.HasPrefix(header, zipMagic) || bytes.HasPrefix(header, zipEmptyMagic)
+}
+
+func isRAR(header []byte) bool {
+	return bytes.HasPrefix(header, rar4Magic) || bytes.HasPrefix(header, rar5Magic)
+}
+
+func is7z(header []byte) bool {
+	return bytes.HasPrefix(header, sevenZipMagic)
+}
+
+// isTar checks the "ustar" magic of POSIX and GNU archives, older v7
+// archives have no magic and are not recognized
+func isTar(header []byte) bool {
+	return len(header) >= 262 && string(header[257:262]) == "ustar"
+}
+
+// isPDF checks for the PDF header, which readers accept after some garbage.
+// The header is only searched after the garbage of files that are not
+// archives, whose entries may be PDF files or contain the header.
+func isPDF(header []byte) bool {
+	if bytes.HasPrefix(header, pdfMagic) {
+		return true
+	}
+	if isZIP(header) || isRAR(header) || is7z(header) || isTar(header) {
+		return false
+	}
+	return bytes.Contains(header[:min(len(header), pdfGarbageWindow)], pdfMagic)
+}
+
+// isMOBI checks the type and creator of the Palm database
+func isMOBI(header []byte) bool {
+	if len(header) < 68 {
+		return false
+	}
+	kind := string(header[60:68])
+	return kind == "BOOKMOBI" || kind == "TEXtREAd"
+}
+
+// isFB2 checks for the root element of a FictionBook in the XML document
+func isFB2(header []byte) bool {
+	header = bytes.TrimPrefix(header, utf8BOM)
+	return bytes.HasPrefix(bytes.TrimSpace(header), []byte("<")) && bytes.Contains(header, []byte("<FictionBook"))
+}
+
+// zipFirstEntry returns the name of the first file of a ZIP archive, skipping
+// the empty folder entries, and the beginning of its (possibly compressed) data
+func zipFirstEntry(header []byte) (string, []byte, bool) {
+	for bytes.HasPrefix(header, zipMagic) && len(header) >= zipLocalHeader {
+		// The sizes are only known from the header without a data descriptor
+		sized := binary.LittleEndian.Uint16(header[6:8])&zipDataDescriptor == 0
+		compressedSize := binary.LittleEndian.Uint32(header[18:22])
+		nameLength := int(binary.LittleEndian.Uint16(header[26:28]))
+		extraLength := int(binary.LittleEndian.Uint16(header[28:30]))
+		if zipLocalHeader+nameLength > len(header) {
+			return "", nil, false
+		}
+
+		name := string(header[zipLocalHeader : zipLocalHeader+nameLength])
+		data := header[min(zipLocalHeader+nameLength+extraLength, len(header)):]
+		if !strings.HasSuffix(name, "/") || !sized || compressedSize != 0 {
+			return name, data, true
+		}
+		header = data
+	}
+	return "", nil, false
+}
+
+// isEPUB checks for the "mimetype" entry that starts every EPUB container,
+// or for the META-INF folder of EPUBs missing it. The mimetype of other
+// containers, such as OpenDocument files, is rejected when it is stored
+// uncompressed as required.
+func isEPUB(header []byte) bool {
+	name, data, ok := zipFirstEntry(header)
+	switch {
+	case !ok:
+		return false
+	case name == "mimetype":
+		return !bytes.HasPrefix(data, []byte("application/")) || bytes.HasPrefix(data, []byte("application/epub+zip"))
+	default:
+		return strings.HasPrefix(name, "META-INF/")
+	}
+}
+
+// isZippedFB2 checks if the first entry of a ZIP archive is a FictionBook
+func isZippedFB2(header []byte) bool {
+	name, _, ok := zipFirstEntry(header)
+	return ok && strings.HasSuffix(strings.ToLower(name), ".fb2")
+}
+
+// Detection tells which format reads a file
+type Detection struct {
+	// Handler reads the book, nil when the format is unknown
+	Handler Handler
+
+	// Extension is the handler of the file extension, nil when the extension
+	// is not registered
+	Extension Handler
+
+	// Mismatch is set when the content of the file belongs to another format
+	// than its extension, e.g. a .cbr file that is a ZIP archive
+	Mismatch bool
+}
+
+// Detect finds the format of a book from its first bytes, falling back to its
+// extension when the content is not recognized, or when the handler of the
+// extension is not a Sniffer. Sniffers are tried from the last registered to
+// the first, so that a format based on a container (e.g. EPUB, a ZIP archive)
+// is registered after the container. An error is returned when the file
+// cannot be read, along with the extension handler.
+func Detect(path string) (Detection, error) {
+	d := Detection{}
+	d.Extension, _ = HandlerFor(path)
+	d.Handler = d.Extension
+
+	header, err := readHeader(path)
+	if err != nil {
+		return d, err
+	}
+
+	// Trust the extension when it cannot be checked
+	if _, ok := d.Extension.(Sniffer); d.Extension != nil && !ok {
+		return d, nil
+	}
+
+	for _, s := range sniffers() {
+		if s.Sniff(header) {
+			d.Handler = s
+			d.Mismatch = d.Extension != nil && d.Extension.Name() != s.Name()
+			break
+		}
+	}
+	return d, nil
+}
+
+// detect returns the handler reading a book, read errors are left to the
+// handler of the extension
+func detect(path string) Handler {
+	d, _ := Detect(path)
+	return d.Handler
+}
+
+// readHeader reads the first SniffLength bytes of a file
+func readHeader(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, SniffLength)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read '%s': %w", path, err)
+	}
+	return header[:n], nil
+}
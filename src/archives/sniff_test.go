@@ -0,0 +1,170 @@
+package archives
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testZIP returns a ZIP archive with the given entries, stored uncompressed
+func testZIP(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: entry.Name, Method: zip.Store})
+		require.NoError(t, err)
+		_, err = f.Write(entry.Data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func testTar(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	require.NoError(t, w.WriteHeader(&tar.Header{Name: "page.png", Mode: 0644, Size: 4}))
+	_, err := w.Write([]byte("page"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestSniff(t *testing.T) {
+	pdf, err := os.ReadFile(filepath.Join("..", "..", "fixtures", "testfile.pdf"))
+	require.NoError(t, err)
+	epub, err := os.ReadFile(filepath.Join("..", "..", "fixtures", "pg11-images-3.epub"))
+	require.NoError(t, err)
+
+	mobiPath := filepath.Join(t.TempDir(), "book.mobi")
+	createTestMOBI(t, mobiPath, "Title", mobiEncodingUTF8, nil, nil)
+	mobi, err := os.ReadFile(mobiPath)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"ZIP", testZIP(t, zipEntry{"page.png", []byte("page")}), "cbz"},
+		{"empty ZIP", testZIP(t), "cbz"},
+		{"RAR4", []byte("Rar!\x1a\x07\x00\xcf\x90\x73"), "cbr"},
+		{"RAR5", []byte("Rar!\x1a\x07\x01\x00\x33\x92"), "cbr"},
+		{"7z", []byte("7z\xbc\xaf\x27\x1c\x00\x04"), "cb7"},
+		{"tar", testTar(t), "cbt"},
+		{"PDF", pdf, "pdf"},
+		{"PDF after garbage", append([]byte("\r\n"), pdf...), "pdf"},
+		{"PDF after long garbage", append(bytes.Repeat([]byte(" "), pdfGarbageWindow), pdf...), ""},
+		{"ZIP of PDF", testZIP(t, zipEntry{"book.pdf", pdf}), "cbz"},
+		{"ZIP with PDF header", testZIP(t, zipEntry{"page.png", []byte("page")}, zipEntry{"notes.txt", []byte("%PDF-1.7")}), "cbz"},
+		{"EPUB", epub, "epub"},
+		{"EPUB without mimetype", testZIP(t, zipEntry{"META-INF/container.xml", nil}), "epub"},
+		{"OpenDocument", testZIP(t, zipEntry{"mimetype", []byte("application/vnd.oasis.opendocument.text")}), "cbz"},
+		{"MOBI", mobi, "mobi"},
+		{"FB2", []byte(testFB2(nil)), "fb2"},
+		{"FB2 with BOM", append([]byte("\xef\xbb\xbf"), testFB2(nil)...), "fb2"},
+		{"zipped FB2", testZIP(t, zipEntry{"book/", nil}, zipEntry{"book/book.fb2", []byte(testFB2(nil))}), "fb2"},
+		{"XML", []byte(`<?xml version="1.0"?><html></html>`), ""},
+		{"text", []byte("not a book"), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header[:min(len(tt.header), SniffLength)]
+			var got string
+			for _, s := range sniffers() {
+				if s.Sniff(header) {
+					got = s.Name()
+					break
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	dir := t.TempDir()
+	cbz, err := os.ReadFile(filepath.Join("..", "..", "fixtures", "dummy_book.cbz"))
+	require.NoError(t, err)
+	pdf, err := os.ReadFile(filepath.Join("..", "..", "fixtures", "testfile.pdf"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		file      string
+		data      []byte
+		handler   string
+		extension string
+		mismatch  bool
+	}{
+		{"matching extension", "book.cbz", cbz, "cbz", "cbz", false},
+		{"ZIP named CBR", "book.cbr", cbz, "cbz", "cbr", true},
+		{"PDF named CBZ", "book.cbz", pdf, "pdf", "cbz", true},
+		{"no extension", "book", pdf, "pdf", "", false},
+		{"unknown content", "book.cbz", []byte("garbage"), "cbz", "cbz", false},
+		{"unknown file", "notes.txt", []byte("garbage"), "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			require.NoError(t, os.WriteFile(path, tt.data, 0644))
+
+			d, err := Detect(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.mismatch, d.Mismatch)
+			if tt.handler == "" {
+				assert.Nil(t, d.Handler)
+			} else {
+				require.NotNil(t, d.Handler)
+				assert.Equal(t, tt.handler, d.Handler.Name())
+			}
+			if tt.extension == "" {
+				assert.Nil(t, d.Extension)
+			} else {
+				require.NotNil(t, d.Extension)
+				assert.Equal(t, tt.extension, d.Extension.Name())
+			}
+		})
+	}
+
+	d, err := Detect(filepath.Join(dir, "missing.pdf"))
+	assert.Error(t, err)
+	assert.Equal(t, "pdf", d.Handler.Name(), "should fall back to the extension")
+}
+
+func TestDetectDispatch(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("..", "..", "fixtures", "testfile.pdf"))
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "book.cbz")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	info, err := GetBookInfo(path)
+	require.NoError(t, err, "a PDF named .cbz should be read as a PDF")
+	assert.Equal(t, "Title of the Book", info.Title)
+
+	path = filepath.Join(dir, "upload")
+	require.NoError(t, os.WriteFile(path, []byte(testFB2(nil)), 0644))
+	info, err = GetBookInfo(path)
+	require.NoError(t, err, "a FictionBook without extension should be read")
+	assert.Equal(t, expectedFB2.Title, info.Title)
+
+	path = filepath.Join(dir, "book.fb2")
+	require.NoError(t, os.WriteFile(path, testZIP(t, zipEntry{"book.fb2", []byte(testFB2(nil))}), 0644))
+	info, err = GetBookInfo(path)
+	require.NoError(t, err, "a zipped FictionBook named .fb2 should be read")
+	assert.Equal(t, expectedFB2.Title, info.Title)
+}
@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+)
+
+// cacheVersion is bumped whenever the cached entries can no longer be trusted,
+// e.g. when BookInfo gains new fields. Caches of another version are discarded.
+const cacheVersion = 7
+
+// cacheFileName is the name of the scan cache in the state folder
+const cacheFileName = "scan-cache.json"
+
+// cacheEntry is what the cache remembers about a book file
+type cacheEntry struct {
+	Size          int64             `json:"size"`
+	ModTime       int64             `json:"mtime"`
+	Inode         uint64            `json:"inode,omitempty"`
+	Hash          string            `json:"hash,omitempty"`
+	HashAlgorithm string            `json:"hash_algorithm,omitempty"`
+	Format        string            `json:"format,omitempty"`
+	Warnings      []string          `json:"warnings,omitempty"`
+	Book          archives.BookInfo `json:"book"`
+}
+
+// matches checks if the file is unchanged since the entry was recorded and
+// the entry holds a hash computed with the requested algorithm
+func (e cacheEntry) matches(info fs.FileInfo, hashAlgorithm string) bool {
+	key := newCacheEntry(info)
+	return e.Size == key.Size &&
+		e.ModTime == key.ModTime &&
+		e.Inode == key.Inode &&
+		e.HashAlgorithm == hashAlgorithm
+}
+
+// newCacheEntry returns an entry holding the identity of a file
+func newCacheEntry(info fs.FileInfo) cacheEntry {
+	return cacheEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Inode:   inode(info),
+	}
+}
+
+// scanCache remembers the books read by previous scans, keyed by absolute path.
+// Lookups are safe for concurrent use.
+type scanCache struct {
+	mu      sync.RWMutex
+	path    string
+	Version int                   `json:"version"`
+	Files   map[string]cacheEntry `json:"files"`
+}
+
+// loadCache reads the cache file at path. A missing file gives an empty cache.
+func loadCache(path string) (*scanCache, error) {
+	cache := &scanCache{path: path, Version: cacheVersion, Files: map[string]cacheEntry{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scan cache: %w", err)
+	}
+
+	var stored scanCache
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse scan cache %s: %w", path, err)
+	}
+	if stored.Version == cacheVersion && stored.Files != nil {
+		cache.Files = stored.Files
+	}
+	return cache, nil
+}
+
+func (c *scanCache) get(path string) (cacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.Files[path]
+	return entry, ok
+}
+
+func (c *scanCache) set(path string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Files[path] = entry
+}
+
+func (c *scanCache) delete(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.Files, path)
+}
+
+// under returns the cached paths inside the root folder
+func (c *scanCache) under(root string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+	var paths []string
+	for path := range c.Files {
+		if path == root || strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// save writes the cache atomically, so that an interrupted scan never
+// leaves a truncated cache behind
+func (c *scanCache) save() error {
+	c.mu.RLock()
+	data, err := json.Marshal(c)
+	c.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode scan cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state folder: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), cacheFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write scan cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write scan cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write scan cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to write scan cache: %w", err)
+	}
+	return nil
+}
+
+// DefaultStateDir returns the folder where bookkeeper keeps its state between
+// runs: $XDG_STATE_HOME/bookkeeper, or ~/.local/state/bookkeeper
+func DefaultStateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "bookkeeper"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the state folder: %w", err)
+	}
+	return filepath.Join(home, ".local", "state", "bookkeeper"), nil
+}
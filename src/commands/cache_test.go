@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCacheMissing(t *testing.T) {
+	cache, err := loadCache(filepath.Join(t.TempDir(), cacheFileName))
+	require.NoError(t, err, "a missing cache should not be an error")
+	assert.Empty(t, cache.Files)
+}
+
+func TestLoadCacheErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), cacheFileName)
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":`), 0644))
+
+	_, err := loadCache(path)
+	assert.Error(t, err, "should reject a corrupted cache")
+}
+
+func TestLoadCacheVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), cacheFileName)
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":0,"files":{"/book.cbz":{"size":1}}}`), 0644))
+
+	cache, err := loadCache(path)
+	require.NoError(t, err)
+	assert.Empty(t, cache.Files, "should discard a cache of another version")
+}
+
+func TestScanCacheSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", cacheFileName)
+	cache, err := loadCache(path)
+	require.NoError(t, err)
+
+	entry := cacheEntry{Size: 42, ModTime: 1, Inode: 2, Hash: "abc", HashAlgorithm: HashSHA256,
+		Book: archives.BookInfo{Title: "Book", Pages: 3}}
+	cache.set("/library/book.cbz", entry)
+	require.NoError(t, cache.save(), "should create the state folder")
+
+	loaded, err := loadCache(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]cacheEntry{"/library/book.cbz": entry}, loaded.Files)
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), "*"))
+	require.NoError(t, err)
+	assert.Len(t, matches, 1, "should not leave temporary files behind")
+}
+
+func TestScanCacheUnder(t *testing.T) {
+	cache := &scanCache{Files: map[string]cacheEntry{
+		"/library/a.cbz":     {},
+		"/library/sub/b.cbz": {},
+		"/library-old/c.cbz": {},
+		"/elsewhere/d.cbz":   {},
+	}}
+	assert.ElementsMatch(t, []string{"/library/a.cbz", "/library/sub/b.cbz"}, cache.under("/library"))
+}
+
+func TestCacheEntryMatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	entry := newCacheEntry(info)
+	entry.HashAlgorithm = HashSHA256
+	assert.True(t, entry.matches(info, HashSHA256))
+	assert.False(t, entry.matches(info, HashXXH64), "should not match another hash algorithm")
+
+	require.NoError(t, os.Chtimes(path, time.Now(), info.ModTime().Add(time.Second)))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.False(t, entry.matches(info, HashSHA256), "should not match a modified file")
+}
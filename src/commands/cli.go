@@ -0,0 +1,619 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+)
+
+// Exit codes returned by Run
+const (
+	// ExitOK is returned when the command completed successfully
+	ExitOK = 0
+	// ExitFailure is returned when the command failed
+	ExitFailure = 1
+	// ExitUsage is returned when the command line is invalid
+	ExitUsage = 2
+	// ExitPartial is returned when the command completed but some books could not be processed
+	ExitPartial = 3
+)
+
+// defaultPDFiumInstances is the number of PDF files read concurrently by scan.
+// It does not follow -jobs, as each PDFium instance is a WebAssembly runtime
+// whose memory grows with the PDF files it reads.
+const defaultPDFiumInstances = 2
+
+// Env describes the process a command runs in
+type Env struct {
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Version of the binary, reported by the version command
+	Version string
+}
+
+// errPartial is returned by commands that completed with some failed books
+var errPartial = errors.New("some books could not be processed")
+
+// usageError is returned when a command is called with invalid arguments
+type usageError struct {
+	msg string
+	// reported is set when the flag package already printed the error
+	reported bool
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...any) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// command describes a bookkeeper sub-command
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(c *cli, fs *flag.FlagSet, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{
+			name:    "scan",
+			args:    "<folder>",
+			summary: "Scan a folder recursively and report metadata of every book found",
+			run:     runScan,
+		},
+		{
+			name:    "extract",
+			args:    "<book> <extractTo>",
+			summary: "Extract all pages of a book to a folder, along with a pages.json",
+			run:     runExtract,
+		},
+		{
+			name:    "extractCover",
+			args:    "<book> <extractTo>.<jpg|png>",
+			summary: "Extract the cover of a book, the image format is chosen from the output extension",
+			run:     runExtractCover,
+		},
+		{
+			name:    "page",
+			args:    "<book> <n>",
+			summary: "Write a page of a book to the standard output, pages are numbered from 1",
+			run:     runPage,
+		},
+		{
+			name:    "convert",
+			args:    "<book>",
+			summary: "Convert a book to another format, e.g. a CBR archive or a PDF file to CBZ",
+			run:     runConvert,
+		},
+		{
+			name:    "tag",
+			args:    "<book>",
+			summary: "Write the metadata of a book into the book, e.g. the ComicInfo.xml of a CBZ archive",
+			run:     runTag,
+		},
+		{
+			name:    "formats",
+			summary: "List the supported book formats and what can be done with them",
+			run:     runFormats,
+		},
+		{
+			name:    "version",
+			summary: "Print the version",
+			run:     runVersion,
+		},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// cli holds the state shared by all commands of a single invocation
+type cli struct {
+	ctx     context.Context
+	env     Env
+	config  Config
+	format  string
+	verbose bool
+	quiet   bool
+	logger  *slog.Logger
+	out     *printer
+	flags   *flag.FlagSet
+}
+
+// Run parses the command line arguments (without the program name), runs
+// the requested command and returns the process exit code
+func Run(args []string, env Env) int {
+	return RunContext(context.Background(), args, env)
+}
+
+// RunContext is like Run, the command is interrupted when ctx is done
+func RunContext(ctx context.Context, args []string, env Env) int {
+	c := &cli{
+		ctx:    ctx,
+		env:    env,
+		format: FormatJSON,
+		logger: slog.New(slog.NewTextHandler(env.Stderr, nil)),
+	}
+
+	fs := flag.NewFlagSet("bookkeeper", flag.ContinueOnError)
+	c.flags = fs
+	fs.SetOutput(env.Stderr)
+	configPath := fs.String("config", "", "read default settings from a JSON `file`")
+	showVersion := fs.Bool("version", false, "print the version and exit")
+	c.addOutputFlags(fs)
+	fs.Usage = c.usage
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	if *configPath != "" {
+		cfg, err := LoadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(env.Stderr, "bookkeeper: %v\n", err)
+			return ExitUsage
+		}
+		c.applyConfig(cfg, setFlags(fs))
+	}
+
+	if *showVersion {
+		cmd, _ := findCommand("version")
+		return c.exit(cmd.name, cmd.run(c, c.flagSet(cmd), nil))
+	}
+
+	rest := fs.Args()
+	if len(rest) == 0 {
+		c.usage()
+		return ExitUsage
+	}
+
+	name := rest[0]
+	if name == "help" {
+		return c.exit(name, c.help(rest[1:]))
+	}
+
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(env.Stderr, "bookkeeper: unknown command %q\n", name)
+		fmt.Fprintln(env.Stderr, "Run 'bookkeeper help' for the list of commands.")
+		return ExitUsage
+	}
+
+	return c.exit(name, cmd.run(c, c.flagSet(cmd), rest[1:]))
+}
+
+// exit reports the error returned by a command and converts it into an exit code
+func (c *cli) exit(name string, err error) int {
+	var usageErr usageError
+
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.As(err, &usageErr):
+		if !usageErr.reported {
+			fmt.Fprintf(c.env.Stderr, "bookkeeper %s: %v\n", name, err)
+			fmt.Fprintf(c.env.Stderr, "Run 'bookkeeper help %s' for usage.\n", name)
+		}
+		return ExitUsage
+	case errors.Is(err, errPartial):
+		c.logger.Warn(err.Error())
+		return ExitPartial
+	default:
+		c.logger.Error(err.Error(), "command", name)
+		return ExitFailure
+	}
+}
+
+// addOutputFlags registers the flags shared by every command
+func (c *cli) addOutputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.format, "format", c.format, "output `format`: json or text")
+	fs.BoolVar(&c.verbose, "v", c.verbose, "log debug messages on stderr")
+	fs.BoolVar(&c.verbose, "verbose", c.verbose, "log debug messages on stderr")
+	fs.BoolVar(&c.quiet, "q", c.quiet, "only log errors on stderr")
+	fs.BoolVar(&c.quiet, "quiet", c.quiet, "only log errors on stderr")
+}
+
+// applyConfig uses the configuration file values for the flags that were not set
+func (c *cli) applyConfig(cfg Config, set map[string]bool) {
+	c.config = cfg
+	if cfg.Format != "" && !set["format"] {
+		c.format = cfg.Format
+	}
+	if !set["v"] && !set["verbose"] {
+		c.verbose = c.verbose || cfg.Verbose
+	}
+	if !set["q"] && !set["quiet"] {
+		c.quiet = c.quiet || cfg.Quiet
+	}
+}
+
+// setFlags returns the names of the flags set on the command line
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// defaultString returns value, or fallback when value is empty
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// setup validates the shared flags and prepares the logger and printer,
+// it must be called once the command flags are parsed
+func (c *cli) setup() error {
+	if !validFormat(c.format) {
+		return usagef("unknown output format %q", c.format)
+	}
+
+	level := slog.LevelWarn
+	if c.verbose {
+		level = slog.LevelDebug
+	}
+	if c.quiet {
+		level = slog.LevelError
+	}
+	c.logger = slog.New(slog.NewTextHandler(c.env.Stderr, &slog.HandlerOptions{Level: level}))
+	c.out = newPrinter(c.env.Stdout, c.format)
+	return nil
+}
+
+// flagSet creates the flag set of a command, including the shared flags
+func (c *cli) flagSet(cmd command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(c.env.Stderr)
+	c.addOutputFlags(fs)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s\n\n%s.\n\nFlags:\n", strings.TrimSpace("bookkeeper "+cmd.name+" [flags] "+cmd.args), cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the command flags, checks the number of positional arguments
+// and prepares the shared state
+func (c *cli) parse(fs *flag.FlagSet, args []string, nArgs int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, usageError{msg: err.Error(), reported: true}
+	}
+	if fs.NArg() != nArgs {
+		return nil, usagef("expected %d argument(s), got %d", nArgs, fs.NArg())
+	}
+	if err := c.setup(); err != nil {
+		return nil, err
+	}
+	return fs.Args(), nil
+}
+
+func (c *cli) usage() {
+	out := c.env.Stderr
+	fmt.Fprintln(out, "Usage: bookkeeper [flags] <command> [command flags] [arguments]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(out, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Flags:")
+	c.flags.PrintDefaults()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Run 'bookkeeper help <command>' for more information on a command.")
+}
+
+// help prints the general usage or the usage of a single command
+func (c *cli) help(args []string) error {
+	if len(args) == 0 {
+		c.usage()
+		return nil
+	}
+
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		return usagef("unknown command %q", args[0])
+	}
+	return cmd.run(c, c.flagSet(cmd), []string{"-help"})
+}
+
+type versionResult struct {
+	Version string `json:"version"`
+}
+
+func (r versionResult) text() string {
+	return "bookkeeper " + r.Version
+}
+
+func runVersion(c *cli, fs *flag.FlagSet, args []string) error {
+	if _, err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	return c.out.print(versionResult{Version: version(c.env.Version)})
+}
+
+// version returns the given version, or the module version when the binary
+// was built without one
+func version(v string) string {
+	if v != "" && v != "dev" {
+		return v
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return strings.TrimPrefix(info.Main.Version, "v")
+	}
+	return "dev"
+}
+
+func runScan(c *cli, fs *flag.FlagSet, args []string) error {
+	hashAlgorithm := fs.String("hash", defaultString(c.config.Scan.Hash, HashSHA256),
+		"`algorithm` used to fingerprint the books: "+strings.Join(HashAlgorithms, ", "))
+	jobs := fs.Int("jobs", c.config.Scan.Jobs, "`number` of books read concurrently (default: number of CPUs)")
+	ordered := fs.Bool("ordered", c.config.Scan.Ordered, "report the books in the order they are found")
+	pdfiumInstances := fs.Int("pdfium-instances", c.config.Scan.PDFiumInstances,
+		"`number` of PDF files read concurrently, each one is a PDFium WebAssembly runtime whose memory grows with the PDF files it reads (default: 2, at most -jobs)")
+	incremental := fs.Bool("incremental", c.config.Scan.Incremental,
+		"only read the books changed since the previous scan, and report the removed ones")
+	stateDir := fs.String("state-dir", c.config.Scan.StateDir,
+		"`folder` holding the cache of incremental scans (default: $XDG_STATE_HOME/bookkeeper)")
+	skipUnchanged := fs.Bool("skip-unchanged", c.config.Scan.SkipUnchanged,
+		"do not report the unchanged books of an incremental scan")
+	timeout := fs.Duration("timeout", time.Duration(c.config.Scan.Timeout),
+		"maximum `duration` spent reading a single book, e.g. 30s (default: no limit)")
+
+	args, err := c.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if !validHash(*hashAlgorithm) {
+		return usagef("unknown hash algorithm %q", *hashAlgorithm)
+	}
+	if *jobs < 0 || *pdfiumInstances < 0 {
+		return usagef("-jobs and -pdfium-instances must be positive")
+	}
+	if *timeout < 0 {
+		return usagef("-timeout must be positive")
+	}
+
+	if *jobs == 0 {
+		*jobs = runtime.NumCPU()
+	}
+	if *pdfiumInstances == 0 {
+		*pdfiumInstances = min(defaultPDFiumInstances, *jobs)
+	}
+	if err := archives.SetPDFiumPoolSize(*pdfiumInstances); err != nil {
+		return err
+	}
+
+	var cache string
+	if *incremental {
+		if *stateDir == "" {
+			if *stateDir, err = DefaultStateDir(); err != nil {
+				return err
+			}
+		}
+		cache = filepath.Join(*stateDir, cacheFileName)
+	}
+
+	stats, err := scan(c.ctx, args[0], ScanOptions{
+		Output:        c.env.Stdout,
+		Format:        c.format,
+		Logger:        c.logger,
+		Hash:          *hashAlgorithm,
+		Jobs:          *jobs,
+		Ordered:       *ordered,
+		Cache:         cache,
+		SkipUnchanged: *skipUnchanged,
+		Timeout:       *timeout,
+	})
+	if err != nil {
+		return err
+	}
+	if stats.Failed > 0 {
+		return errPartial
+	}
+	return nil
+}
+
+// addRenderFlags registers the flags setting how the pages of PDF files are rendered
+func addRenderFlags(fs *flag.FlagSet, render *archives.RenderOptions) {
+	fs.IntVar(&render.DPI, "dpi", 0, fmt.Sprintf("`resolution` of the pages rendered from PDF files (default %d)", archives.DefaultDPI))
+	fs.IntVar(&render.Width, "width", 0, "render the pages of PDF files to this `width` instead of a resolution")
+	fs.IntVar(&render.Height, "height", 0, "render the pages of PDF files to this `height` instead of a resolution")
+	fs.IntVar(&render.MaxWidth, "max-width", 0, "scale down the pages rendered from PDF files to this `width` at most")
+	fs.IntVar(&render.MaxHeight, "max-height", 0, "scale down the pages rendered from PDF files to this `height` at most")
+	fs.StringVar(&render.Format, "image-format", archives.ImageFormatJPEG, "`format` of the pages rendered from PDF files: jpeg or png")
+	fs.IntVar(&render.Quality, "quality", archives.DefaultJPEGQuality, "`quality` of the JPEG pages rendered from PDF files, from 1 to 100")
+	fs.BoolVar(&render.Grayscale, "grayscale", false, "render the pages of PDF files in shades of gray")
+}
+
+func runExtract(c *cli, fs *flag.FlagSet, args []string) error {
+	var opts archives.ExtractOptions
+	addRenderFlags(fs, &opts.Render)
+	pages := fs.String("pages", "", "`range` of pages to extract, numbered from 1: 5, 2-10, 3- or -10")
+	fs.BoolVar(&opts.EmbeddedImages, "embedded-images", false, "copy the JPEG image of the PDF pages made of a single image instead of rendering them")
+	fs.BoolVar(&opts.SkipAdvertisements, "skip-ads", false, "leave out the pages of comic book archives marked as Advertisement in ComicInfo.xml")
+	fs.Int64Var(&opts.Limits.MaxTotalSize, "max-total-size", 0, fmt.Sprintf("stop extracting an archive past this `size` in bytes, -1 for no limit (default %d)", archives.DefaultMaxTotalSize))
+	fs.Int64Var(&opts.Limits.MaxEntrySize, "max-entry-size", 0, fmt.Sprintf("reject the files of an archive larger than this `size` in bytes, -1 for no limit (default %d)", archives.DefaultMaxEntrySize))
+	fs.IntVar(&opts.Limits.MaxEntries, "max-entries", 0, fmt.Sprintf("stop extracting an archive past this `number` of files, -1 for no limit (default %d)", archives.DefaultMaxEntries))
+	fs.Int64Var(&opts.Limits.MaxImagePixels, "max-image-pixels", 0, fmt.Sprintf("reject the images of an archive larger than this number of `pixels`, -1 for no limit (default %d)", archives.DefaultMaxImagePixels))
+	fs.Float64Var(&opts.Limits.MaxCompressionRatio, "max-compression-ratio", 0, fmt.Sprintf("stop extracting an archive past this `ratio` of the extracted size to the archive size, -1 for no limit (default %d)", archives.DefaultMaxCompressionRatio))
+
+	args, err := c.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	if opts.FirstPage, opts.LastPage, err = parsePageRange(*pages); err != nil {
+		return usagef("%v", err)
+	}
+	if err := opts.Validate(); err != nil {
+		return usagef("%v", err)
+	}
+
+	result, err := extractBook(c.ctx, args[0], args[1], opts)
+	if err != nil {
+		return err
+	}
+	return c.out.print(result)
+}
+
+func runExtractCover(c *cli, fs *flag.FlagSet, args []string) error {
+	args, err := c.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	result, err := extractCover(c.ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return c.out.print(result)
+}
+
+func runPage(c *cli, fs *flag.FlagSet, args []string) error {
+	var opts archives.ExtractOptions
+	addRenderFlags(fs, &opts.Render)
+	fs.BoolVar(&opts.EmbeddedImages, "embedded-images", false, "copy the JPEG image of a PDF page made of a single image instead of rendering it")
+
+	args, err := c.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(args[1])
+	if err != nil || number < 1 {
+		return usagef("invalid page number %q", args[1])
+	}
+	if err := opts.Validate(); err != nil {
+		return usagef("%v", err)
+	}
+
+	page, err := writePage(c.ctx, c.env.Stdout, args[0], number, opts)
+	if err != nil {
+		return err
+	}
+	c.logger.Debug("page written", "book", args[0], "page", page.Path, "width", page.Width, "height", page.Height)
+	return nil
+}
+
+func runConvert(c *cli, fs *flag.FlagSet, args []string) error {
+	to := fs.String("to", ConvertCBZ, "`format` of the converted book: "+strings.Join(ConvertFormats, ", "))
+	output := fs.String("output", "", "converted book `file` (default: the book with the extension of the format)")
+	deleteSource := fs.Bool("delete", false, "delete the book once converted and checked")
+	var render archives.RenderOptions
+	addRenderFlags(fs, &render)
+
+	args, err := c.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(ConvertFormats, *to) {
+		return usagef("unknown format %q", *to)
+	}
+	if err := render.Validate(); err != nil {
+		return usagef("%v", err)
+	}
+	if *output == "" {
+		*output = convertOutput(args[0], *to)
+	}
+
+	result, err := convertBook(c.ctx, args[0], *output, archives.ConvertOptions{Render: render}, *deleteSource)
+	if err != nil {
+		return err
+	}
+	return c.out.print(result)
+}
+
+func runTag(c *cli, fs *flag.FlagSet, args []string) error {
+	from := fs.String("from", "", "read the metadata from a JSON `file`, such as the book reported by scan, instead of the book itself")
+	var changes []tagChange
+	stringFlag := func(name, usage string, set func(info *archives.BookInfo, value string)) {
+		fs.Func(name, usage, func(value string) error {
+			changes = append(changes, func(info *archives.BookInfo) { set(info, value) })
+			return nil
+		})
+	}
+	stringFlag("title", "set the `title`", func(info *archives.BookInfo, v string) { info.Title = v })
+	stringFlag("series", "set the `series`", func(info *archives.BookInfo, v string) { info.Series = v })
+	stringFlag("number", "set the `number` of the book in its series", func(info *archives.BookInfo, v string) { info.SeriesIndex = v })
+	stringFlag("summary", "set the `summary`", func(info *archives.BookInfo, v string) { info.Description = v })
+	stringFlag("authors", "set the comma-separated `authors`", func(info *archives.BookInfo, v string) { info.Authors = splitList(v) })
+	stringFlag("publisher", "set the `publisher`", func(info *archives.BookInfo, v string) { info.Publisher = v })
+	stringFlag("date", "set the publication `date`: YYYY, YYYY-MM or YYYY-MM-DD", func(info *archives.BookInfo, v string) { info.PublishedDate = v })
+	stringFlag("isbn", "set the `ISBN`", func(info *archives.BookInfo, v string) { info.ISBN = v })
+	stringFlag("language", "set the `language` code, e.g. en", func(info *archives.BookInfo, v string) { info.Language = splitList(v) })
+	stringFlag("tags", "set the comma-separated `tags`", func(info *archives.BookInfo, v string) { info.Keywords = splitList(v) })
+
+	args, err := c.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *from == "" && len(changes) == 0 {
+		return usagef("nothing to write, set some metadata flags or -from")
+	}
+
+	result, err := tagBook(c.ctx, args[0], *from, changes)
+	if err != nil {
+		return err
+	}
+	return c.out.print(result)
+}
+
+type formatResult struct {
+	Name         string   `json:"name"`
+	Extensions   []string `json:"extensions"`
+	Capabilities []string `json:"capabilities"`
+}
+
+func (r formatResult) text() string {
+	return r.Name + "\t" + strings.Join(r.Extensions, ",") + "\t" + strings.Join(r.Capabilities, ",")
+}
+
+func runFormats(c *cli, fs *flag.FlagSet, args []string) error {
+	if _, err := c.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	for _, h := range archives.Handlers() {
+		err := c.out.print(formatResult{
+			Name:         h.Name(),
+			Extensions:   h.Extensions(),
+			Capabilities: archives.Capabilities(h).Names(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runCLI runs the command line and returns the exit code, stdout and stderr
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := Run(args, Env{Stdout: &stdout, Stderr: &stderr, Version: "1.2.3"})
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunVersion(t *testing.T) {
+	code, stdout, _ := runCLI(t, "version")
+	assert.Equal(t, ExitOK, code)
+	assert.JSONEq(t, `{"version":"1.2.3"}`, stdout)
+
+	code, stdout, _ = runCLI(t, "--version", "--format", "text")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "bookkeeper 1.2.3\n", stdout)
+}
+
+func TestRunFormats(t *testing.T) {
+	code, stdout, _ := runCLI(t, "formats")
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stdout, `{"name":"cbr","extensions":["cbr"],"capabilities":["info","extract","page","cover","convert"]}`)
+	assert.Contains(t, stdout, `{"name":"cbz","extensions":["cbz"],"capabilities":["info","extract","page","cover","write","convert"]}`)
+	assert.Contains(t, stdout, `{"name":"epub","extensions":["epub"],"capabilities":["info","extract","cover"]}`)
+
+	code, stdout, _ = runCLI(t, "--format", "text", "formats")
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stdout, "pdf\tpdf\tinfo,extract,page,cover,convert\n")
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no command", nil},
+		{"unknown command", []string{"frobnicate"}},
+		{"unknown flag", []string{"--frobnicate", "scan", "."}},
+		{"missing argument", []string{"extract", "book.cbz"}},
+		{"unknown format", []string{"scan", "--format", "xml", "."}},
+		{"help for unknown command", []string{"help", "frobnicate"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(t, tt.args...)
+			assert.Equal(t, ExitUsage, code, "should exit with usage error")
+			assert.Empty(t, stdout, "should not print on stdout")
+			assert.NotEmpty(t, stderr, "should explain the error on stderr")
+		})
+	}
+}
+
+func TestRunHelp(t *testing.T) {
+	code, _, stderr := runCLI(t, "help")
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stderr, "scan")
+	assert.Contains(t, stderr, "extract")
+
+	code, _, stderr = runCLI(t, "help", "extract")
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stderr, "Usage: bookkeeper extract [flags] <book> <extractTo>")
+
+	code, _, stderr = runCLI(t, "scan", "--help")
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stderr, "Usage: bookkeeper scan [flags] <folder>")
+}
+
+func TestRunScan(t *testing.T) {
+	code, stdout, _ := runCLI(t, "scan", filepath.Join("..", "..", "fixtures"))
+	assert.Equal(t, ExitOK, code)
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	require.NotEmpty(t, lines, "should report books")
+	for _, line := range lines {
+		var m metadata
+		require.NoError(t, json.Unmarshal([]byte(line), &m), "each line should be JSON: %s", line)
+		assert.Equal(t, "success", m.Status)
+		assert.NotEmpty(t, m.Book.Title)
+	}
+}
+
+func TestRunScanText(t *testing.T) {
+	code, stdout, _ := runCLI(t, "--format", "text", "scan", filepath.Join("..", "..", "fixtures"))
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stdout, "success\ttestfile.pdf\tTitle of the Book (1 pages)\n")
+}
+
+func TestRunScanFailures(t *testing.T) {
+	code, stdout, _ := runCLI(t, "scan", filepath.Join(t.TempDir(), "missing"))
+	assert.Equal(t, ExitPartial, code, "should report that some books failed")
+	assert.Contains(t, stdout, `"status":"failed"`)
+}
+
+func TestRunExtract(t *testing.T) {
+	outputDir := t.TempDir()
+	code, stdout, _ := runCLI(t, "extract", filepath.Join("..", "..", "fixtures", "testfile.pdf"), outputDir)
+	require.Equal(t, ExitOK, code)
+
+	var result extractResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	assert.Equal(t, "success", result.Status)
+	assert.Equal(t, 1, result.Pages)
+	assert.FileExists(t, filepath.Join(outputDir, "pages.json"))
+}
+
+func TestRunExtractOptions(t *testing.T) {
+	outputDir := t.TempDir()
+	pdf := filepath.Join("..", "..", "fixtures", "testfile.pdf")
+	code, stdout, _ := runCLI(t, "extract", "--width", "300", "--image-format", "png", "--pages", "1-", "--embedded-images", pdf, outputDir)
+	require.Equal(t, ExitOK, code)
+
+	var result extractResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	assert.Equal(t, 1, result.Pages)
+	assert.FileExists(t, filepath.Join(outputDir, "page_01.png"))
+
+	for _, args := range [][]string{
+		{"--pages", "3-2"},
+		{"--dpi", "72", "--width", "300"},
+		{"--quality", "0x"},
+	} {
+		code, _, _ = runCLI(t, append(append([]string{"extract"}, args...), pdf, t.TempDir())...)
+		assert.Equal(t, ExitUsage, code, "%v should be rejected", args)
+	}
+
+	cbz := filepath.Join("..", "..", "fixtures", "dummy_book.cbz")
+	code, _, stderr := runCLI(t, "extract", "--max-entries", "1", cbz, t.TempDir())
+	assert.Equal(t, ExitFailure, code)
+	assert.Contains(t, stderr, "entry count limit")
+}
+
+func TestRunPage(t *testing.T) {
+	pdf := filepath.Join("..", "..", "fixtures", "testfile.pdf")
+	code, stdout, stderr := runCLI(t, "page", "--image-format", "png", "--width", "100", pdf, "1")
+	require.Equal(t, ExitOK, code, stderr)
+
+	config, format, err := image.DecodeConfig(strings.NewReader(stdout))
+	require.NoError(t, err, "the page should be written to stdout")
+	assert.Equal(t, "png", format)
+	assert.Equal(t, 100, config.Width)
+
+	code, _, stderr = runCLI(t, "page", pdf, "2")
+	assert.Equal(t, ExitFailure, code)
+	assert.Contains(t, stderr, "out of range")
+
+	code, _, _ = runCLI(t, "page", pdf, "0")
+	assert.Equal(t, ExitUsage, code)
+}
+
+func TestRunExtractFailure(t *testing.T) {
+	code, stdout, stderr := runCLI(t, "extract", "book.txt", t.TempDir())
+	assert.Equal(t, ExitFailure, code)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "unsupported file format")
+}
+
+func TestRunConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"format":"text"}`), 0644))
+
+	code, stdout, _ := runCLI(t, "--config", configPath, "version")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "bookkeeper 1.2.3\n", stdout, "should use the format from the config file")
+
+	code, stdout, _ = runCLI(t, "--config", configPath, "--format", "json", "version")
+	assert.Equal(t, ExitOK, code)
+	assert.JSONEq(t, `{"version":"1.2.3"}`, stdout, "flags should take precedence over the config file")
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`{"format":`), 0644))
+	unknown := filepath.Join(dir, "unknown.json")
+	require.NoError(t, os.WriteFile(unknown, []byte(`{"fromat":"text"}`), 0644))
+	duration := filepath.Join(dir, "duration.json")
+	require.NoError(t, os.WriteFile(duration, []byte(`{"scan":{"timeout":30}}`), 0644))
+
+	for _, path := range []string{filepath.Join(dir, "missing.json"), invalid, unknown, duration} {
+		_, err := LoadConfig(path)
+		assert.Error(t, err, "should fail to load %s", path)
+	}
+}
+
+func TestRunExtractCover(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "cover.jpg")
+	code, stdout, _ := runCLI(t, "extractCover", filepath.Join("..", "..", "fixtures", "pg11-images-3.epub"), outputPath)
+	require.Equal(t, ExitOK, code)
+
+	var result coverResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	assert.Equal(t, "success", result.Status)
+	assert.Equal(t, 800, result.Width)
+	assert.FileExists(t, outputPath)
+}
+
+func TestRunScanHash(t *testing.T) {
+	code, stdout, _ := runCLI(t, "scan", "--hash", HashXXH64, filepath.Join("..", "..", "fixtures"))
+	require.Equal(t, ExitOK, code)
+
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		var m metadata
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		assert.Equal(t, HashXXH64, m.HashAlgorithm)
+
+		expected, err := hashFile(t.Context(), filepath.Join("..", "..", "fixtures", m.Path), HashXXH64)
+		require.NoError(t, err)
+		assert.Equal(t, expected, m.Hash, "hash of %s", m.Path)
+	}
+
+	code, _, _ = runCLI(t, "scan", "--hash", "md5", filepath.Join("..", "..", "fixtures"))
+	assert.Equal(t, ExitUsage, code, "should reject unknown hash algorithms")
+}
+
+func TestRunScanIncremental(t *testing.T) {
+	stateDir := t.TempDir()
+	fixtures := filepath.Join("..", "..", "fixtures")
+
+	code, _, _ := runCLI(t, "scan", "--incremental", "--state-dir", stateDir, fixtures)
+	require.Equal(t, ExitOK, code)
+	assert.FileExists(t, filepath.Join(stateDir, cacheFileName))
+
+	code, stdout, _ := runCLI(t, "--format", "text", "scan", "--incremental", "--state-dir", stateDir, fixtures)
+	require.Equal(t, ExitOK, code)
+	assert.Contains(t, stdout, "unchanged\ttestfile.pdf\tTitle of the Book (1 pages)\n")
+
+	code, stdout, _ = runCLI(t, "scan", "--incremental", "--skip-unchanged", "--state-dir", stateDir, fixtures)
+	require.Equal(t, ExitOK, code)
+	assert.Empty(t, stdout, "should not report unchanged books")
+}
+
+func TestRunScanTimeout(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"scan":{"timeout":"1ns"}}`), 0644))
+
+	code, stdout, _ := runCLI(t, "--config", configPath, "scan", filepath.Join("..", "..", "fixtures"))
+	assert.Equal(t, ExitPartial, code, "books should time out")
+	assert.Contains(t, stdout, `"error":"timed out after 1ns: context deadline exceeded"`)
+
+	code, stdout, _ = runCLI(t, "--config", configPath, "scan", "--timeout", "1m", filepath.Join("..", "..", "fixtures"))
+	assert.Equal(t, ExitOK, code, "the flag should take precedence over the config file")
+	assert.NotContains(t, stdout, "timed out")
+
+	code, _, _ = runCLI(t, "scan", "--timeout", "-1s", filepath.Join("..", "..", "fixtures"))
+	assert.Equal(t, ExitUsage, code)
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	var stdout, stderr bytes.Buffer
+	code := RunContext(ctx, []string{"scan", filepath.Join("..", "..", "fixtures")}, Env{Stdout: &stdout, Stderr: &stderr})
+	assert.Equal(t, ExitFailure, code)
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), "context canceled")
+}
+
+func TestRunTag(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "fixtures", "dummy_book.cbz"))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	code, stdout, _ := runCLI(t, "tag", "-title", "New Title", "-authors", "Jane Doe, John Doe", "-date", "2024-05", path)
+	require.Equal(t, ExitOK, code)
+
+	var result tagResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	assert.Equal(t, "success", result.Status)
+	assert.Equal(t, "New Title", result.Book.Title)
+
+	info, err := archives.GetBookInfo(path)
+	require.NoError(t, err)
+	assert.Equal(t, "New Title", info.Title)
+	assert.Equal(t, []string{"Jane Doe", "John Doe"}, info.Authors)
+	assert.Equal(t, "2024-05", info.PublishedDate)
+	assert.Equal(t, result.Book.Pages, info.Pages, "pages should be kept")
+
+	from := filepath.Join(t.TempDir(), "book.json")
+	require.NoError(t, os.WriteFile(from, []byte(`{"title":"From JSON","series":"Series","pages":3}`), 0644))
+	code, stdout, _ = runCLI(t, "--format", "text", "tag", "-from", from, "-series", "Other", path)
+	require.Equal(t, ExitOK, code)
+	assert.Equal(t, "Metadata written to "+path+": From JSON (3 pages)\n", stdout)
+
+	info, err = archives.GetBookInfo(path)
+	require.NoError(t, err)
+	require.NotNil(t, info.Comic)
+	assert.Equal(t, 10, info.Comic.Count, "the other ComicInfo.xml fields should be kept")
+	info.Comic = nil
+	assert.Equal(t, archives.BookInfo{Title: "From JSON", Series: "Other", Pages: 3}, info)
+
+	code, _, _ = runCLI(t, "tag", "-number", "12.5", path)
+	require.Equal(t, ExitOK, code)
+	info, err = archives.GetBookInfo(path)
+	require.NoError(t, err)
+	assert.Equal(t, "12.5", info.SeriesIndex, "decimal numbers should be written as-is")
+	assert.Equal(t, "From JSON", info.Title)
+
+	code, _, _ = runCLI(t, "tag", path)
+	assert.Equal(t, ExitUsage, code, "should require some metadata")
+
+	code, _, _ = runCLI(t, "tag", "-date", "someday", path)
+	assert.Equal(t, ExitFailure, code)
+
+	code, _, stderr := runCLI(t, "tag", "-title", "Title", filepath.Join("..", "..", "fixtures", "testfile.pdf"))
+	assert.Equal(t, ExitFailure, code)
+	assert.Contains(t, stderr, "not supported")
+}
+
+func TestRunConvert(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "fixtures", "dummy_book.cbz"))
+	require.NoError(t, err)
+
+	// A ZIP archive with the extension of a RAR one
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.cbr")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	code, stdout, _ := runCLI(t, "convert", "--to", "cbz", path)
+	require.Equal(t, ExitOK, code)
+
+	var result convertResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	assert.Equal(t, convertResult{Path: path, Status: "success", Output: filepath.Join(dir, "book.cbz"), Pages: 4}, result)
+	assert.FileExists(t, path, "source should be kept")
+
+	info, err := archives.GetBookInfo(result.Output)
+	require.NoError(t, err)
+	assert.Equal(t, "Test Comic Book", info.Title, "ComicInfo.xml should be carried over")
+
+	code, _, _ = runCLI(t, "convert", path)
+	assert.Equal(t, ExitFailure, code, "should not overwrite the converted book")
+
+	output := filepath.Join(dir, "other.cbz")
+	code, stdout, _ = runCLI(t, "--format", "text", "convert", "--output", output, "--delete", path)
+	require.Equal(t, ExitOK, code)
+	assert.Equal(t, fmt.Sprintf("Converted %s to %s (%d pages), source deleted\n", path, output, 4), stdout)
+	assert.NoFileExists(t, path)
+
+	code, _, _ = runCLI(t, "convert", "--to", "rar", output)
+	assert.Equal(t, ExitUsage, code)
+}
+
+func TestRunConvertPDF(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "fixtures", "testfile.pdf"))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "book.pdf")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	code, stdout, _ := runCLI(t, "convert", "--image-format", "png", "--max-width", "200", "--grayscale", path)
+	require.Equal(t, ExitOK, code)
+
+	var result convertResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	assert.Equal(t, 1, result.Pages)
+
+	info, err := archives.GetBookInfo(result.Output)
+	require.NoError(t, err)
+	assert.Equal(t, "Title of the Book", info.Title, "ComicInfo.xml should be generated from the PDF metadata")
+
+	code, _, _ = runCLI(t, "convert", "--quality", "200", "--output", filepath.Join(t.TempDir(), "other.cbz"), path)
+	assert.Equal(t, ExitUsage, code)
+}
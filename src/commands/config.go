@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config holds the settings that can be read from a configuration file.
+// Command line flags always take precedence over the configuration file.
+type Config struct {
+	// Format of the results printed on stdout, FormatJSON or FormatText
+	Format string `json:"format,omitempty"`
+
+	// Verbose enables debug logging on stderr
+	Verbose bool `json:"verbose,omitempty"`
+
+	// Quiet only logs errors on stderr
+	Quiet bool `json:"quiet,omitempty"`
+
+	// Scan holds the defaults of the scan command
+	Scan ScanConfig `json:"scan,omitempty"`
+}
+
+// ScanConfig holds the defaults of the scan command
+type ScanConfig struct {
+	// Hash is the algorithm used to fingerprint the books
+	Hash string `json:"hash,omitempty"`
+
+	// Jobs is the number of books read concurrently
+	Jobs int `json:"jobs,omitempty"`
+
+	// Ordered reports the books in the order they are found
+	Ordered bool `json:"ordered,omitempty"`
+
+	// PDFiumInstances is the number of PDF files read concurrently, 2 by default
+	PDFiumInstances int `json:"pdfium_instances,omitempty"`
+
+	// Incremental only reads the books changed since the previous scan
+	Incremental bool `json:"incremental,omitempty"`
+
+	// StateDir is the folder holding the cache of incremental scans
+	StateDir string `json:"state_dir,omitempty"`
+
+	// SkipUnchanged does not report the unchanged books of incremental scans
+	SkipUnchanged bool `json:"skip_unchanged,omitempty"`
+
+	// Timeout is the maximum duration spent reading a single book
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// Duration is a time.Duration written as a string in the configuration file, e.g. "30s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// LoadConfig reads a JSON configuration file
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	file, err := os.Open(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+)
+
+// Formats books can be converted to
+const (
+	// ConvertCBZ converts comic book archives and PDF files to ZIP
+	ConvertCBZ = "cbz"
+)
+
+// ConvertFormats lists the formats accepted by convert --to
+var ConvertFormats = []string{ConvertCBZ}
+
+// convertResult is reported once a book has been converted
+type convertResult struct {
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	Output  string `json:"output"`
+	Pages   int    `json:"pages"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+func (r convertResult) text() string {
+	line := fmt.Sprintf("Converted %s to %s (%d pages)", r.Path, r.Output, r.Pages)
+	if r.Deleted {
+		line += ", source deleted"
+	}
+	return line
+}
+
+// convertOutput returns the default output of a conversion: the input file
+// with the extension of the format
+func convertOutput(inputFile, format string) string {
+	return strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + "." + format
+}
+
+// convertBook converts a book to CBZ, then deletes the source when asked to
+func convertBook(ctx context.Context, inputFile, outputFile string, opts archives.ConvertOptions, deleteSource bool) (convertResult, error) {
+	pages, err := archives.ConvertToCBZContext(ctx, inputFile, outputFile, opts)
+	if err != nil {
+		return convertResult{}, fmt.Errorf("conversion failed: %w", err)
+	}
+
+	result := convertResult{Path: inputFile, Status: "success", Output: outputFile, Pages: pages}
+	if deleteSource {
+		if err := os.Remove(inputFile); err != nil {
+			return convertResult{}, fmt.Errorf("failed to delete source: %w", err)
+		}
+		result.Deleted = true
+	}
+	return result, nil
+}
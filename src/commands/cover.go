@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+)
+
+// coverResult is reported once the cover of a book has been extracted
+type coverResult struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Output string `json:"output"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+func (r coverResult) text() string {
+	return fmt.Sprintf("Cover extracted to %s (%dx%d)", r.Output, r.Width, r.Height)
+}
+
+// ExtractCover extracts the cover of a book to outputFile, the image format
+// is chosen from the extension of outputFile
+func ExtractCover(inputFile, outputFile string) error {
+	return ExtractCoverContext(context.Background(), inputFile, outputFile)
+}
+
+// ExtractCoverContext extracts the cover of a book to outputFile, it stops
+// and returns the context error when ctx is done
+func ExtractCoverContext(ctx context.Context, inputFile, outputFile string) error {
+	result, err := extractCover(ctx, inputFile, outputFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(result.text())
+	return nil
+}
+
+func extractCover(ctx context.Context, inputFile, outputFile string) (coverResult, error) {
+	cover, err := archives.ExtractCoverContext(ctx, inputFile, outputFile)
+	if err != nil {
+		return coverResult{}, fmt.Errorf("cover extraction failed: %w", err)
+	}
+
+	return coverResult{
+		Path:   inputFile,
+		Status: "success",
+		Output: cover.Path,
+		Width:  cover.Width,
+		Height: cover.Height,
+	}, nil
+}
@@ -2,10 +2,13 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/biblioteca/bookkeeper/src/archives"
 )
@@ -15,21 +18,80 @@ type PagesJSON struct {
 	Pages []archives.Page `json:"pages"`
 }
 
+// extractResult is reported once a book has been extracted
+type extractResult struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Output string `json:"output"`
+	Pages  int    `json:"pages"`
+}
+
+func (r extractResult) text() string {
+	return fmt.Sprintf("Extraction complete. %d files extracted to %s", r.Pages, r.Output)
+}
+
 // Extract extracts files from an archive or PDF into the output folder
 func Extract(inputFile, outputFolder string) error {
+	return ExtractContext(context.Background(), inputFile, outputFolder)
+}
+
+// ExtractContext extracts files from an archive or PDF into the output
+// folder, it stops and returns the context error when ctx is done
+func ExtractContext(ctx context.Context, inputFile, outputFolder string) error {
+	result, err := extractBook(ctx, inputFile, outputFolder, archives.ExtractOptions{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(result.text())
+	return nil
+}
+
+// extractBook extracts the pages and writes pages.json next to them
+func extractBook(ctx context.Context, inputFile, outputFolder string, opts archives.ExtractOptions) (extractResult, error) {
 	// Use the archives package to extract files
-	extractedPages, err := archives.Extract(inputFile, outputFolder)
+	extractedPages, err := archives.ExtractWithOptionsContext(ctx, inputFile, outputFolder, opts)
 	if err != nil {
-		return fmt.Errorf("extraction failed: %w", err)
+		return extractResult{}, fmt.Errorf("extraction failed: %w", err)
 	}
 
 	// Create pages.json
 	if err := createPagesJSON(extractedPages, outputFolder); err != nil {
-		return fmt.Errorf("failed to create pages.json: %w", err)
+		return extractResult{}, fmt.Errorf("failed to create pages.json: %w", err)
 	}
 
-	fmt.Printf("Extraction complete. %d files extracted to %s\n", len(extractedPages), outputFolder)
-	return nil
+	return extractResult{
+		Path:   inputFile,
+		Status: "success",
+		Output: outputFolder,
+		Pages:  len(extractedPages),
+	}, nil
+}
+
+// parsePageRange parses a range of pages such as "5", "2-10", "3-" or "-10"
+// into the first and last pages, zero when open
+func parsePageRange(value string) (first, last int, err error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	from, to, isRange := strings.Cut(value, "-")
+	if !isRange {
+		to = from
+	}
+	if from != "" {
+		if first, err = strconv.Atoi(from); err != nil || first < 1 {
+			return 0, 0, fmt.Errorf("invalid page range %q", value)
+		}
+	}
+	if to != "" {
+		if last, err = strconv.Atoi(to); err != nil || last < 1 {
+			return 0, 0, fmt.Errorf("invalid page range %q", value)
+		}
+	}
+	if (from == "" && to == "") || (last > 0 && first > last) {
+		return 0, 0, fmt.Errorf("invalid page range %q", value)
+	}
+	return first, last, nil
 }
 
 // createPagesJSON creates the pages.json file with extracted pages and their dimensions
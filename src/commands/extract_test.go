@@ -141,6 +141,31 @@ func TestExtractPDF(t *testing.T) {
 	t.Logf("Successfully extracted %d pages from PDF", len(pagesJSON.Pages))
 }
 
+func TestExtractEPUB(t *testing.T) {
+	inputPath := filepath.Join("..", "..", "fixtures", "pg76832-images.epub")
+	outputDir := t.TempDir()
+
+	require.NoError(t, Extract(inputPath, outputDir), "should successfully extract EPUB file")
+
+	pagesData, err := os.ReadFile(filepath.Join(outputDir, "pages.json"))
+	require.NoError(t, err, "pages.json should be created")
+
+	var pagesJSON PagesJSON
+	require.NoError(t, json.Unmarshal(pagesData, &pagesJSON))
+	require.NotEmpty(t, pagesJSON.Pages, "should list the chapters")
+
+	titles := 0
+	for _, page := range pagesJSON.Pages {
+		assert.FileExists(t, filepath.Join(outputDir, page.Path))
+		assert.NotEmpty(t, page.ID, "chapter %s should have its manifest ID", page.Path)
+		assert.Equal(t, "application/xhtml+xml", page.MediaType)
+		if page.Title != "" {
+			titles++
+		}
+	}
+	assert.Positive(t, titles, "chapters should have titles from the table of contents")
+}
+
 func TestExtractErrorCases(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -221,6 +246,29 @@ func TestCreatePagesJSON(t *testing.T) {
 	}
 }
 
+func TestParsePageRange(t *testing.T) {
+	tests := []struct {
+		value       string
+		first, last int
+	}{
+		{"", 0, 0},
+		{"5", 5, 5},
+		{"2-10", 2, 10},
+		{"3-", 3, 0},
+		{"-10", 0, 10},
+	}
+	for _, tt := range tests {
+		first, last, err := parsePageRange(tt.value)
+		require.NoError(t, err, "should parse %q", tt.value)
+		assert.Equal(t, [2]int{tt.first, tt.last}, [2]int{first, last}, "range %q", tt.value)
+	}
+
+	for _, value := range []string{"-", "0", "a-b", "5-2", "1-2-3", "-0"} {
+		_, _, err := parsePageRange(value)
+		assert.Error(t, err, "should reject %q", value)
+	}
+}
+
 func TestCreatePagesJSONErrorCases(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// Hash algorithms used to fingerprint the content of the books
+const (
+	// HashSHA256 is the SHA-256 digest of the whole file, the default
+	HashSHA256 = "sha256"
+	// HashXXH64 is the 64-bit xxHash of the whole file, fast but not cryptographic
+	HashXXH64 = "xxh64"
+	// HashBLAKE3 is the 256-bit BLAKE3 digest of the whole file
+	HashBLAKE3 = "blake3"
+	// HashPartial is the SHA-256 digest of the file size, its first and its
+	// last partialHashChunk bytes. It is meant for huge files where reading
+	// the whole content is too slow, at the cost of missing changes in the middle.
+	HashPartial = "partial"
+	// HashNone disables hashing
+	HashNone = "none"
+)
+
+// partialHashChunk is the number of bytes read at each end of the file by HashPartial
+const partialHashChunk = 1 << 20
+
+// HashAlgorithms lists the supported values for ScanOptions.Hash
+var HashAlgorithms = []string{HashSHA256, HashXXH64, HashBLAKE3, HashPartial, HashNone}
+
+// validHash checks if the hash algorithm is supported
+func validHash(algorithm string) bool {
+	return slices.Contains(HashAlgorithms, algorithm)
+}
+
+// newHash returns the hash function used by an algorithm
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case HashSHA256, HashPartial:
+		return sha256.New(), nil
+	case HashXXH64:
+		return xxhash.New(), nil
+	case HashBLAKE3:
+		return blake3.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm '%s'", algorithm)
+	}
+}
+
+// hashFile computes the hex encoded digest of a file, streaming its content.
+// It stops reading when ctx is done.
+func hashFile(ctx context.Context, path string, algorithm string) (string, error) {
+	if algorithm == HashNone {
+		return "", nil
+	}
+
+	h, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if algorithm == HashPartial {
+		err = hashPartial(h, contextFile{ctx: ctx, file: file})
+	} else {
+		_, err = io.Copy(h, contextReader{ctx: ctx, r: file})
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashPartial feeds the size, the head and the tail of the file to h.
+// Files smaller than two chunks are hashed entirely.
+func hashPartial(h hash.Hash, file contextFile) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	if err := binary.Write(h, binary.BigEndian, size); err != nil {
+		return err
+	}
+
+	if size <= 2*partialHashChunk {
+		_, err = io.Copy(h, file)
+		return err
+	}
+
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, partialHashChunk)); err != nil {
+		return err
+	}
+	_, err = io.Copy(h, io.NewSectionReader(file, size-partialHashChunk, partialHashChunk))
+	return err
+}
+
+// contextFile is a file whose reads stop when ctx is done
+type contextFile struct {
+	ctx  context.Context
+	file *os.File
+}
+
+func (f contextFile) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+func (f contextFile) Read(p []byte) (int, error) {
+	return contextReader{ctx: f.ctx, r: f.file}.Read(p)
+}
+
+func (f contextFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.file.ReadAt(p, off)
+}
+
+// contextReader stops reading when ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	require.NoError(t, os.WriteFile(path, []byte("hello world"), 0644))
+
+	tests := []struct {
+		algorithm string
+		expected  string
+	}{
+		{HashSHA256, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"},
+		{HashXXH64, "45ab6734b21e6968"},
+		{HashBLAKE3, "d74981efa70a0c880b8d8c1985d075dbcbf679b99a5f9914e5aaf96b831a9e24"},
+		{HashNone, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			hash, err := hashFile(t.Context(), path, tt.algorithm)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, hash)
+		})
+	}
+}
+
+func TestHashFilePartial(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 3*partialHashChunk)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	hashContent := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		hash, err := hashFile(t.Context(), path, HashPartial)
+		require.NoError(t, err)
+		return hash
+	}
+
+	original := hashContent("original.pdf", content)
+	assert.Len(t, original, 64, "should be a hex encoded SHA-256")
+
+	middle := append([]byte(nil), content...)
+	middle[len(middle)/2]++
+	assert.Equal(t, original, hashContent("middle.pdf", middle), "changes in the middle are not part of the partial hash")
+
+	tail := append([]byte(nil), content...)
+	tail[len(tail)-1]++
+	assert.NotEqual(t, original, hashContent("tail.pdf", tail), "changes in the tail should change the hash")
+
+	longer := append(append([]byte(nil), content...), 0)
+	assert.NotEqual(t, original, hashContent("longer.pdf", longer), "the size should be part of the hash")
+
+	small := hashContent("small.pdf", []byte("hello world"))
+	assert.NotEqual(t, small, hashContent("small2.pdf", []byte("hello_world")), "small files should be hashed entirely")
+}
+
+func TestHashFileErrorCases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.cbz")
+	require.NoError(t, os.WriteFile(path, []byte("hello world"), 0644))
+
+	_, err := hashFile(t.Context(), path, "md5")
+	assert.Error(t, err, "should reject unknown algorithms")
+
+	_, err = hashFile(t.Context(), filepath.Join(t.TempDir(), "missing.cbz"), HashSHA256)
+	assert.Error(t, err, "should fail on missing files")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	for _, algorithm := range []string{HashSHA256, HashPartial} {
+		_, err = hashFile(ctx, path, algorithm)
+		assert.ErrorIs(t, err, context.Canceled, "%s should stop when the context is done", algorithm)
+	}
+}
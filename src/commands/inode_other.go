@@ -0,0 +1,11 @@
+//go:build !unix
+
+package commands
+
+import "io/fs"
+
+// inode is not available on this platform, files are only identified by their
+// size and modification time
+func inode(info fs.FileInfo) uint64 {
+	return 0
+}
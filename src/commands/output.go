@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Output formats supported by the commands
+const (
+	// FormatJSON prints one JSON document per line
+	FormatJSON = "json"
+	// FormatText prints one human readable, tab separated line per result
+	FormatText = "text"
+)
+
+// texter is implemented by every result that can be printed in FormatText
+type texter interface {
+	text() string
+}
+
+// printer writes results to an output in the selected format.
+// It is safe for concurrent use.
+type printer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) *printer {
+	if format == "" {
+		format = FormatJSON
+	}
+	return &printer{w: w, format: format}
+}
+
+// print writes a single result on its own line
+func (p *printer) print(v texter) error {
+	var line string
+	switch p.format {
+	case FormatText:
+		line = v.text()
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		line = string(b)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := fmt.Fprintln(p.w, line)
+	return err
+}
+
+// validFormat checks if the output format is supported
+func validFormat(format string) bool {
+	return format == FormatJSON || format == FormatText
+}
@@ -0,0 +1,23 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+)
+
+// writePage writes a page of a book to w, number starting at 1
+func writePage(ctx context.Context, w io.Writer, inputFile string, number int, opts archives.ExtractOptions) (archives.Page, error) {
+	rc, page, err := archives.ExtractPageWithOptionsContext(ctx, inputFile, number-1, opts)
+	if err != nil {
+		return archives.Page{}, fmt.Errorf("page extraction failed: %w", err)
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(w, rc); err != nil {
+		return archives.Page{}, fmt.Errorf("failed to write page: %w", err)
+	}
+	return page, nil
+}
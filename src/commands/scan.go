@@ -1,20 +1,81 @@
 package commands
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/biblioteca/bookkeeper/src/archives"
 )
 
+// ScanOptions configures how a scan reports its results
+type ScanOptions struct {
+	// Output receives one line per book found, defaults to os.Stdout
+	Output io.Writer
+
+	// Format of each line, FormatJSON (default) or FormatText
+	Format string
+
+	// Logger receives progress messages, nothing is logged when nil
+	Logger *slog.Logger
+
+	// Hash is the algorithm used to fingerprint the books, one of
+	// HashAlgorithms. Defaults to HashSHA256.
+	Hash string
+
+	// Jobs is the number of books read concurrently, defaults to the number of CPUs
+	Jobs int
+
+	// Ordered reports the books in the order they are found while walking the
+	// folder, instead of as soon as they are read
+	Ordered bool
+
+	// Cache is the file remembering the books read by previous scans, the scan
+	// is incremental when set: books whose size, modification time and inode
+	// did not change are reported as "unchanged" without being read again, and
+	// books that disappeared since the previous scan are reported as "removed"
+	Cache string
+
+	// SkipUnchanged does not report the unchanged books of an incremental scan
+	SkipUnchanged bool
+
+	// Timeout is the maximum duration spent reading a single book, books taking
+	// longer are reported as failed. There is no limit when zero. The formats
+	// that cannot be interrupted finish being read in the background: at most
+	// Jobs books are read at once, including these.
+	Timeout time.Duration
+}
+
+// WarningFormatMismatch is reported for books whose content does not match
+// their extension, e.g. a .cbr file that is a ZIP archive
+const WarningFormatMismatch = "format_mismatch"
+
 type metadata struct {
-	Path   string            `json:"path"`
-	Status string            `json:"status"`
-	Size   int64             `json:"size,omitempty"`
-	Hash   string            `json:"hash"`
-	Book   archives.BookInfo `json:"book"`
+	Path          string            `json:"path"`
+	Status        string            `json:"status"`
+	Size          int64             `json:"size,omitempty"`
+	Hash          string            `json:"hash"`
+	HashAlgorithm string            `json:"hash_algorithm,omitempty"`
+	Format        string            `json:"format,omitempty"`
+	Warnings      []string          `json:"warnings,omitempty"`
+	Book          archives.BookInfo `json:"book"`
+}
+
+func (m metadata) text() string {
+	line := fmt.Sprintf("%s\t%s\t%s (%d pages)", m.Status, m.Path, m.Book.Title, m.Book.Pages)
+	if len(m.Warnings) > 0 {
+		line += "\t" + strings.Join(m.Warnings, ",")
+	}
+	return line
 }
 
 type errorMetadata struct {
@@ -23,66 +84,401 @@ type errorMetadata struct {
 	Error  string `json:"error"`
 }
 
+func (m errorMetadata) text() string {
+	return fmt.Sprintf("%s\t%s\t%s", m.Status, m.Path, m.Error)
+}
+
+type removedMetadata struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+func (m removedMetadata) text() string {
+	return fmt.Sprintf("%s\t%s", m.Status, m.Path)
+}
+
+// scanStats counts the books seen during a scan
+type scanStats struct {
+	Scanned   int
+	Failed    int
+	Unchanged int
+	Removed   int
+}
+
 // Scan scans the given path recursively for book files and prints their metadata as JSON lines
 func Scan(scanPath string) error {
+	return ScanWithOptions(scanPath, ScanOptions{})
+}
+
+// ScanWithOptions scans the given path recursively for book files and prints their metadata
+// to the configured output
+func ScanWithOptions(scanPath string, opts ScanOptions) error {
+	return ScanContext(context.Background(), scanPath, opts)
+}
+
+// ScanContext is like ScanWithOptions, but stops walking the folder and
+// reading the books when ctx is done. The books being read when the scan is
+// cancelled are not reported, and the context error is returned.
+func ScanContext(ctx context.Context, scanPath string, opts ScanOptions) error {
+	_, err := scan(ctx, scanPath, opts)
+	return err
+}
+
+// scanJob is a file found while walking the tree, err is set when the walk failed on it
+type scanJob struct {
+	index int
+	path  string
+	err   error
+}
+
+// scanResult is the line reported for a scanJob
+type scanResult struct {
+	index     int
+	path      string
+	line      texter
+	book      bool
+	failed    bool
+	unchanged bool
+	err       error
+	// entry is the cache entry of a book read successfully during an incremental scan
+	entry *cacheEntry
+}
+
+func scan(ctx context.Context, scanPath string, opts ScanOptions) (scanStats, error) {
+	var stats scanStats
+
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	if opts.Hash == "" {
+		opts.Hash = HashSHA256
+	}
+	if !validHash(opts.Hash) {
+		return stats, fmt.Errorf("unknown hash algorithm '%s'", opts.Hash)
+	}
+	if opts.Jobs <= 0 {
+		opts.Jobs = runtime.NumCPU()
+	}
+	out := newPrinter(opts.Output, opts.Format)
+
 	abs, err := filepath.Abs(scanPath)
 	if err != nil {
-		return err
+		return stats, err
 	}
-
-	return filepath.WalkDir(abs, func(path string, d os.DirEntry, err error) error {
+	var cache *scanCache
+	if opts.Cache != "" {
+		cache, err = loadCache(opts.Cache)
 		if err != nil {
-			fmt.Println(errorLine(abs, path, err))
-			return nil
+			return stats, err
 		}
-		if d.IsDir() {
+		opts.Logger.Debug("loaded scan cache", "path", opts.Cache, "books", len(cache.Files))
+	}
+	opts.Logger.Info("scanning", "root", abs, "jobs", opts.Jobs)
+
+	// Walk the tree and queue every book file found
+	jobs := make(chan scanJob)
+	go func() {
+		defer close(jobs)
+		index := 0
+		filepath.WalkDir(abs, func(path string, d os.DirEntry, err error) error {
+			if err == nil && (d.IsDir() || !isBookFile(path)) {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			select {
+			case jobs <- scanJob{index: index, path: path, err: err}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			index++
 			return nil
+		})
+	}()
+
+	// Read the books concurrently, reads holding a slot per book being read
+	results := make(chan scanResult)
+	reads := make(chan struct{}, opts.Jobs)
+	var wg sync.WaitGroup
+	for range opts.Jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- scanOne(ctx, abs, job, opts, cache, reads)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Report the results, in the walk order when requested
+	var printErr error
+	emit := func(line texter) {
+		// Keep consuming the results after a write error so that the workers can finish
+		if printErr == nil {
+			printErr = out.print(line)
 		}
-		if !archives.IsValidBookFile(path) {
-			return nil
+	}
+
+	seen := map[string]bool{}
+	var unreadable []string
+	report := func(r scanResult) {
+		// Books interrupted by the cancellation of the scan are not failures
+		if ctx.Err() != nil && r.err != nil && errors.Is(r.err, ctx.Err()) {
+			return
 		}
 
-		line, err := scanBook(abs, path)
-		if err != nil {
-			fmt.Println(errorLine(abs, path, err))
-			return nil
+		if r.book {
+			stats.Scanned++
+		}
+		if r.failed {
+			stats.Failed++
+		}
+		if r.unchanged {
+			stats.Unchanged++
+		}
+
+		if cache != nil {
+			seen[r.path] = true
+			switch {
+			case r.entry != nil:
+				cache.set(r.path, *r.entry)
+			case r.failed && r.book:
+				// Read the book again on the next scan
+				cache.delete(r.path)
+			case r.failed:
+				// The walk failed, whatever was cached below is not known to be gone
+				unreadable = append(unreadable, r.path)
+			}
 		}
-		fmt.Println(line)
-		return nil
+
+		if !r.unchanged || !opts.SkipUnchanged {
+			emit(r.line)
+		}
+	}
+
+	pending := map[int]scanResult{}
+	next := 0
+	for r := range results {
+		if !opts.Ordered {
+			report(r)
+			continue
+		}
+
+		pending[r.index] = r
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			report(r)
+			next++
+		}
+	}
+
+	// An interrupted walk did not see every book, none can be reported as removed
+	if cache != nil && ctx.Err() == nil {
+		for _, path := range removedBooks(cache, abs, seen, unreadable) {
+			cache.delete(path)
+			stats.Removed++
+			emit(removedMetadata{Path: relPath(abs, path), Status: "removed"})
+		}
+	}
+	// The books read before an interruption are kept for the next scan
+	if cache != nil {
+		if err := cache.save(); err != nil && printErr == nil {
+			printErr = err
+		}
+	}
+
+	opts.Logger.Info("scan complete", "books", stats.Scanned, "failed", stats.Failed,
+		"unchanged", stats.Unchanged, "removed", stats.Removed)
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	return stats, printErr
+}
+
+// removedBooks returns the sorted paths of the books cached below root that
+// were not seen during the scan, ignoring the folders that could not be read
+func removedBooks(cache *scanCache, root string, seen map[string]bool, unreadable []string) []string {
+	var removed []string
+	for _, path := range cache.under(root) {
+		if seen[path] || slices.ContainsFunc(unreadable, func(dir string) bool {
+			return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+		}) {
+			continue
+		}
+		removed = append(removed, path)
+	}
+	slices.Sort(removed)
+	return removed
+}
+
+// isBookFile checks the extension of a file, files without extension are
+// recognized from their content
+func isBookFile(path string) bool {
+	if archives.IsValidBookFile(path) {
+		return true
+	}
+	if filepath.Ext(path) != "" {
+		return false
+	}
+	d, err := archives.Detect(path)
+	return err == nil && d.Handler != nil
+}
+
+// scanOne reads a single book found while walking root, cache is nil unless
+// the scan is incremental
+func scanOne(ctx context.Context, root string, job scanJob, opts ScanOptions, cache *scanCache, reads chan struct{}) scanResult {
+	result := scanResult{index: job.index, path: job.path}
+	if job.err != nil {
+		result.line = errorLine(root, job.path, job.err)
+		result.failed = true
+		return result
+	}
+	result.book = true
+	fail := func(err error) scanResult {
+		result.line = errorLine(root, job.path, err)
+		result.failed = true
+		result.err = err
+		return result
+	}
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+
+	// Stat before reading, a book modified while being read is read again next time
+	info, err := os.Stat(job.path)
+	if err != nil {
+		return fail(err)
+	}
+
+	if cache != nil {
+		if entry, ok := cache.get(job.path); ok && entry.matches(info, opts.Hash) {
+			opts.Logger.Debug("book unchanged", "path", job.path)
+			result.line = bookLine(root, job.path, "unchanged", entry)
+			result.unchanged = true
+			return result
+		}
+	}
+
+	// Wait for a read abandoned on timeout to finish before starting another
+	// one, without counting the wait in the timeout of the book
+	select {
+	case reads <- struct{}{}:
+	case <-ctx.Done():
+		return fail(ctx.Err())
+	}
+
+	opts.Logger.Debug("reading book", "path", job.path)
+	bookCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		bookCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	entry, err := scanBook(bookCtx, reads, func(ctx context.Context) (cacheEntry, error) {
+		return readBook(ctx, job.path, info, opts.Hash)
 	})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			opts.Logger.Warn("book timed out", "path", job.path, "timeout", opts.Timeout)
+			err = fmt.Errorf("timed out after %s: %w", opts.Timeout, err)
+		}
+		return fail(err)
+	}
+	if slices.Contains(entry.Warnings, WarningFormatMismatch) {
+		opts.Logger.Warn("book content does not match its extension", "path", job.path, "format", entry.Format)
+	}
+	result.line = bookLine(root, job.path, "success", entry)
+	result.entry = &entry
+	return result
 }
 
-func scanBook(root string, path string) (string, error) {
-	book, err := archives.GetBookInfo(path)
+// scanBook runs read, which holds a slot of reads, in its own goroutine so
+// that a worker is released as soon as ctx is done even if the format cannot
+// be interrupted, e.g. while unarr decompresses a RAR entry or PDFium renders
+// a page: the read then finishes in the background and only frees its slot,
+// and the PDFium instance or the archive it uses, once done.
+func scanBook(ctx context.Context, reads chan struct{}, read func(context.Context) (cacheEntry, error)) (cacheEntry, error) {
+	type bookResult struct {
+		entry cacheEntry
+		err   error
+	}
+	done := make(chan bookResult, 1)
+	go func() {
+		defer func() { <-reads }()
+		entry, err := read(ctx)
+		done <- bookResult{entry: entry, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.entry, r.err
+	case <-ctx.Done():
+		return cacheEntry{}, ctx.Err()
+	}
+}
+
+// readBook reads the metadata of a book and fingerprints its content
+func readBook(ctx context.Context, path string, info os.FileInfo, hashAlgorithm string) (cacheEntry, error) {
+	detection, err := archives.Detect(path)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	book, err := archives.GetBookInfoContext(ctx, path)
 	if err != nil {
-		return "", err
+		return cacheEntry{}, err
 	}
-	info, err := os.Stat(path)
+	hash, err := hashFile(ctx, path, hashAlgorithm)
 	if err != nil {
-		return "", err
+		return cacheEntry{}, err
 	}
+
+	entry := newCacheEntry(info)
+	entry.Hash = hash
+	entry.HashAlgorithm = hashAlgorithm
+	entry.Book = book
+	if detection.Handler != nil {
+		entry.Format = detection.Handler.Name()
+	}
+	if detection.Mismatch {
+		entry.Warnings = []string{WarningFormatMismatch}
+	}
+	return entry, nil
+}
+
+func bookLine(root, path, status string, entry cacheEntry) metadata {
 	m := metadata{
-		Path:   relPath(root, path),
-		Status: "success",
-		Hash:   "",
-		Size:   info.Size(),
-		Book:   book,
+		Path:     relPath(root, path),
+		Status:   status,
+		Hash:     entry.Hash,
+		Size:     entry.Size,
+		Format:   entry.Format,
+		Warnings: entry.Warnings,
+		Book:     entry.Book,
 	}
-	b, err := json.Marshal(m)
-	if err != nil {
-		return "", err
+	if entry.HashAlgorithm != HashNone {
+		m.HashAlgorithm = entry.HashAlgorithm
 	}
-	return string(b), nil
+	return m
 }
 
-func errorLine(root, path string, e error) string {
-	m := errorMetadata{
+func errorLine(root, path string, e error) errorMetadata {
+	return errorMetadata{
 		Path:   relPath(root, path),
 		Status: "failed",
 		Error:  e.Error(),
 	}
-	b, _ := json.Marshal(m)
-	return string(b)
 }
 
 func relPath(root, p string) string {
@@ -0,0 +1,293 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createLibrary copies every fixture the given number of times in a new folder
+func createLibrary(tb testing.TB, copies int) (string, int) {
+	tb.Helper()
+
+	fixtures, err := filepath.Glob(filepath.Join("..", "..", "fixtures", "*.*"))
+	require.NoError(tb, err)
+
+	root := tb.TempDir()
+	books := 0
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture)
+		require.NoError(tb, err)
+		for i := range copies {
+			dir := filepath.Join(root, fmt.Sprintf("shelf%02d", i))
+			require.NoError(tb, os.MkdirAll(dir, 0755))
+			require.NoError(tb, os.WriteFile(filepath.Join(dir, filepath.Base(fixture)), data, 0644))
+			books++
+		}
+	}
+	return root, books
+}
+
+// scanPaths runs a scan and returns the reported paths, in output order
+func scanPaths(t *testing.T, root string, opts ScanOptions) []string {
+	t.Helper()
+
+	var out bytes.Buffer
+	opts.Output = &out
+	stats, err := scan(t.Context(), root, opts)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Failed, "no book should fail")
+
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var m metadata
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		assert.Equal(t, "success", m.Status, "%s should be read", m.Path)
+		paths = append(paths, m.Path)
+	}
+	return paths
+}
+
+func TestScanParallel(t *testing.T) {
+	root, books := createLibrary(t, 4)
+	require.NoError(t, archives.SetPDFiumPoolSize(4))
+	t.Cleanup(func() { archives.SetPDFiumPoolSize(1) })
+
+	serial := scanPaths(t, root, ScanOptions{Jobs: 1, Hash: HashNone})
+	assert.Len(t, serial, books, "should report every book")
+	assert.True(t, sort.StringsAreSorted(serial), "a serial scan reports books in walk order")
+
+	parallel := scanPaths(t, root, ScanOptions{Jobs: 8, Hash: HashNone})
+	assert.ElementsMatch(t, serial, parallel, "a parallel scan should report the same books")
+
+	ordered := scanPaths(t, root, ScanOptions{Jobs: 8, Ordered: true, Hash: HashNone})
+	assert.Equal(t, serial, ordered, "an ordered parallel scan should report books in walk order")
+}
+
+func TestScanParallelFailures(t *testing.T) {
+	root, _ := createLibrary(t, 2)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "broken.pdf"), []byte("not a PDF"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "broken.cbz"), []byte("not a ZIP"), 0644))
+
+	var out bytes.Buffer
+	stats, err := scan(t.Context(), root, ScanOptions{Output: &out, Jobs: 4, Ordered: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Failed, "should count the broken books")
+	assert.Equal(t, 10, stats.Scanned, "should count every book")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Contains(t, lines[0], `"path":"broken.cbz","status":"failed"`)
+	assert.Contains(t, lines[1], `"path":"broken.pdf","status":"failed"`)
+}
+
+func TestScanWithOptionsErrors(t *testing.T) {
+	err := ScanWithOptions(t.TempDir(), ScanOptions{Output: io.Discard, Hash: "md5"})
+	assert.Error(t, err, "should reject unknown hash algorithms")
+}
+
+// BenchmarkScan measures the scan throughput depending on the number of jobs.
+// Run with: go test ./src/commands -run '^$' -bench Scan
+func BenchmarkScan(b *testing.B) {
+	root, books := createLibrary(b, 8)
+
+	jobs := []int{1, 2, 4, runtime.NumCPU()}
+	for _, n := range jobs {
+		b.Run(fmt.Sprintf("jobs=%d", n), func(b *testing.B) {
+			require.NoError(b, archives.SetPDFiumPoolSize(n))
+			b.ResetTimer()
+
+			for b.Loop() {
+				_, err := scan(b.Context(), root, ScanOptions{Output: io.Discard, Jobs: n})
+				require.NoError(b, err)
+			}
+
+			b.ReportMetric(float64(books*b.N)/b.Elapsed().Seconds(), "books/s")
+		})
+	}
+	require.NoError(b, archives.SetPDFiumPoolSize(1))
+}
+
+// scanStatuses runs a scan and returns the status reported for each path
+func scanStatuses(t *testing.T, root string, opts ScanOptions) map[string]string {
+	t.Helper()
+
+	var out bytes.Buffer
+	opts.Output = &out
+	_, err := scan(t.Context(), root, opts)
+	require.NoError(t, err)
+
+	statuses := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m metadata
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		statuses[m.Path] = m.Status
+	}
+	return statuses
+}
+
+func TestScanIncremental(t *testing.T) {
+	root, _ := createLibrary(t, 1)
+	opts := ScanOptions{Cache: filepath.Join(t.TempDir(), cacheFileName)}
+
+	statuses := scanStatuses(t, root, opts)
+	assert.Equal(t, "success", statuses["shelf00/testfile.pdf"], "the first scan reads every book")
+
+	// Unchanged tree
+	statuses = scanStatuses(t, root, opts)
+	for path, status := range statuses {
+		assert.Equal(t, "unchanged", status, "%s should be unchanged", path)
+	}
+
+	// Modified, removed and new books
+	modified := filepath.Join(root, "shelf00", "testfile.pdf")
+	info, err := os.Stat(modified)
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(modified, info.ModTime(), info.ModTime().Add(time.Minute)))
+	require.NoError(t, os.Remove(filepath.Join(root, "shelf00", "pg11-images-3.epub")))
+	data, err := os.ReadFile(modified)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "new.pdf"), data, 0644))
+
+	statuses = scanStatuses(t, root, opts)
+	assert.Equal(t, "success", statuses["shelf00/testfile.pdf"], "should read modified books again")
+	assert.Equal(t, "removed", statuses["shelf00/pg11-images-3.epub"], "should report removed books")
+	assert.Equal(t, "success", statuses["new.pdf"], "should read new books")
+
+	// Removed books are only reported once
+	opts.SkipUnchanged = true
+	statuses = scanStatuses(t, root, opts)
+	assert.Empty(t, statuses, "should not report anything for an unchanged tree")
+}
+
+func TestScanIncrementalHash(t *testing.T) {
+	root, _ := createLibrary(t, 1)
+	opts := ScanOptions{Cache: filepath.Join(t.TempDir(), cacheFileName), Hash: HashNone}
+	scanStatuses(t, root, opts)
+
+	opts.Hash = HashXXH64
+	statuses := scanStatuses(t, root, opts)
+	for path, status := range statuses {
+		assert.Equal(t, "success", status, "%s should be read again to compute the new hash", path)
+	}
+}
+
+func TestScanIncrementalSubfolder(t *testing.T) {
+	root, _ := createLibrary(t, 2)
+	opts := ScanOptions{Cache: filepath.Join(t.TempDir(), cacheFileName), SkipUnchanged: true}
+	scanStatuses(t, root, opts)
+
+	statuses := scanStatuses(t, filepath.Join(root, "shelf01"), opts)
+	assert.Empty(t, statuses, "books outside of the scanned folder should not be reported as removed")
+}
+
+func TestScanTimeout(t *testing.T) {
+	root, books := createLibrary(t, 1)
+
+	var out bytes.Buffer
+	stats, err := scan(t.Context(), root, ScanOptions{Output: &out, Timeout: time.Nanosecond})
+	require.NoError(t, err)
+	assert.Equal(t, books, stats.Failed, "every book should time out")
+
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var m errorMetadata
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		assert.Equal(t, "failed", m.Status)
+		assert.Equal(t, "timed out after 1ns: context deadline exceeded", m.Error)
+	}
+}
+
+func TestScanBookAbandoned(t *testing.T) {
+	reads := make(chan struct{}, 1)
+	reads <- struct{}{}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	finish := make(chan struct{})
+	_, err := scanBook(ctx, reads, func(context.Context) (cacheEntry, error) {
+		<-finish
+		return cacheEntry{}, nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	select {
+	case reads <- struct{}{}:
+		t.Fatal("an abandoned read should hold its slot until it finishes")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(finish)
+	select {
+	case reads <- struct{}{}:
+	case <-time.After(time.Second):
+		t.Fatal("a finished read should free its slot")
+	}
+}
+
+func TestScanContextCancelled(t *testing.T) {
+	root, _ := createLibrary(t, 1)
+	cache := filepath.Join(t.TempDir(), cacheFileName)
+	_, err := scan(t.Context(), root, ScanOptions{Output: io.Discard, Cache: cache})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	var out bytes.Buffer
+	err = ScanContext(ctx, root, ScanOptions{Output: &out, Cache: cache})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, out.String(), "should neither report interrupted books nor removed ones")
+}
+
+func TestScanFormatMismatch(t *testing.T) {
+	root := t.TempDir()
+	fixtures := filepath.Join("..", "..", "fixtures")
+	copyFile := func(src, dst string) {
+		data, err := os.ReadFile(filepath.Join(fixtures, src))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(root, dst), data, 0644))
+	}
+	copyFile("dummy_book.cbz", "book.cbz")
+	copyFile("dummy_book.cbz", "zip.cbr")
+	copyFile("testfile.pdf", "upload")
+	require.NoError(t, os.WriteFile(filepath.Join(root, "README"), []byte("not a book"), 0644))
+
+	var out bytes.Buffer
+	_, err := scan(t.Context(), root, ScanOptions{Output: &out, Hash: HashNone})
+	require.NoError(t, err)
+
+	books := map[string]metadata{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var m metadata
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		books[m.Path] = m
+	}
+	require.Len(t, books, 3, "extensionless files should be recognized from their content")
+
+	assert.Equal(t, "cbz", books["book.cbz"].Format)
+	assert.Empty(t, books["book.cbz"].Warnings)
+
+	assert.Equal(t, "success", books["zip.cbr"].Status)
+	assert.Equal(t, "cbz", books["zip.cbr"].Format)
+	assert.Equal(t, []string{WarningFormatMismatch}, books["zip.cbr"].Warnings)
+
+	assert.Equal(t, "success", books["upload"].Status)
+	assert.Equal(t, "pdf", books["upload"].Format)
+	assert.Equal(t, "Title of the Book", books["upload"].Book.Title)
+	assert.Empty(t, books["upload"].Warnings, "a file without extension cannot mismatch")
+}
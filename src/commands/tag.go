@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/biblioteca/bookkeeper/src/archives"
+)
+
+// tagResult is reported once the metadata of a book has been written
+type tagResult struct {
+	Path   string            `json:"path"`
+	Status string            `json:"status"`
+	Book   archives.BookInfo `json:"book"`
+}
+
+func (r tagResult) text() string {
+	return fmt.Sprintf("Metadata written to %s: %s (%d pages)", r.Path, r.Book.Title, r.Book.Pages)
+}
+
+// tagChange updates a single field of the metadata of a book
+type tagChange func(info *archives.BookInfo)
+
+// tagBook applies the changes to the metadata of a book, read from the book
+// itself or from the JSON file from, and writes it back into the book
+func tagBook(ctx context.Context, path, from string, changes []tagChange) (tagResult, error) {
+	var info archives.BookInfo
+	if from != "" {
+		data, err := os.ReadFile(from)
+		if err != nil {
+			return tagResult{}, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		if err := json.Unmarshal(data, &info); err != nil {
+			return tagResult{}, fmt.Errorf("failed to parse metadata '%s': %w", from, err)
+		}
+	} else {
+		var err error
+		if info, err = archives.GetBookInfoContext(ctx, path); err != nil {
+			return tagResult{}, fmt.Errorf("failed to read metadata: %w", err)
+		}
+	}
+
+	for _, change := range changes {
+		change(&info)
+	}
+
+	if err := archives.WriteBookInfoContext(ctx, path, info); err != nil {
+		return tagResult{}, fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return tagResult{Path: path, Status: "success", Book: info}, nil
+}
+
+// splitList splits a comma-separated flag value, an empty value clears the list
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}